@@ -0,0 +1,23 @@
+package app
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authorizeAdmin reports whether the request carries the admin token as a bearer token,
+// and writes the error response otherwise. Admin routes are disabled unless ADMIN_TOKEN is set.
+func (s *Handlers) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		writeJSONError(w, r, http.StatusNotFound, "admin API is disabled; set ADMIN_TOKEN to enable it")
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeJSONError(w, r, http.StatusUnauthorized, "invalid admin token")
+		return false
+	}
+	return true
+}
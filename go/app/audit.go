@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Actions of item events.
+const (
+	itemEventCreate  = "create"
+	itemEventUpdate  = "update"
+	itemEventDelete  = "delete"
+	itemEventRestore = "restore"
+)
+
+// ItemEvent is a change of an item in the audit trail. ItemRepository writes one for every item it changes,
+// in the same transaction as the change.
+type ItemEvent struct {
+	ID     int `json:"id"`
+	ItemID int `json:"item_id"`
+	// Action is create, update, delete or restore.
+	Action string `json:"action"`
+	// Actor is who made the change; see WithActor.
+	Actor string `json:"actor"`
+	// RequestID is the ID of the API request that made the change, to find it in the logs.
+	RequestID string `json:"request_id,omitempty"`
+	// Before and After are the item as JSON before and after the change.
+	// Before is null for create and restore, and After is null for delete.
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose changes to items are recorded as made by actor,
+// e.g. "api" for API requests, "admin" for the admin API and the name of a command.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFromContext returns the actor set by WithActor, or "system" if none is set.
+func actorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "system"
+}
+
+// newItemEvent returns the event of a change of the item by the actor of ctx. before or after may be nil.
+func newItemEvent(ctx context.Context, itemID int, action string, before, after *Item) (*ItemEvent, error) {
+	event := &ItemEvent{
+		ItemID:    itemID,
+		Action:    action,
+		Actor:     actorFromContext(ctx),
+		RequestID: requestIDFromContext(ctx),
+		CreatedAt: time.Now().UTC(),
+	}
+	var err error
+	if before != nil {
+		if event.Before, err = json.Marshal(before); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if event.After, err = json.Marshal(after); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
+
+// recordItemEvent writes the event of a change of the item in the transaction of the change.
+func recordItemEvent(ctx context.Context, tx querier, itemID int, action string, before, after *Item) error {
+	event, err := newItemEvent(ctx, itemID, action, before, after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO item_events (item_id, action, actor, request_id, before_json, after_json, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, event.ItemID, event.Action, event.Actor, nullString(event.RequestID),
+		nullString(string(event.Before)), nullString(string(event.After)), event.CreatedAt)
+	return err
+}
+
+// nullString stores an empty string as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+type ItemHistoryResponse struct {
+	Events []*ItemEvent `json:"events"`
+}
+
+// GetItemHistory is a handler to return the changes of an item, including deleted items, for GET /items/{item_id}/history .
+// Items added before the audit trail existed have no events until they are changed.
+func (s *Handlers) GetItemHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	itemID, err := strconv.Atoi(r.PathValue("item_id"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "item_id must be an integer")
+		return
+	}
+	events, err := s.itemRepo.History(ctx, itemID)
+	if err != nil {
+		slog.Error("failed to get item history", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "failed to get item history")
+		return
+	}
+	if len(events) == 0 {
+		// an item without events exists only if it was added before the audit trail
+		if _, err := s.itemRepo.GetByID(ctx, itemID); errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, r, http.StatusNotFound, "item not found")
+			return
+		} else if err != nil {
+			slog.Error("failed to get item", "error", err)
+			writeJSONError(w, r, http.StatusInternalServerError, "failed to get item history")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(ItemHistoryResponse{Events: events}); err != nil {
+		slog.Error("failed to write item history", "error", err)
+	}
+}
+
+// RestoreItem is a handler to restore a deleted item for POST /admin/items/{item_id}/restore .
+// It requires the admin token, and the restore is recorded as made by "admin".
+func (s *Handlers) RestoreItem(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	ctx := WithActor(r.Context(), "admin")
+
+	itemID, err := strconv.Atoi(r.PathValue("item_id"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "item_id must be an integer")
+		return
+	}
+	item, err := s.itemRepo.Restore(ctx, itemID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, r, http.StatusNotFound, "item not found")
+		return
+	case errors.Is(err, errItemNotDeleted):
+		writeJSONError(w, r, http.StatusConflict, "item is not deleted")
+		return
+	case err != nil:
+		slog.Error("failed to restore item", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "failed to restore item")
+		return
+	}
+	slog.Info("restored item", "item_id", item.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", itemETag(item))
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		slog.Error("failed to write item", "error", err)
+	}
+}
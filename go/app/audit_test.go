@@ -0,0 +1,223 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGetItemHistory(t *testing.T) {
+	t.Parallel()
+
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion", Image: defaultImageName}
+	event := &ItemEvent{ID: 1, ItemID: 1, Action: itemEventCreate, Actor: "api", After: json.RawMessage(`{"id":1}`)}
+
+	cases := map[string]struct {
+		itemID   string
+		injector func(m *MockItemRepository)
+		wantCode int
+		wantIDs  []int
+	}{
+		"ok: events": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().History(gomock.Any(), 1).Return([]*ItemEvent{event}, nil)
+			},
+			wantCode: http.StatusOK,
+			wantIDs:  []int{1},
+		},
+		"ok: item added before the audit trail": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().History(gomock.Any(), 1).Return(nil, nil)
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil)
+			},
+			wantCode: http.StatusOK,
+			wantIDs:  []int{},
+		},
+		"ng: item not found": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().History(gomock.Any(), 1).Return(nil, nil)
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(nil, sql.ErrNoRows)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		"ng: invalid item id": {
+			itemID:   "jacket",
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/"+tt.itemID+"/history", nil)
+			req.SetPathValue("item_id", tt.itemID)
+			res := httptest.NewRecorder()
+			h.GetItemHistory(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+			var body ItemHistoryResponse
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode history: %v", err)
+			}
+			ids := []int{}
+			for _, e := range body.Events {
+				ids = append(ids, e.ID)
+			}
+			if diff := cmp.Diff(tt.wantIDs, ids); diff != "" {
+				t.Errorf("unexpected events (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRestoreItem(t *testing.T) {
+	t.Parallel()
+
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion", Image: defaultImageName}
+
+	cases := map[string]struct {
+		authorization string
+		injector      func(m *MockItemRepository)
+		wantCode      int
+	}{
+		"ok: restored by admin": {
+			authorization: "Bearer secret",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Restore(gomock.Any(), 1).DoAndReturn(func(ctx context.Context, id int) (*Item, error) {
+					if actor := actorFromContext(ctx); actor != "admin" {
+						t.Errorf("unexpected actor. want=admin, got=%s", actor)
+					}
+					return item, nil
+				})
+			},
+			wantCode: http.StatusOK,
+		},
+		"ng: no token": {
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusUnauthorized,
+		},
+		"ng: item not found": {
+			authorization: "Bearer secret",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Restore(gomock.Any(), 1).Return(nil, sql.ErrNoRows)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		"ng: item not deleted": {
+			authorization: "Bearer secret",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Restore(gomock.Any(), 1).Return(nil, errItemNotDeleted)
+			},
+			wantCode: http.StatusConflict,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR, adminToken: "secret"}
+
+			req := httptest.NewRequest("POST", "/admin/items/1/restore", nil)
+			req.SetPathValue("item_id", "1")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res := httptest.NewRecorder()
+			h.RestoreItem(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+// TestItemHistoryE2e checks that changes through the API are recorded with the actor and the request ID.
+func TestItemHistoryE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	dir := t.TempDir()
+	handler, closeStores, err := Server{ImageDirPath: dir, DBPath: filepath.Join(dir, "mercari.sqlite3")}.Handler(context.Background())
+	if err != nil {
+		t.Fatalf("failed to set up server: %v", err)
+	}
+	t.Cleanup(func() { closeStores() })
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	req, _ := http.NewRequest("POST", srv.URL+"/items", strings.NewReader(url.Values{"name": {"jacket"}, "category": {"fashion"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Request-ID", "add-jacket")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to add item: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code. want=%d, got=%d", http.StatusOK, res.StatusCode)
+	}
+
+	res, err = http.Get(srv.URL + "/items/1/history")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	var body ItemHistoryResponse
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to decode history: %v", err)
+	}
+	if len(body.Events) != 1 {
+		t.Fatalf("unexpected number of events. want=1, got=%d", len(body.Events))
+	}
+	e := body.Events[0]
+	if e.Action != itemEventCreate || e.Actor != "api" || e.RequestID != "add-jacket" {
+		t.Errorf("unexpected event: action=%s, actor=%s, request_id=%s", e.Action, e.Actor, e.RequestID)
+	}
+
+	for authorization, want := range map[string]int{"Bearer secret": http.StatusConflict, "": http.StatusUnauthorized} {
+		req, _ := http.NewRequest("POST", srv.URL+"/admin/items/1/restore", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to restore item: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("unexpected status code of restore with %q. want=%d, got=%d", authorization, want, res.StatusCode)
+		}
+	}
+}
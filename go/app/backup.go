@@ -0,0 +1,475 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupFormat is the version of the archive layout written by Backup.
+const backupFormat = 1
+
+// Entries of a backup archive.
+const (
+	backupDatabaseName = "mercari.sqlite3"
+	backupManifestName = "manifest.json"
+	backupImagePrefix  = "images/"
+)
+
+// BackupManifest describes the content of a backup archive.
+// It is the last entry of the archive, so that an archive cut short has no manifest and is refused.
+type BackupManifest struct {
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	// SchemaVersion is the schema version of the database.
+	SchemaVersion int        `json:"schema_version"`
+	Items         int        `json:"items"`
+	Database      BackupFile `json:"database"`
+	// Images are the images referred to by items in the database, sorted by name.
+	Images []BackupFile `json:"images"`
+	// MissingImages are referred to by items but were not in the image directory.
+	MissingImages []string `json:"missing_images"`
+}
+
+// BackupFile is a file in a backup archive.
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup writes a tar archive of a snapshot of the database and the images its items refer to.
+// The snapshot is taken with VACUUM INTO, which copies the database in a single read transaction
+// while the server keeps running. db must accept writes, since read-only connections refuse VACUUM INTO;
+// on the write pool of the server, writes wait for the copy but not for the archive to be sent.
+// Images are read after the snapshot: uploaded images are never changed, and an image deleted
+// in between is listed in MissingImages.
+func Backup(ctx context.Context, db *sql.DB, imgDirPath string, w io.Writer) (*BackupManifest, error) {
+	dir, err := os.MkdirTemp("", "mercari-backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshotPath := filepath.Join(dir, backupDatabaseName)
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, snapshotPath); err != nil {
+		return nil, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	manifest, referenced, err := inspectBackupDatabase(ctx, snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(w)
+	manifest.Database, err = writeBackupFile(tw, snapshotPath, backupDatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range referenced {
+		if !isBackupImageName(name) {
+			manifest.MissingImages = append(manifest.MissingImages, name)
+			continue
+		}
+		file, err := writeBackupFile(tw, filepath.Join(imgDirPath, name), backupImagePrefix+name)
+		if errors.Is(err, os.ErrNotExist) {
+			manifest.MissingImages = append(manifest.MissingImages, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		file.Name = name
+		manifest.Images = append(manifest.Images, file)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	hdr := &tar.Header{Name: backupManifestName, Mode: 0644, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// inspectBackupDatabase returns the manifest of the database at path without its files,
+// and the images referred to by its items sorted by name.
+func inspectBackupDatabase(ctx context.Context, path string) (*BackupManifest, []string, error) {
+	db, err := sql.Open(sqliteDriver, sqliteDSN(sqliteDriver, path, defaultSQLitePragmas, true))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	manifest := &BackupManifest{
+		Format:        backupFormat,
+		CreatedAt:     time.Now().UTC().Truncate(time.Second),
+		Images:        []BackupFile{},
+		MissingImages: []string{},
+	}
+	if manifest.SchemaVersion, err = schemaVersion(ctx, db); err != nil {
+		return nil, nil, err
+	}
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM items`).Scan(&manifest.Items); err != nil {
+		return nil, nil, fmt.Errorf("failed to count items: %w", err)
+	}
+	counts, err := NewItemRepositoryWithDB(db).ReferencedImages(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list referenced images: %w", err)
+	}
+	var referenced []string
+	for name := range counts {
+		if name != "" {
+			referenced = append(referenced, name)
+		}
+	}
+	slices.Sort(referenced)
+	return manifest, referenced, nil
+}
+
+// writeBackupFile adds the file at path to the archive as name.
+func writeBackupFile(tw *tar.Writer, path, name string) (BackupFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return BackupFile{}, err
+	}
+
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return BackupFile{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(tw, io.TeeReader(f, h)); err != nil {
+		return BackupFile{}, fmt.Errorf("failed to archive %s: %w", path, err)
+	}
+	return BackupFile{Name: name, Size: info.Size(), SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// isBackupImageName reports whether name is a file name that can be archived under backupImagePrefix,
+// and extracted into the image directory without escaping it.
+func isBackupImageName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`) &&
+		(strings.HasSuffix(name, ".jpg") || strings.HasSuffix(name, ".jpeg"))
+}
+
+// RestoreOptions selects how RestoreBackup uses the archive.
+type RestoreOptions struct {
+	// DryRun validates the archive without changing the database or the images.
+	DryRun bool
+}
+
+// RestoreReport is the result of RestoreBackup.
+type RestoreReport struct {
+	Manifest *BackupManifest `json:"manifest"`
+	// RestoredImages is the number of images written to the image directory.
+	// The other images were already there with the same content.
+	RestoredImages int `json:"restored_images"`
+	// PreviousDatabase is where the replaced database was moved. It is empty if there was none.
+	PreviousDatabase string `json:"previous_database"`
+}
+
+// errDatabaseInUse is returned by RestoreBackup if the database may be open in another process.
+var errDatabaseInUse = errors.New("database is in use")
+
+// RestoreBackup validates the archive written by Backup and swaps it in as the database at dbPath
+// and the images in imgDirPath. The archive is extracted next to the database first, and nothing is
+// changed unless every file matches the manifest, the database passes an integrity check and its schema
+// is not newer than this binary supports. The replaced database is kept next to the restored one.
+// The server must be stopped, since its connections would keep using the replaced database;
+// RestoreBackup refuses to run while the write-ahead log of the database exists.
+func RestoreBackup(ctx context.Context, r io.Reader, dbPath, imgDirPath string, opts RestoreOptions) (*RestoreReport, error) {
+	// extracted next to the database, so that it is swapped in by a rename
+	stagingParent := ""
+	if !opts.DryRun {
+		if _, err := os.Stat(dbPath + "-wal"); err == nil {
+			return nil, fmt.Errorf("%w: %s-wal exists; stop the server before restoring", errDatabaseInUse, dbPath)
+		}
+		stagingParent = filepath.Dir(dbPath)
+		if err := os.MkdirAll(stagingParent, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+	staging, err := os.MkdirTemp(stagingParent, ".restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := extractBackup(r, staging)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateBackupDatabase(ctx, filepath.Join(staging, backupDatabaseName), manifest); err != nil {
+		return nil, err
+	}
+	report := &RestoreReport{Manifest: manifest}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	// images first: images no item refers to are harmless if the database cannot be swapped in
+	if err := os.MkdirAll(imgDirPath, 0755); err != nil {
+		return report, fmt.Errorf("failed to create image directory: %w", err)
+	}
+	for _, img := range manifest.Images {
+		restored, err := restoreBackupImage(filepath.Join(staging, backupImagePrefix, img.Name), imgDirPath, img)
+		if err != nil {
+			return report, err
+		}
+		if restored {
+			report.RestoredImages++
+		}
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		report.PreviousDatabase = dbPath + ".before-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		if err := os.Rename(dbPath, report.PreviousDatabase); err != nil {
+			return report, fmt.Errorf("failed to move the database aside: %w", err)
+		}
+	}
+	if err := os.Rename(filepath.Join(staging, backupDatabaseName), dbPath); err != nil {
+		if report.PreviousDatabase != "" {
+			os.Rename(report.PreviousDatabase, dbPath)
+		}
+		return report, fmt.Errorf("failed to swap in the database: %w", err)
+	}
+	return report, nil
+}
+
+// extractBackup extracts the archive into dir and checks every file against the manifest.
+func extractBackup(r io.Reader, dir string) (*BackupManifest, error) {
+	if err := os.Mkdir(filepath.Join(dir, backupImagePrefix), 0755); err != nil {
+		return nil, err
+	}
+
+	var manifest *BackupManifest
+	files := map[string]BackupFile{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %s in archive", hdr.Name)
+		}
+		if _, ok := files[hdr.Name]; ok || (hdr.Name == backupManifestName && manifest != nil) {
+			return nil, fmt.Errorf("duplicate entry %s in archive", hdr.Name)
+		}
+
+		switch image, isImage := strings.CutPrefix(hdr.Name, backupImagePrefix); {
+		case hdr.Name == backupManifestName:
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("failed to read manifest: %w", err)
+			}
+		case hdr.Name == backupDatabaseName || isImage && isBackupImageName(image):
+			file, err := extractBackupFile(tr, filepath.Join(dir, filepath.FromSlash(hdr.Name)))
+			if err != nil {
+				return nil, err
+			}
+			files[hdr.Name] = file
+		default:
+			return nil, fmt.Errorf("unexpected entry %s in archive", hdr.Name)
+		}
+	}
+
+	if manifest == nil {
+		return nil, errors.New("archive has no manifest; it may be cut short")
+	}
+	if manifest.Format != backupFormat {
+		return nil, fmt.Errorf("unsupported archive format %d, want %d", manifest.Format, backupFormat)
+	}
+	check := func(entry string, want BackupFile) error {
+		got, ok := files[entry]
+		if !ok {
+			return fmt.Errorf("%s is in the manifest but not in the archive", entry)
+		}
+		delete(files, entry)
+		if got.Size != want.Size || got.SHA256 != want.SHA256 {
+			return fmt.Errorf("%s does not match the manifest", entry)
+		}
+		return nil
+	}
+	if err := check(backupDatabaseName, manifest.Database); err != nil {
+		return nil, err
+	}
+	for _, img := range manifest.Images {
+		if err := check(backupImagePrefix+img.Name, img); err != nil {
+			return nil, err
+		}
+		if imageRefPattern.MatchString(img.Name) && img.SHA256+".jpg" != img.Name {
+			return nil, fmt.Errorf("content of %s does not match its name", img.Name)
+		}
+	}
+	for entry := range files {
+		return nil, fmt.Errorf("%s is in the archive but not in the manifest", entry)
+	}
+	return manifest, nil
+}
+
+// extractBackupFile writes the current entry of the archive to path.
+func extractBackupFile(tr *tar.Reader, path string) (BackupFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(tr, h))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return BackupFile{}, fmt.Errorf("failed to extract %s: %w", filepath.Base(path), err)
+	}
+	return BackupFile{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// validateBackupDatabase checks that the extracted database is intact, can be migrated by this binary,
+// and that the images of its items are in the archive or were already missing when it was taken.
+func validateBackupDatabase(ctx context.Context, path string, manifest *BackupManifest) error {
+	db, err := sql.Open(sqliteDriver, sqliteDSN(sqliteDriver, path, defaultSQLitePragmas, true))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check(1)`).Scan(&result); err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database is corrupt: %s", result)
+	}
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if version > latestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", version, latestSchemaVersion())
+	}
+
+	referenced, err := NewItemRepositoryWithDB(db).ReferencedImages(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list referenced images: %w", err)
+	}
+	archived := map[string]bool{}
+	for _, img := range manifest.Images {
+		archived[img.Name] = true
+	}
+	for name := range referenced {
+		if name != "" && !archived[name] && !slices.Contains(manifest.MissingImages, name) {
+			return fmt.Errorf("image %s of the items is not in the archive", name)
+		}
+	}
+	return nil
+}
+
+// restoreBackupImage copies the extracted image at src into imgDirPath unless it is already there
+// with the same content. It reports whether the image was written.
+func restoreBackupImage(src, imgDirPath string, img BackupFile) (bool, error) {
+	dst := filepath.Join(imgDirPath, img.Name)
+	if sum, err := fileSHA256(dst); err == nil && sum == img.SHA256 {
+		return false, nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	// written under a temporary name, so that the server never serves a partial image
+	out, err := os.CreateTemp(imgDirPath, ".restore-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to restore %s: %w", img.Name, err)
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return false, fmt.Errorf("failed to restore %s: %w", img.Name, err)
+	}
+	return true, nil
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// BackupDatabase is a handler to download a backup archive of the database and the images for GET /admin/backup .
+// It requires the admin token, and is only available if items are stored in SQLite.
+func (s *Handlers) BackupDatabase(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.db == nil {
+		writeJSONError(w, r, http.StatusNotImplemented, "backups are only available with ITEM_STORE=sqlite")
+		return
+	}
+
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="mercari-backup-%s.tar"`, time.Now().UTC().Format("20060102T150405Z")))
+
+	res := newExportResponse(w)
+	manifest, err := Backup(ctx, s.db, s.imgDirPath, res)
+	if err != nil {
+		slog.Error("failed to back up database", "error", err)
+		if !res.started {
+			w.Header().Del("Content-Disposition")
+			writeJSONError(w, r, http.StatusInternalServerError, "failed to back up database")
+			return
+		}
+		// the status has been sent with the first entries, so abort the response
+		// for the client to see a truncated download instead of a complete archive
+		panic(http.ErrAbortHandler)
+	}
+	slog.Info("backed up database", "items", manifest.Items, "images", len(manifest.Images), "missing_images", len(manifest.MissingImages))
+}
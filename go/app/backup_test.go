@@ -0,0 +1,417 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// backupFixture is a database with items referring to a stored image, the default image and a missing image.
+type backupFixture struct {
+	db     *sqliteDB
+	imgDir string
+	stored string
+}
+
+func newBackupFixture(t *testing.T) *backupFixture {
+	t.Helper()
+
+	db := openTestSQLiteDB(t, sqliteDriver)
+	imgDir := t.TempDir()
+	content := []byte("jacket image")
+	sum := sha256.Sum256(content)
+	stored := hex.EncodeToString(sum[:]) + ".jpg"
+	for name, data := range map[string][]byte{stored: content, defaultImageName: []byte("default image")} {
+		if err := os.WriteFile(filepath.Join(imgDir, name), data, 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	repo := NewItemRepositoryWithPools(db.write, db.read)
+	for _, img := range []string{stored, defaultImageName, strings.Repeat("f", 64) + ".jpg"} {
+		if err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", Image: img}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	return &backupFixture{db: db, imgDir: imgDir, stored: stored}
+}
+
+// rewriteBackup rewrites the entries of the archive with edit, which drops entries by returning an empty name.
+func rewriteBackup(t *testing.T, archive []byte, edit func(name string, data []byte) (string, []byte)) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(archive))
+	tw := tar.NewWriter(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		name, data := edit(hdr.Name, data)
+		if name == "" {
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatalf("failed to write archive: %v", err)
+		}
+		tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBackupRestore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	f := newBackupFixture(t)
+	ctx := context.Background()
+	var archive bytes.Buffer
+	manifest, err := Backup(ctx, f.db.write, f.imgDir, &archive)
+	if err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	if manifest.Items != 3 || manifest.SchemaVersion != latestSchemaVersion() {
+		t.Errorf("unexpected manifest: %d items at schema version %d", manifest.Items, manifest.SchemaVersion)
+	}
+	var images []string
+	for _, img := range manifest.Images {
+		images = append(images, img.Name)
+	}
+	if diff := cmp.Diff([]string{f.stored, defaultImageName}, images); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{strings.Repeat("f", 64) + ".jpg"}, manifest.MissingImages); diff != "" {
+		t.Errorf("unexpected missing images (-want +got):\n%s", diff)
+	}
+
+	// restore into another server whose database has diverged
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "mercari.sqlite3")
+	imgDir := filepath.Join(dir, "images")
+	if err := os.WriteFile(dbPath, []byte("previous"), 0644); err != nil {
+		t.Fatalf("failed to write database: %v", err)
+	}
+
+	if _, err := RestoreBackup(ctx, bytes.NewReader(archive.Bytes()), dbPath, imgDir, RestoreOptions{DryRun: true}); err != nil {
+		t.Fatalf("failed to validate archive: %v", err)
+	}
+	if _, err := os.Stat(imgDir); !os.IsNotExist(err) {
+		t.Errorf("expected a dry run not to create the image directory: %v", err)
+	}
+
+	if err := os.WriteFile(dbPath+"-wal", nil, 0644); err != nil {
+		t.Fatalf("failed to write log: %v", err)
+	}
+	if _, err := RestoreBackup(ctx, bytes.NewReader(archive.Bytes()), dbPath, imgDir, RestoreOptions{}); err == nil {
+		t.Errorf("expected the restore to refuse a database in use")
+	}
+	os.Remove(dbPath + "-wal")
+
+	report, err := RestoreBackup(ctx, bytes.NewReader(archive.Bytes()), dbPath, imgDir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if report.RestoredImages != 2 {
+		t.Errorf("unexpected number of restored images. want=2, got=%d", report.RestoredImages)
+	}
+	if previous, err := os.ReadFile(report.PreviousDatabase); err != nil || string(previous) != "previous" {
+		t.Errorf("expected the previous database to be kept: %q, %v", previous, err)
+	}
+	for _, img := range []string{f.stored, defaultImageName} {
+		if _, err := os.Stat(filepath.Join(imgDir, img)); err != nil {
+			t.Errorf("expected %s to be restored: %v", img, err)
+		}
+	}
+
+	db, err := OpenDB(ctx, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open restored database: %v", err)
+	}
+	defer db.Close()
+	items, err := NewItemRepositoryWithDB(db).GetAll(ctx)
+	if err != nil {
+		t.Fatalf("failed to get items: %v", err)
+	}
+	if len(items) != 3 {
+		t.Errorf("unexpected number of restored items. want=3, got=%d", len(items))
+	}
+}
+
+// TestOpenDBNoMigrate checks that the database the backup command opens is left as the server expects it.
+func TestOpenDBNoMigrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	if _, err := OpenDBNoMigrate(ctx, filepath.Join(dir, "missing.sqlite3")); err == nil {
+		t.Errorf("expected an error for a missing database")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.sqlite3")); !os.IsNotExist(err) {
+		t.Errorf("expected the missing database not to be created: %v", err)
+	}
+
+	dbPath := filepath.Join(dir, "mercari.sqlite3")
+	raw, err := sql.Open(sqliteDriver, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer raw.Close()
+	if _, err := raw.ExecContext(ctx, migrations[0]); err != nil {
+		t.Fatalf("failed to apply migration: %v", err)
+	}
+	if _, err := raw.ExecContext(ctx, `PRAGMA user_version = 1`); err != nil {
+		t.Fatalf("failed to set schema version: %v", err)
+	}
+
+	db, err := OpenDBNoMigrate(ctx, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	var journalMode string
+	if err := db.QueryRowContext(ctx, `PRAGMA journal_mode`).Scan(&journalMode); err != nil {
+		t.Fatalf("failed to read journal mode: %v", err)
+	}
+	db.Close()
+	if version, err := schemaVersion(ctx, raw); err != nil || version != 1 {
+		t.Errorf("expected the database not to be migrated, got version %d: %v", version, err)
+	}
+	if journalMode != "delete" {
+		t.Errorf("expected the journal mode to be kept, got %q", journalMode)
+	}
+
+	// a database migrated by a newer server
+	if _, err := raw.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, latestSchemaVersion()+1)); err != nil {
+		t.Fatalf("failed to set schema version: %v", err)
+	}
+	if _, err := OpenDBNoMigrate(ctx, dbPath); err == nil {
+		t.Errorf("expected an error for a schema newer than this binary supports")
+	}
+}
+
+// TestRestoreBackupInvalid checks that archives failing validation leave the database and images untouched.
+func TestRestoreBackupInvalid(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	cases := map[string]struct {
+		setup func(db *sql.DB)
+		edit  func(name string, data []byte) (string, []byte)
+	}{
+		"ng: cut short": {
+			edit: func(name string, data []byte) (string, []byte) {
+				if name == backupManifestName {
+					return "", nil
+				}
+				return name, data
+			},
+		},
+		"ng: image changed": {
+			edit: func(name string, data []byte) (string, []byte) {
+				if name == backupImagePrefix+defaultImageName {
+					return name, []byte("another image")
+				}
+				return name, data
+			},
+		},
+		"ng: image dropped": {
+			edit: func(name string, data []byte) (string, []byte) {
+				if name == backupImagePrefix+defaultImageName {
+					return "", nil
+				}
+				return name, data
+			},
+		},
+		"ng: path outside the image directory": {
+			edit: func(name string, data []byte) (string, []byte) {
+				if name == backupImagePrefix+defaultImageName {
+					return backupImagePrefix + "../" + defaultImageName, data
+				}
+				return name, data
+			},
+		},
+		"ng: unknown entry": {
+			edit: func(name string, data []byte) (string, []byte) {
+				if name == backupDatabaseName {
+					return "other.sqlite3", data
+				}
+				return name, data
+			},
+		},
+		"ng: newer schema": {
+			setup: func(db *sql.DB) {
+				db.Exec(`PRAGMA user_version = 99`)
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newBackupFixture(t)
+			if tt.setup != nil {
+				tt.setup(f.db.write)
+			}
+			ctx := context.Background()
+			var archive bytes.Buffer
+			if _, err := Backup(ctx, f.db.write, f.imgDir, &archive); err != nil {
+				t.Fatalf("failed to back up: %v", err)
+			}
+			data := archive.Bytes()
+			if tt.edit != nil {
+				data = rewriteBackup(t, data, tt.edit)
+			}
+
+			dir := t.TempDir()
+			dbPath := filepath.Join(dir, "mercari.sqlite3")
+			imgDir := filepath.Join(dir, "images")
+			if err := os.WriteFile(dbPath, []byte("previous"), 0644); err != nil {
+				t.Fatalf("failed to write database: %v", err)
+			}
+			if _, err := RestoreBackup(ctx, bytes.NewReader(data), dbPath, imgDir, RestoreOptions{}); err == nil {
+				t.Fatalf("expected the archive to be refused")
+			}
+			if got, err := os.ReadFile(dbPath); err != nil || string(got) != "previous" {
+				t.Errorf("expected the database to be untouched: %q, %v", got, err)
+			}
+			if _, err := os.Stat(imgDir); !os.IsNotExist(err) {
+				t.Errorf("expected no image to be restored: %v", err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("expected the staging directory to be removed, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestBackupDatabase(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	f := newBackupFixture(t)
+	cases := map[string]struct {
+		adminToken    string
+		authorization string
+		noDB          bool
+		wantCode      int
+	}{
+		"ok: backup": {
+			adminToken:    "secret",
+			authorization: "Bearer secret",
+			wantCode:      http.StatusOK,
+		},
+		"ng: disabled": {
+			authorization: "Bearer ",
+			wantCode:      http.StatusNotFound,
+		},
+		"ng: no token": {
+			adminToken: "secret",
+			wantCode:   http.StatusUnauthorized,
+		},
+		"ng: wrong token": {
+			adminToken:    "secret",
+			authorization: "Bearer secret2",
+			wantCode:      http.StatusUnauthorized,
+		},
+		"ng: JSON store": {
+			adminToken:    "secret",
+			authorization: "Bearer secret",
+			noDB:          true,
+			wantCode:      http.StatusNotImplemented,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{imgDirPath: f.imgDir, db: f.db.write, adminToken: tt.adminToken}
+			if tt.noDB {
+				h.db = nil
+			}
+			req := httptest.NewRequest("GET", "/admin/backup", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res := httptest.NewRecorder()
+			h.BackupDatabase(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+			if ct := res.Header().Get("Content-Type"); ct != "application/x-tar" {
+				t.Errorf("unexpected content type %q", ct)
+			}
+			var manifest *BackupManifest
+			rewriteBackup(t, res.Body.Bytes(), func(name string, data []byte) (string, []byte) {
+				if name == backupManifestName {
+					if err := json.Unmarshal(data, &manifest); err != nil {
+						t.Errorf("failed to decode manifest: %v", err)
+					}
+				}
+				return name, data
+			})
+			if manifest == nil || manifest.Items != 3 {
+				t.Errorf("unexpected manifest: %+v", manifest)
+			}
+		})
+	}
+}
+
+// TestBackupDatabaseAborted checks that a backup failing after the archive started aborts the response,
+// so that clients do not take the truncated archive for a complete one.
+func TestBackupDatabaseAborted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+	t.Parallel()
+
+	f := newBackupFixture(t)
+	// the missing image cannot be read once the database is in the archive
+	if err := os.Mkdir(filepath.Join(f.imgDir, strings.Repeat("f", 64)+".jpg"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	h := &Handlers{imgDirPath: f.imgDir, db: f.db.write, adminToken: "secret"}
+	req := httptest.NewRequest("GET", "/admin/backup", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res := httptest.NewRecorder()
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("expected the response to be aborted, got panic %v with status %d", p, res.Code)
+		}
+	}()
+	h.BackupDatabase(res, req)
+}
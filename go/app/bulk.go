@@ -0,0 +1,311 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// maxBulkItems limits the rows of a bulk import, so that a request does not hold the server for long.
+const maxBulkItems = 10000
+
+// maxBulkBodySize limits the size of the body of POST /items:bulk, the file and the images together.
+// Each image is also limited to maxAddItemBodySize as in POST /items.
+const maxBulkBodySize = 512 << 20
+
+// bulkFormMemory is the part of the form of POST /items:bulk kept in memory; the rest is stored in temporary files.
+const bulkFormMemory = 32 << 20
+
+// bulkInsertBatchSize is the number of items inserted in a transaction.
+// A failed batch fails only its own rows.
+const bulkInsertBatchSize = 500
+
+var (
+	errTooManyBulkItems  = fmt.Errorf("must have at most %d rows", maxBulkItems)
+	errBulkImageTooLarge = errors.New("image is too large")
+)
+
+// BulkItemRow is a row of a bulk import file.
+type BulkItemRow struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// Image is the path or file:// URL of the image on the client, or empty for the placeholder.
+	// The image is attached to the request under the name returned by BulkImageName.
+	Image string `json:"image"`
+}
+
+// BulkItemResult is the result of importing a row.
+type BulkItemResult struct {
+	// Row is the number of the row from 1, not counting the header of a CSV file.
+	Row int `json:"row"`
+	// ID is the ID of the item added, or zero if the row failed.
+	ID     int          `json:"id,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type BulkAddItemsResponse struct {
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Results  []BulkItemResult `json:"results"`
+}
+
+// ReadBulkItems reads the rows of a bulk import file. The format is chosen by the extension of fileName:
+// ".csv" with a header of name, category and optionally image, or ".jsonl" with a BulkItemRow per line.
+// The rows are validated later, one by one; an error is returned only if the file cannot be read
+// or has more than maxBulkItems rows, in which case the rest of the file is not read.
+func ReadBulkItems(r io.Reader, fileName string) ([]BulkItemRow, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return readBulkItemsCSV(r)
+	case ".jsonl", ".ndjson":
+		return readBulkItemsJSONL(r)
+	}
+	return nil, fmt.Errorf("unsupported file %q: want .csv or .jsonl", fileName)
+}
+
+func readBulkItemsCSV(r io.Reader) ([]BulkItemRow, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		// a BOM is written by some spreadsheets
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		switch name {
+		case "name", "category", "image":
+			columns[name] = i
+		default:
+			return nil, fmt.Errorf("unknown CSV column %q: want name, category and image", name)
+		}
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("CSV header must have name and category")
+	}
+	if _, ok := columns["category"]; !ok {
+		return nil, errors.New("CSV header must have name and category")
+	}
+
+	rows := []BulkItemRow{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == maxBulkItems {
+			return nil, errTooManyBulkItems
+		}
+		row := BulkItemRow{Name: record[columns["name"]], Category: record[columns["category"]]}
+		if i, ok := columns["image"]; ok {
+			row.Image = record[i]
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readBulkItemsJSONL(r io.Reader) ([]BulkItemRow, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	rows := []BulkItemRow{}
+	for {
+		var row BulkItemRow
+		err := dec.Decode(&row)
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON in row %d: %w", len(rows)+1, err)
+		}
+		if len(rows) == maxBulkItems {
+			return nil, errTooManyBulkItems
+		}
+		rows = append(rows, row)
+	}
+}
+
+// BulkImageName returns the name under which the image of a row is attached:
+// the base name of the path or file:// URL.
+func BulkImageName(image string) string {
+	if u, err := url.Parse(image); err == nil && u.Scheme == "file" {
+		image = u.Path
+	}
+	// paths may come from Windows clients
+	return image[strings.LastIndexAny(image, `/\`)+1:]
+}
+
+// BulkAddItems is a handler to add items listed in a CSV or JSON Lines file for POST /items:bulk .
+// The file is sent as "items" and the images it refers to as "images" in a multipart form.
+// Each row is validated like POST /items, and the result of every row is returned.
+func (s *Handlers) BulkAddItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkBodySize)
+	if err := r.ParseMultipartForm(bulkFormMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must be at most %d bytes", maxBulkBodySize))
+			return
+		}
+		writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to parse form: %v", err))
+		return
+	}
+	file, header, err := r.FormFile("items")
+	if err != nil {
+		verr := &ValidationError{}
+		verr.add("items", "is required")
+		writeValidationError(w, r, verr)
+		return
+	}
+	defer file.Close()
+	rows, err := ReadBulkItems(file, header.Filename)
+	if err != nil {
+		verr := &ValidationError{}
+		verr.add("items", "%s", err.Error())
+		writeValidationError(w, r, verr)
+		return
+	}
+	images := map[string]*multipart.FileHeader{}
+	if r.MultipartForm != nil {
+		for _, fh := range r.MultipartForm.File["images"] {
+			images[fh.Filename] = fh
+		}
+	}
+
+	resp := BulkAddItemsResponse{Results: make([]BulkItemResult, len(rows))}
+	var items []*Item
+	var itemRows []int
+	for i, row := range rows {
+		resp.Results[i].Row = i + 1
+		item, err := s.bulkItem(r, row, images)
+		if err != nil {
+			resp.Results[i].Errors = bulkRowErrors(err)
+			continue
+		}
+		items = append(items, item)
+		itemRows = append(itemRows, i)
+	}
+
+	for start := 0; start < len(items); start += bulkInsertBatchSize {
+		end := min(start+bulkInsertBatchSize, len(items))
+		err := s.itemRepo.InsertBatch(ctx, items[start:end])
+		if err != nil {
+			slog.Error("failed to insert items", "error", err)
+		}
+		for k := start; k < end; k++ {
+			if err != nil {
+				resp.Results[itemRows[k]].Errors = []FieldError{{Message: "failed to add the item"}}
+			} else {
+				resp.Results[itemRows[k]].ID = items[k].ID
+			}
+		}
+	}
+
+	for _, result := range resp.Results {
+		if result.ID != 0 {
+			resp.Imported++
+		} else {
+			resp.Failed++
+		}
+	}
+	slog.Info("items imported", "imported", resp.Imported, "failed", resp.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// bulkItem validates a row like POST /items and stores its image.
+// Images of rows that fail later are left to the upload GC.
+func (s *Handlers) bulkItem(r *http.Request, row BulkItemRow, images map[string]*multipart.FileHeader) (*Item, error) {
+	req := &AddItemRequest{Name: row.Name, Category: row.Category}
+	verr := &ValidationError{}
+	if row.Image != "" {
+		fh, ok := images[BulkImageName(row.Image)]
+		if !ok {
+			verr.add("image", "is not attached")
+		} else {
+			data, err := readFormFile(fh)
+			if errors.Is(err, errBulkImageTooLarge) {
+				verr.add("image", "must be at most %d bytes", maxAddItemBodySize)
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to read image: %w", err)
+			}
+			req.ImageName = data
+		}
+	}
+	if err := validateAddItemRequest(req); err != nil {
+		var rowErr *ValidationError
+		if !errors.As(err, &rowErr) {
+			return nil, err
+		}
+		verr.Fields = append(verr.Fields, rowErr.Fields...)
+	}
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+
+	fileName := s.placeholderImage(req.Category)
+	if len(req.ImageName) > 0 {
+		var err error
+		fileName, err = s.storeImage(r.Context(), req.ImageName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	similar, err := s.checkSimilarImages(r.Context(), "image", fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(similar) > 0 {
+		slog.Warn("imported item flagged for a similar image", "image_name", fileName, "similar_item_ids", similar)
+	}
+	return &Item{Name: req.Name, Category: req.Category, Image: fileName}, nil
+}
+
+// readFormFile reads an attached image, up to maxAddItemBodySize.
+func readFormFile(fh *multipart.FileHeader) ([]byte, error) {
+	if fh.Size > maxAddItemBodySize {
+		return nil, errBulkImageTooLarge
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxAddItemBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxAddItemBodySize {
+		return nil, errBulkImageTooLarge
+	}
+	return data, nil
+}
+
+// bulkRowErrors converts the error of a row into the field errors reported for it.
+func bulkRowErrors(err error) []FieldError {
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &verr):
+		return verr.Fields
+	case errors.Is(err, errInvalidImage):
+		return []FieldError{{Field: "image", Message: invalidImageMessage}}
+	}
+	slog.Error("failed to import item", "error", err)
+	return []FieldError{{Message: "failed to add the item"}}
+}
@@ -0,0 +1,234 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestReadBulkItems(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		fileName string
+		content  string
+		want     []BulkItemRow
+		wantErr  bool
+	}{
+		"ok: csv": {
+			fileName: "items.csv",
+			content:  "\ufeffcategory,name,image\nfashion,jacket,photos/jacket.jpg\nphone,\"iPhone, used\",\n",
+			want: []BulkItemRow{
+				{Name: "jacket", Category: "fashion", Image: "photos/jacket.jpg"},
+				{Name: "iPhone, used", Category: "phone"},
+			},
+		},
+		"ok: csv without images": {
+			fileName: "items.CSV",
+			content:  "name,category\njacket,fashion\n",
+			want:     []BulkItemRow{{Name: "jacket", Category: "fashion"}},
+		},
+		"ok: jsonl": {
+			fileName: "items.jsonl",
+			content:  `{"name": "jacket", "category": "fashion", "image": "file:///photos/jacket.jpg"}` + "\n" + `{"name": "", "category": "phone"}` + "\n",
+			want: []BulkItemRow{
+				{Name: "jacket", Category: "fashion", Image: "file:///photos/jacket.jpg"},
+				{Category: "phone"},
+			},
+		},
+		"ng: unknown column": {
+			fileName: "items.csv",
+			content:  "name,category,price\njacket,fashion,100\n",
+			wantErr:  true,
+		},
+		"ng: missing column": {
+			fileName: "items.csv",
+			content:  "name\njacket\n",
+			wantErr:  true,
+		},
+		"ng: unknown field": {
+			fileName: "items.jsonl",
+			content:  `{"name": "jacket", "category": "fashion", "price": 100}`,
+			wantErr:  true,
+		},
+		"ng: too many rows": {
+			fileName: "items.csv",
+			content:  "name,category\n" + strings.Repeat("jacket,fashion\n", maxBulkItems+1),
+			wantErr:  true,
+		},
+		"ng: too many lines": {
+			fileName: "items.jsonl",
+			content:  strings.Repeat(`{"name": "jacket", "category": "fashion"}`+"\n", maxBulkItems+1),
+			wantErr:  true,
+		},
+		"ng: unsupported file": {
+			fileName: "items.xlsx",
+			content:  "name,category\n",
+			wantErr:  true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ReadBulkItems(strings.NewReader(tt.content), tt.fileName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected rows (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBulkImageName(t *testing.T) {
+	t.Parallel()
+
+	for image, want := range map[string]string{
+		"jacket.jpg":                  "jacket.jpg",
+		"photos/jacket.jpg":           "jacket.jpg",
+		`C:\photos\jacket.jpg`:        "jacket.jpg",
+		"file:///home/me/jacket.jpg":  "jacket.jpg",
+		"file:///C:/photos/a%20b.jpg": "a b.jpg",
+	} {
+		if got := BulkImageName(image); got != want {
+			t.Errorf("unexpected name of %s. want=%s, got=%s", image, want, got)
+		}
+	}
+}
+
+func TestBulkAddItems(t *testing.T) {
+	t.Parallel()
+
+	jacket := encodeTestJPEG(t, 8, 8, nil)
+	content := "name,category,image\n" +
+		"jacket,fashion,photos/jacket.jpg\n" +
+		",fashion,\n" +
+		"shirt,fashion,missing.jpg\n" +
+		"notes,fashion,notes.jpg\n" +
+		"iPhone,phone,\n"
+
+	cases := map[string]struct {
+		injector    func(m *MockItemRepository)
+		wantResults []BulkItemResult
+	}{
+		"ok: valid rows are added": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().InsertBatch(gomock.Any(), gomock.Len(2)).DoAndReturn(func(_ any, items []*Item) error {
+					for i, item := range items {
+						item.ID = i + 1
+					}
+					return nil
+				})
+			},
+			wantResults: []BulkItemResult{
+				{Row: 1, ID: 1},
+				{Row: 2, Errors: []FieldError{{Field: "name", Message: "is required"}}},
+				{Row: 3, Errors: []FieldError{{Field: "image", Message: "is not attached"}}},
+				{Row: 4, Errors: []FieldError{{Field: "image", Message: invalidImageMessage}}},
+				{Row: 5, ID: 2},
+			},
+		},
+		"ng: failed to insert": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().InsertBatch(gomock.Any(), gomock.Len(2)).Return(errors.New("failed to insert"))
+			},
+			wantResults: []BulkItemResult{
+				{Row: 1, Errors: []FieldError{{Message: "failed to add the item"}}},
+				{Row: 2, Errors: []FieldError{{Field: "name", Message: "is required"}}},
+				{Row: 3, Errors: []FieldError{{Field: "image", Message: "is not attached"}}},
+				{Row: 4, Errors: []FieldError{{Field: "image", Message: invalidImageMessage}}},
+				{Row: 5, Errors: []FieldError{{Message: "failed to add the item"}}},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{imgDirPath: t.TempDir(), itemRepo: mockIR}
+
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			for _, part := range []struct{ field, name, content string }{
+				{"items", "items.csv", content},
+				{"images", "jacket.jpg", string(jacket)},
+				{"images", "notes.jpg", "not an image"},
+			} {
+				fw, err := mw.CreateFormFile(part.field, part.name)
+				if err != nil {
+					t.Fatalf("failed to create form file: %v", err)
+				}
+				fw.Write([]byte(part.content))
+			}
+			mw.Close()
+
+			req := httptest.NewRequest("POST", "/items:bulk", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			res := httptest.NewRecorder()
+			h.BulkAddItems(res, req)
+
+			if res.Code != http.StatusOK {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", http.StatusOK, res.Code, res.Body.String())
+			}
+			var got BulkAddItemsResponse
+			if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to unmarshal response body: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantResults, got.Results); diff != "" {
+				t.Errorf("unexpected results (-want +got):\n%s", diff)
+			}
+			imported := 0
+			for _, result := range tt.wantResults {
+				if result.ID != 0 {
+					imported++
+				}
+			}
+			if got.Imported != imported || got.Failed != len(tt.wantResults)-imported {
+				t.Errorf("unexpected counts. want=%d/%d, got=%d/%d", imported, len(tt.wantResults)-imported, got.Imported, got.Failed)
+			}
+		})
+	}
+}
+
+// TestBulkAddItemsMalformedForm checks that a form that cannot be parsed is reported as such
+// instead of as a missing file.
+func TestBulkAddItemsMalformedForm(t *testing.T) {
+	t.Parallel()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("items", "items.csv")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	fw.Write([]byte("name,category\njacket,fashion\n"))
+	// without the closing boundary
+
+	req := httptest.NewRequest("POST", "/items:bulk", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res := httptest.NewRecorder()
+	h := &Handlers{imgDirPath: t.TempDir(), itemRepo: NewMockItemRepository(gomock.NewController(t))}
+	h.BulkAddItems(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code. want=%d, got=%d: %s", http.StatusBadRequest, res.Code, res.Body.String())
+	}
+	if !strings.Contains(res.Body.String(), "failed to parse form") {
+		t.Errorf("expected the parse error, got %s", res.Body.String())
+	}
+}
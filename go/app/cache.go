@@ -0,0 +1,357 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Default size of the item cache. Items change rarely, so a short TTL bounds
+// how stale a cached item can be when another process writes to the database.
+const (
+	defaultItemCacheSize = 1000
+	defaultItemCacheTTL  = 30 * time.Second
+)
+
+// CacheStats is a snapshot of the statistics of a cache.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+// ItemCacheStats is the statistics of CachedItemRepository.
+type ItemCacheStats struct {
+	Items  CacheStats `json:"items"`
+	Search CacheStats `json:"search"`
+}
+
+// lruCache is a size bounded cache evicting the least recently used entry.
+// Entries older than ttl are treated as missing.
+type lruCache[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+	ll       *list.List // front is the most recently used
+	entries  map[string]*list.Element
+	// generation is incremented by clear, so that a value loaded before
+	// the cache was cleared is not stored after it.
+	generation uint64
+
+	hits, misses, evictions uint64
+}
+
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+func newLRUCache[V any](capacity int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{
+		capacity: capacity,
+		ttl:      ttl,
+		now:      time.Now,
+		ll:       list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// get returns the value of key if it is cached and not expired.
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*lruEntry[V])
+		if c.now().Before(entry.expiresAt) {
+			c.ll.MoveToFront(e)
+			c.hits++
+			return entry.value, true
+		}
+		c.removeElement(e)
+	}
+	c.misses++
+	var zero V
+	return zero, false
+}
+
+// currentGeneration returns the generation to pass to add after loading a value.
+func (c *lruCache[V]) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// add stores the value unless the cache was cleared since generation was returned by currentGeneration.
+func (c *lruCache[V]) add(key string, value V, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+	c.entries[key] = c.ll.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: c.now().Add(c.ttl)})
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// clear deletes all entries.
+func (c *lruCache[V]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.ll.Init()
+	clear(c.entries)
+}
+
+func (c *lruCache[V]) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.entries, e.Value.(*lruEntry[V]).key)
+}
+
+func (c *lruCache[V]) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{Hits: c.hits, Misses: c.misses, Evictions: c.evictions, Entries: c.ll.Len()}
+}
+
+// CachedItemRepository is an ItemRepository caching GetByID and SearchByKeyword of another ItemRepository.
+// Writes go to the underlying repository and invalidate the affected entries.
+// Concurrent misses for the same key are served by a single query, which runs with the
+// context of the first caller.
+//
+// Every method is delegated explicitly instead of embedding ItemRepository,
+// so that a new write method cannot bypass the invalidation by accident.
+type CachedItemRepository struct {
+	repo   ItemRepository
+	items  *lruCache[*Item]
+	search *lruCache[[]*Item]
+	group  singleflight.Group
+}
+
+var _ ItemRepository = (*CachedItemRepository)(nil)
+
+// NewCachedItemRepository wraps repo with a cache of size entries per query kind, each kept for ttl.
+func NewCachedItemRepository(repo ItemRepository, size int, ttl time.Duration) *CachedItemRepository {
+	return &CachedItemRepository{
+		repo:   repo,
+		items:  newLRUCache[*Item](size, ttl),
+		search: newLRUCache[[]*Item](size, ttl),
+	}
+}
+
+// Stats returns the hit and miss statistics of the cache.
+func (c *CachedItemRepository) Stats() ItemCacheStats {
+	return ItemCacheStats{Items: c.items.stats(), Search: c.search.stats()}
+}
+
+func (c *CachedItemRepository) CategoryInsert(ctx context.Context, categoryName string) (int, error) {
+	return c.repo.CategoryInsert(ctx, categoryName)
+}
+
+// Insert inserts the item and invalidates the search results, since any of them may now include it.
+// Cached items are kept as inserting does not change existing items.
+func (c *CachedItemRepository) Insert(ctx context.Context, item *Item) error {
+	defer c.search.clear()
+	return c.repo.Insert(ctx, item)
+}
+
+// InsertBatch inserts the items and invalidates the search results like Insert.
+func (c *CachedItemRepository) InsertBatch(ctx context.Context, items []*Item) error {
+	defer c.search.clear()
+	return c.repo.InsertBatch(ctx, items)
+}
+
+func (c *CachedItemRepository) GetAll(ctx context.Context) ([]*Item, error) {
+	return c.repo.GetAll(ctx)
+}
+
+func (c *CachedItemRepository) LastModified(ctx context.Context) (time.Time, error) {
+	return c.repo.LastModified(ctx)
+}
+
+func (c *CachedItemRepository) GetByID(ctx context.Context, id int) (*Item, error) {
+	key := strconv.Itoa(id)
+	if item, ok := c.items.get(key); ok {
+		return copyItem(item), nil
+	}
+
+	v, err, _ := c.group.Do("item:"+key, func() (any, error) {
+		generation := c.items.currentGeneration()
+		item, err := c.repo.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		c.items.add(key, item, generation)
+		return item, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyItem(v.(*Item)), nil
+}
+
+func (c *CachedItemRepository) SearchByKeyword(ctx context.Context, keyword string) ([]*Item, error) {
+	key := searchCacheKey(keyword)
+	if items, ok := c.search.get(key); ok {
+		return copyItems(items), nil
+	}
+
+	v, err, _ := c.group.Do("search:"+key, func() (any, error) {
+		generation := c.search.currentGeneration()
+		items, err := c.repo.SearchByKeyword(ctx, keyword)
+		if err != nil {
+			return nil, err
+		}
+		c.search.add(key, items, generation)
+		return items, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyItems(v.([]*Item)), nil
+}
+
+func (c *CachedItemRepository) CountByImage(ctx context.Context, imageName string) (int, error) {
+	return c.repo.CountByImage(ctx, imageName)
+}
+
+func (c *CachedItemRepository) ReferencedImages(ctx context.Context) (map[string]int, error) {
+	return c.repo.ReferencedImages(ctx)
+}
+
+// ReplaceImage replaces the image of items and invalidates the cache, since any cached item may refer to it.
+func (c *CachedItemRepository) ReplaceImage(ctx context.Context, oldName, newName string) (int, error) {
+	defer c.items.clear()
+	defer c.search.clear()
+	return c.repo.ReplaceImage(ctx, oldName, newName)
+}
+
+// ExportItems is not cached, since the export is streamed and may be larger than the cache.
+func (c *CachedItemRepository) ExportItems(ctx context.Context, filter ExportFilter, fn func(*ExportItem) error) error {
+	return c.repo.ExportItems(ctx, filter, fn)
+}
+
+// SimilarItems is not cached, since it depends on the images of all items.
+func (c *CachedItemRepository) SimilarItems(ctx context.Context, imageName string, maxDistance int) ([]*SimilarItem, error) {
+	return c.repo.SimilarItems(ctx, imageName, maxDistance)
+}
+
+// Update updates the item and invalidates the cache, since it may be in any search result.
+func (c *CachedItemRepository) Update(ctx context.Context, item *Item) error {
+	defer c.items.clear()
+	defer c.search.clear()
+	return c.repo.Update(ctx, item)
+}
+
+// Delete deletes the item and invalidates the cache.
+func (c *CachedItemRepository) Delete(ctx context.Context, id, version int) error {
+	defer c.items.clear()
+	defer c.search.clear()
+	return c.repo.Delete(ctx, id, version)
+}
+
+// Restore restores the item and invalidates the search results, since any of them may now include it.
+// Cached items are kept as deleted items are not cached.
+func (c *CachedItemRepository) Restore(ctx context.Context, id int) (*Item, error) {
+	defer c.search.clear()
+	return c.repo.Restore(ctx, id)
+}
+
+// History is not cached, since every change adds to it.
+func (c *CachedItemRepository) History(ctx context.Context, id int) ([]*ItemEvent, error) {
+	return c.repo.History(ctx, id)
+}
+
+func (c *CachedItemRepository) GetCategories(ctx context.Context) ([]*Category, error) {
+	return c.repo.GetCategories(ctx)
+}
+
+// RenameCategory renames the category and invalidates the cache, since items include the category name.
+func (c *CachedItemRepository) RenameCategory(ctx context.Context, id int, name string) error {
+	defer c.items.clear()
+	defer c.search.clear()
+	return c.repo.RenameCategory(ctx, id, name)
+}
+
+func (c *CachedItemRepository) DeleteCategory(ctx context.Context, id int) error {
+	return c.repo.DeleteCategory(ctx, id)
+}
+
+// MergeCategories merges the categories and invalidates the cache, since items include the category name.
+func (c *CachedItemRepository) MergeCategories(ctx context.Context, src, dst int) (int, error) {
+	defer c.items.clear()
+	defer c.search.clear()
+	return c.repo.MergeCategories(ctx, src, dst)
+}
+
+// searchCacheKey normalizes a keyword so that keywords with the same results share an entry.
+// SQLite's LIKE ignores the case of ASCII letters only, so other letters are kept as is.
+func searchCacheKey(keyword string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, keyword)
+}
+
+// copyItem returns a copy so that callers cannot modify the cached item.
+func copyItem(item *Item) *Item {
+	c := *item
+	return &c
+}
+
+func copyItems(items []*Item) []*Item {
+	c := make([]*Item, len(items))
+	for i, item := range items {
+		c[i] = copyItem(item)
+	}
+	return c
+}
+
+// uncachedItemRepo returns the repository of the items without the cache, for reads that must see
+// changes made outside the server, such as by mercari-admin, before the cached entries expire.
+func (s *Handlers) uncachedItemRepo() ItemRepository {
+	if s.itemCache != nil {
+		return s.itemCache.repo
+	}
+	return s.itemRepo
+}
+
+// CacheStats is a handler to return the statistics of the item cache for GET /debug/cache .
+// It requires the admin token.
+func (s *Handlers) CacheStats(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	var resp ItemCacheStats
+	if s.itemCache != nil {
+		resp = s.itemCache.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
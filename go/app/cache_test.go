@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestLRUCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newLRUCache[int](2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.add("a", 1, c.currentGeneration())
+	c.add("b", 2, c.currentGeneration())
+	c.get("a") // a is now the most recently used
+	c.add("c", 3, c.currentGeneration())
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("b should have been evicted")
+	}
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("unexpected value of a: %d, %v", v, ok)
+	}
+
+	// a value loaded before clear is not stored
+	generation := c.currentGeneration()
+	c.clear()
+	c.add("d", 4, generation)
+	if _, ok := c.get("d"); ok {
+		t.Errorf("d should not have been stored after clear")
+	}
+
+	c.add("e", 5, c.currentGeneration())
+	now = now.Add(time.Minute)
+	if _, ok := c.get("e"); ok {
+		t.Errorf("e should have expired")
+	}
+
+	want := CacheStats{Hits: 2, Misses: 3, Evictions: 1, Entries: 0}
+	if diff := cmp.Diff(want, c.stats()); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+}
+
+func TestCachedItemRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion"}
+
+	cases := map[string]struct {
+		injector func(m *MockItemRepository)
+		run      func(t *testing.T, c *CachedItemRepository)
+		// want is nil if the stats depend on scheduling
+		want *ItemCacheStats
+	}{
+		"ok: item is cached": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil).Times(1)
+			},
+			run: func(t *testing.T, c *CachedItemRepository) {
+				for range 3 {
+					got, err := c.GetByID(ctx, 1)
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					// callers get a copy they can modify
+					got.Name = "modified"
+				}
+			},
+			want: &ItemCacheStats{Items: CacheStats{Hits: 2, Misses: 1, Entries: 1}},
+		},
+		"ok: errors are not cached": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(nil, context.DeadlineExceeded).Times(2)
+			},
+			run: func(t *testing.T, c *CachedItemRepository) {
+				for range 2 {
+					if _, err := c.GetByID(ctx, 1); err == nil {
+						t.Fatalf("expected an error")
+					}
+				}
+			},
+			want: &ItemCacheStats{Items: CacheStats{Misses: 2}},
+		},
+		"ok: keywords differing in ASCII case share an entry": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SearchByKeyword(gomock.Any(), "Jacket").Return([]*Item{item}, nil).Times(1)
+			},
+			run: func(t *testing.T, c *CachedItemRepository) {
+				for _, keyword := range []string{"Jacket", "jacket", "JACKET"} {
+					if _, err := c.SearchByKeyword(ctx, keyword); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				}
+			},
+			want: &ItemCacheStats{Search: CacheStats{Hits: 2, Misses: 1, Entries: 1}},
+		},
+		"ok: insert invalidates search results": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SearchByKeyword(gomock.Any(), "jacket").Return([]*Item{item}, nil).Times(2)
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			run: func(t *testing.T, c *CachedItemRepository) {
+				c.SearchByKeyword(ctx, "jacket")
+				if err := c.Insert(ctx, &Item{Name: "jacket 2", Category: "fashion"}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				c.SearchByKeyword(ctx, "jacket")
+			},
+			want: &ItemCacheStats{Search: CacheStats{Misses: 2, Entries: 1}},
+		},
+		"ok: update invalidates items": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil).Times(2)
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			run: func(t *testing.T, c *CachedItemRepository) {
+				c.GetByID(ctx, 1)
+				if err := c.Update(ctx, &Item{ID: 1, Name: "blue jacket", Category: "fashion"}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				c.GetByID(ctx, 1)
+			},
+			want: &ItemCacheStats{Items: CacheStats{Misses: 2, Entries: 1}},
+		},
+		"ok: concurrent misses query once": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).DoAndReturn(func(ctx context.Context, id int) (*Item, error) {
+					// give the other callers time to join the flight
+					time.Sleep(50 * time.Millisecond)
+					return item, nil
+				}).Times(1)
+			},
+			run: func(t *testing.T, c *CachedItemRepository) {
+				var wg sync.WaitGroup
+				for range 10 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if _, err := c.GetByID(ctx, 1); err != nil {
+							t.Errorf("unexpected error: %v", err)
+						}
+					}()
+				}
+				wg.Wait()
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			c := NewCachedItemRepository(mockIR, 10, time.Minute)
+
+			tt.run(t, c)
+
+			if tt.want == nil {
+				return
+			}
+			if diff := cmp.Diff(*tt.want, c.Stats()); diff != "" {
+				t.Errorf("unexpected stats (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressibleTypes are the media types compressed by compressionMiddleware.
+// Images and Parquet files are already compressed, so only text formats are.
+var compressibleTypes = map[string]bool{
+	"application/json":     true,
+	"text/csv":             true,
+	"application/x-ndjson": true,
+}
+
+// compressionMiddleware compresses JSON and other text responses with brotli or gzip,
+// whichever the client prefers in Accept-Encoding (brotli on a tie).
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns "br", "gzip" or "" for the Accept-Encoding header.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "br" && coding != "gzip" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ || (q == bestQ && q > 0 && coding == "br") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter compresses the body if the response turns out to be compressible.
+// The decision is made when the header is written, since only then the Content-Type is known.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	wroteHeader bool
+	// w is the compressor, or nil if the response is written as is.
+	w io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if compressibleTypes[mediaType] && h.Get("Content-Encoding") == "" &&
+		code != http.StatusNoContent && code != http.StatusNotModified && code >= http.StatusOK {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// the compressed body differs byte for byte, so the ETag of the uncompressed
+		// representation is only a weak validator for it
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch cw.encoding {
+		case "br":
+			cw.w = brotli.NewWriterLevel(cw.ResponseWriter, brotli.DefaultCompression)
+		case "gzip":
+			cw.w = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			// same as net/http does for an unset Content-Type
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.w.Write(b)
+}
+
+// Flush flushes the compressed data written so far, for streaming responses.
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close writes the remaining compressed data.
+func (cw *compressWriter) Close() error {
+	if cw.w == nil {
+		return nil
+	}
+	return cw.w.Close()
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"":                     "",
+		"identity":             "",
+		"gzip":                 "gzip",
+		"gzip, deflate, br":    "br",
+		"br;q=0.5, gzip":       "gzip",
+		"br;q=0, gzip;q=0":     "",
+		"GZIP;q=0.8, br;q=0.1": "gzip",
+	}
+
+	for acceptEncoding, want := range cases {
+		t.Run(acceptEncoding, func(t *testing.T) {
+			t.Parallel()
+
+			if got := negotiateEncoding(acceptEncoding); got != want {
+				t.Errorf("unexpected encoding. want=%q, got=%q", want, got)
+			}
+		})
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	t.Parallel()
+
+	body := `{"items": []}` + strings.Repeat(" ", 1000)
+	cases := map[string]struct {
+		contentType    string
+		acceptEncoding string
+		etag           string
+		wantEncoding   string
+		wantETag       string
+		decode         func(r io.Reader) (io.Reader, error)
+	}{
+		"ok: gzip": {
+			contentType:    "application/json",
+			acceptEncoding: "gzip",
+			etag:           `"tag"`,
+			wantEncoding:   "gzip",
+			// the compressed body differs byte for byte
+			wantETag: `W/"tag"`,
+			decode:   func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		"ok: brotli": {
+			contentType:    "application/json",
+			acceptEncoding: "gzip, br",
+			etag:           `"tag"`,
+			wantEncoding:   "br",
+			wantETag:       `W/"tag"`,
+			decode:         func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		},
+		"ok: not accepted": {
+			contentType: "application/json",
+			etag:        `"tag"`,
+			wantETag:    `"tag"`,
+		},
+		"ok: image is not compressed": {
+			contentType:    "image/jpeg",
+			acceptEncoding: "gzip",
+			etag:           `"tag"`,
+			wantETag:       `"tag"`,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := compressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("ETag", tt.etag)
+				io.WriteString(w, body)
+			}))
+
+			req := httptest.NewRequest("GET", "/items", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			if got := res.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("unexpected Content-Encoding. want=%q, got=%q", tt.wantEncoding, got)
+			}
+			if got := res.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("unexpected Vary. want=Accept-Encoding, got=%q", got)
+			}
+			if got := res.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("unexpected ETag. want=%s, got=%s", tt.wantETag, got)
+			}
+
+			var r io.Reader = res.Body
+			if tt.decode != nil {
+				var err error
+				if r, err = tt.decode(r); err != nil {
+					t.Fatalf("failed to decode body: %v", err)
+				}
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			if string(got) != body {
+				t.Errorf("unexpected body: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// UpdateItemRequest is the body of PATCH /items/{item_id}. Fields left out are not changed.
+type UpdateItemRequest struct {
+	Name     *string `json:"name"`
+	Category *string `json:"category"`
+}
+
+// parseUpdateItemRequest parses and validates a JSON body, which bodyLimitMiddleware limits. Unknown fields are rejected.
+func parseUpdateItemRequest(r *http.Request) (*UpdateItemRequest, error) {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var req UpdateItemRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, jsonDecodeError(err)
+	}
+	if dec.More() {
+		return nil, errors.New("request body must contain a single JSON object")
+	}
+
+	verr := &ValidationError{}
+	if req.Name == nil && req.Category == nil {
+		verr.add("", "name or category is required")
+	}
+	if req.Name != nil && *req.Name == "" {
+		verr.add("name", "must not be empty")
+	}
+	if req.Category != nil && *req.Category == "" {
+		verr.add("category", "must not be empty")
+	}
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+	return &req, nil
+}
+
+// matchItem returns the item of the request if its If-Match header allows changing it.
+// Otherwise it writes 400, 404, 412 Precondition Failed or, without If-Match, 428 Precondition Required
+// and returns nil. If-Match is required so that clients cannot overwrite each other's changes unknowingly.
+// The item is read without the cache, so that a cached older version does not fail the precondition.
+func (s *Handlers) matchItem(w http.ResponseWriter, r *http.Request) *Item {
+	itemID, err := strconv.Atoi(r.PathValue("item_id"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "item_id must be an integer")
+		return nil
+	}
+	if len(r.Header.Values("If-Match")) == 0 {
+		writeJSONError(w, r, http.StatusPreconditionRequired, "If-Match is required; send the ETag of the item as last read")
+		return nil
+	}
+	item, err := s.uncachedItemRepo().GetByID(r.Context(), itemID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, r, http.StatusNotFound, "item not found")
+		return nil
+	}
+	if err != nil {
+		slog.Error("failed to get item", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "failed to get item")
+		return nil
+	}
+	if !ifMatchItem(r, itemETag(item)) {
+		w.Header().Set("ETag", itemETag(item))
+		writeJSONError(w, r, http.StatusPreconditionFailed, "item was changed; get it again and retry")
+		return nil
+	}
+	return item
+}
+
+// writeItemChangeError writes the error of changing the item read by matchItem.
+// The item may have been changed or deleted in between, which fails the precondition as well.
+func writeItemChangeError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var conflict *ItemConflictError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, r, http.StatusNotFound, "item not found")
+	case errors.As(err, &conflict):
+		writeJSONError(w, r, http.StatusPreconditionFailed, "item was changed; get it again and retry")
+	default:
+		slog.Error(message, "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, message)
+	}
+}
+
+// UpdateItem is a handler to change the name or category of an item for PATCH /items/{item_id} .
+// The item is only changed if its ETag still matches If-Match, so that a client does not
+// overwrite a change it has not seen. The response has the ETag of the changed item.
+func (s *Handlers) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	req, err := parseUpdateItemRequest(r)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			writeValidationError(w, r, verr)
+			return
+		}
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	item := s.matchItem(w, r)
+	if item == nil {
+		return
+	}
+
+	if req.Name != nil {
+		item.Name = *req.Name
+	}
+	if req.Category != nil {
+		item.Category = *req.Category
+	}
+	// the repository changes the item only at the version it was read at
+	if err := s.itemRepo.Update(r.Context(), item); err != nil {
+		writeItemChangeError(w, r, err, "failed to update item")
+		return
+	}
+	slog.Info("updated item", "item_id", item.ID, "version", item.Version)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", itemETag(item))
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		slog.Error("failed to write item", "error", err)
+	}
+}
+
+// DeleteItem is a handler to delete an item for DELETE /items/{item_id} , with If-Match as UpdateItem.
+// The item can be restored by POST /admin/items/{item_id}/restore .
+func (s *Handlers) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	item := s.matchItem(w, r)
+	if item == nil {
+		return
+	}
+	if err := s.itemRepo.Delete(r.Context(), item.ID, item.Version); err != nil {
+		writeItemChangeError(w, r, err, "failed to delete item")
+		return
+	}
+	slog.Info("deleted item", "item_id", item.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,344 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestUpdateItem(t *testing.T) {
+	t.Parallel()
+
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion", Image: defaultImageName, Version: 2}
+	updated := &Item{ID: 1, Name: "blue jacket", Category: "fashion", Image: defaultImageName, Version: 3}
+
+	cases := map[string]struct {
+		body     string
+		ifMatch  string
+		injector func(m *MockItemRepository)
+		wantCode int
+		wantETag string
+		wantItem *Item
+	}{
+		"ok: matching ETag": {
+			body:    `{"name":"blue jacket"}`,
+			ifMatch: `"item-1-v2"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(copyItem(item), nil)
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, got *Item) error {
+					// the version read is the one compared by the repository
+					if got.Version != 2 {
+						t.Errorf("unexpected version to update. want=2, got=%d", got.Version)
+					}
+					got.Version++
+					return nil
+				})
+			},
+			wantCode: http.StatusOK,
+			wantItem: updated,
+		},
+		"ng: without If-Match": {
+			body:     `{"name":"blue jacket"}`,
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusPreconditionRequired,
+		},
+		"ok: any ETag": {
+			body:    `{"category":"outer"}`,
+			ifMatch: `"item-1-v1", *`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(copyItem(item), nil)
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			wantCode: http.StatusOK,
+			wantItem: &Item{ID: 1, Name: "jacket", Category: "outer", Image: defaultImageName, Version: 2},
+		},
+		"ng: stale ETag": {
+			body:    `{"name":"blue jacket"}`,
+			ifMatch: `"item-1-v1"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(copyItem(item), nil)
+			},
+			wantCode: http.StatusPreconditionFailed,
+			// the current ETag, to retry with
+			wantETag: `"item-1-v2"`,
+		},
+		"ok: weak ETag of a compressed response": {
+			body:    `{"name":"blue jacket"}`,
+			ifMatch: `W/"item-1-v2"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(copyItem(item), nil)
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, got *Item) error {
+					got.Version++
+					return nil
+				})
+			},
+			wantCode: http.StatusOK,
+			wantItem: updated,
+		},
+		"ng: changed after the ETag was checked": {
+			body:    `{"name":"blue jacket"}`,
+			ifMatch: `"item-1-v2"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(copyItem(item), nil)
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(&ItemConflictError{ID: 1, Version: 2})
+			},
+			wantCode: http.StatusPreconditionFailed,
+		},
+		"ng: changed concurrently with any ETag": {
+			body:    `{"name":"blue jacket"}`,
+			ifMatch: "*",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(copyItem(item), nil)
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(&ItemConflictError{ID: 1, Version: 2})
+			},
+			wantCode: http.StatusPreconditionFailed,
+		},
+		"ng: item not found": {
+			body:    `{"name":"blue jacket"}`,
+			ifMatch: `"item-1-v2"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(nil, sql.ErrNoRows)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		"ng: nothing to change": {
+			body:     `{}`,
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: empty name": {
+			body:     `{"name":""}`,
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: unknown field": {
+			body:     `{"image_name":"default.jpg"}`,
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("PATCH", "/items/1", strings.NewReader(tt.body))
+			req.SetPathValue("item_id", "1")
+			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			res := httptest.NewRecorder()
+			h.UpdateItem(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if tt.wantItem == nil {
+				if etag := res.Header().Get("ETag"); etag != tt.wantETag {
+					t.Errorf("unexpected ETag. want=%q, got=%q", tt.wantETag, etag)
+				}
+				return
+			}
+			var got *Item
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode item: %v", err)
+			}
+			// the version is only sent in the ETag
+			got.Version = tt.wantItem.Version
+			if diff := cmp.Diff(tt.wantItem, got); diff != "" {
+				t.Errorf("unexpected item (-want +got):\n%s", diff)
+			}
+			if etag := res.Header().Get("ETag"); etag != itemETag(tt.wantItem) {
+				t.Errorf("unexpected ETag. want=%s, got=%s", itemETag(tt.wantItem), etag)
+			}
+		})
+	}
+}
+
+func TestDeleteItem(t *testing.T) {
+	t.Parallel()
+
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion", Image: defaultImageName, Version: 2}
+
+	cases := map[string]struct {
+		ifMatch  string
+		injector func(m *MockItemRepository)
+		wantCode int
+	}{
+		"ok: matching ETag": {
+			ifMatch: `"item-1-v2"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil)
+				m.EXPECT().Delete(gomock.Any(), 1, 2).Return(nil)
+			},
+			wantCode: http.StatusNoContent,
+		},
+		"ng: without If-Match": {
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusPreconditionRequired,
+		},
+		"ng: stale ETag": {
+			ifMatch: `"item-1-v1"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil)
+			},
+			wantCode: http.StatusPreconditionFailed,
+		},
+		"ng: deleted concurrently": {
+			ifMatch: `"item-1-v2"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil)
+				m.EXPECT().Delete(gomock.Any(), 1, 2).Return(sql.ErrNoRows)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		"ng: item not found": {
+			ifMatch: `"item-1-v2"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(nil, sql.ErrNoRows)
+			},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("DELETE", "/items/1", nil)
+			req.SetPathValue("item_id", "1")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			res := httptest.NewRecorder()
+			h.DeleteItem(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+// TestUpdateItemCached checks that If-Match is compared with the item in the repository,
+// not with an older version left in the cache by a change made outside the server.
+func TestUpdateItemCached(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	mockIR := NewMockItemRepository(ctrl)
+	cache := NewCachedItemRepository(mockIR, defaultItemCacheSize, defaultItemCacheTTL)
+	h := &Handlers{itemRepo: cache, itemCache: cache}
+
+	gomock.InOrder(
+		mockIR.EXPECT().GetByID(gomock.Any(), 1).Return(&Item{ID: 1, Name: "jacket", Category: "fashion", Image: defaultImageName, Version: 1}, nil),
+		// changed by mercari-admin while version 1 is cached
+		mockIR.EXPECT().GetByID(gomock.Any(), 1).Return(&Item{ID: 1, Name: "jacket", Category: "outer", Image: defaultImageName, Version: 2}, nil),
+	)
+	mockIR.EXPECT().Update(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, got *Item) error {
+		got.Version++
+		return nil
+	})
+	if _, err := cache.GetByID(context.Background(), 1); err != nil {
+		t.Fatalf("failed to cache item: %v", err)
+	}
+
+	req := httptest.NewRequest("PATCH", "/items/1", strings.NewReader(`{"name":"blue jacket"}`))
+	req.SetPathValue("item_id", "1")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"item-1-v2"`)
+	res := httptest.NewRecorder()
+	h.UpdateItem(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("unexpected status code. want=%d, got=%d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if etag := res.Header().Get("ETag"); etag != `"item-1-v3"` {
+		t.Errorf("unexpected ETag. want=%s, got=%s", `"item-1-v3"`, etag)
+	}
+}
+
+// TestEditItemE2e checks that two clients editing the same item do not overwrite each other.
+func TestEditItemE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	handler, closeStores, err := Server{ImageDirPath: dir, DBPath: filepath.Join(dir, "mercari.sqlite3")}.Handler(context.Background())
+	if err != nil {
+		t.Fatalf("failed to set up server: %v", err)
+	}
+	t.Cleanup(func() { closeStores() })
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	res, err := http.PostForm(srv.URL+"/items", url.Values{"name": {"jacket"}, "category": {"fashion"}})
+	if err != nil {
+		t.Fatalf("failed to add item: %v", err)
+	}
+	res.Body.Close()
+
+	do := func(method, body, ifMatch string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+"/items/1", strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to %s item: %v", method, err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	// both clients read the item
+	etag := do("GET", "", "").Header.Get("ETag")
+	first := do("PATCH", `{"name":"blue jacket"}`, etag)
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code of the first update. want=%d, got=%d", http.StatusOK, first.StatusCode)
+	}
+	if first.Header.Get("ETag") == etag {
+		t.Errorf("expected the ETag to change with the update")
+	}
+	if res := do("PATCH", `{"name":"red jacket"}`, etag); res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("unexpected status code of the second update. want=%d, got=%d", http.StatusPreconditionFailed, res.StatusCode)
+	}
+	if res := do("DELETE", "", etag); res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("unexpected status code of a stale delete. want=%d, got=%d", http.StatusPreconditionFailed, res.StatusCode)
+	}
+	if res := do("GET", "", ""); res.Header.Get("ETag") != first.Header.Get("ETag") {
+		t.Errorf("expected the item to be as the first update left it")
+	}
+	if res := do("DELETE", "", first.Header.Get("ETag")); res.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected status code of delete. want=%d, got=%d", http.StatusNoContent, res.StatusCode)
+	}
+	if res := do("GET", "", ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status code of a deleted item. want=%d, got=%d", http.StatusNotFound, res.StatusCode)
+	}
+}
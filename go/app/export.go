@@ -0,0 +1,277 @@
+package app
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ExportColumns are the columns of an export in the order they are written.
+var ExportColumns = []string{"id", "name", "category_id", "category", "image_name", "updated_at"}
+
+// exportContentTypes are the media types of the export formats.
+var exportContentTypes = map[string]string{
+	"csv":     "text/csv; charset=utf-8",
+	"jsonl":   "application/x-ndjson",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// exportWriteTimeout is how long a write of the export may wait for the client.
+// The export has no request timeout, so that a large export is not cut off,
+// but a client that stops reading is dropped instead of holding the server.
+const exportWriteTimeout = 30 * time.Second
+
+// parquetRowGroupSize bounds the memory used to buffer a Parquet export.
+// The library default of 128 MiB would hold most catalogs in memory at once.
+const parquetRowGroupSize = 8 << 20
+
+// ExportWriter writes the rows of an export.
+type ExportWriter interface {
+	Write(item *ExportItem) error
+	// Close writes the rest of the export, e.g. the footer of a Parquet file.
+	// It does not close the underlying writer.
+	Close() error
+}
+
+// ParseExportColumns parses a comma-separated list of columns, keeping the order of ExportColumns.
+// An empty list selects all columns.
+func ParseExportColumns(s string) ([]string, error) {
+	if s == "" {
+		return ExportColumns, nil
+	}
+	selected := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(ExportColumns, name) {
+			return nil, fmt.Errorf("unknown column %q: want %s", name, strings.Join(ExportColumns, ", "))
+		}
+		selected[name] = true
+	}
+	var columns []string
+	for _, name := range ExportColumns {
+		if selected[name] {
+			columns = append(columns, name)
+		}
+	}
+	return columns, nil
+}
+
+// NewExportWriter returns a writer of the columns in format: "csv", "jsonl" or "parquet".
+func NewExportWriter(w io.Writer, format string, columns []string) (ExportWriter, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		return &csvExportWriter{w: cw, columns: columns}, cw.Write(columns)
+	case "jsonl":
+		return &jsonlExportWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	case "parquet":
+		return newParquetExportWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unsupported format %q: want csv, jsonl or parquet", format)
+}
+
+// exportValue returns the value of the column of the item: int64, string or time.Time.
+func exportValue(item *ExportItem, column string) any {
+	switch column {
+	case "id":
+		return int64(item.ID)
+	case "name":
+		return item.Name
+	case "category_id":
+		return int64(item.CategoryID)
+	case "category":
+		return item.Category
+	case "image_name":
+		return item.Image
+	case "updated_at":
+		return item.UpdatedAt.UTC()
+	}
+	panic("unknown export column " + column)
+}
+
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (e *csvExportWriter) Write(item *ExportItem) error {
+	record := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		switch v := exportValue(item, column).(type) {
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case string:
+			record[i] = v
+		case time.Time:
+			record[i] = v.Format(time.RFC3339)
+		}
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExportWriter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonlExportWriter writes an object per line with the keys in the order of the columns.
+type jsonlExportWriter struct {
+	w       *bufio.Writer
+	columns []string
+}
+
+func (e *jsonlExportWriter) Write(item *ExportItem) error {
+	e.w.WriteByte('{')
+	for i, column := range e.columns {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		v := exportValue(item, column)
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339)
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.w, "%q:%s", column, value)
+	}
+	_, err := e.w.WriteString("}\n")
+	return err
+}
+
+func (e *jsonlExportWriter) Close() error {
+	return e.w.Flush()
+}
+
+type parquetExportWriter struct {
+	w       *writer.CSVWriter
+	out     *bufio.Writer
+	columns []string
+}
+
+func newParquetExportWriter(w io.Writer, columns []string) (*parquetExportWriter, error) {
+	schema := make([]string, len(columns))
+	for i, column := range columns {
+		switch exportValue(&ExportItem{}, column).(type) {
+		case int64:
+			schema[i] = fmt.Sprintf("name=%s, type=INT64", column)
+		case string:
+			schema[i] = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8", column)
+		case time.Time:
+			schema[i] = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MILLIS", column)
+		}
+	}
+	out := bufio.NewWriter(w)
+	pw, err := writer.NewCSVWriterFromWriter(schema, out, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	return &parquetExportWriter{w: pw, out: out, columns: columns}, nil
+}
+
+func (e *parquetExportWriter) Write(item *ExportItem) error {
+	record := make([]any, len(e.columns))
+	for i, column := range e.columns {
+		v := exportValue(item, column)
+		if t, ok := v.(time.Time); ok {
+			v = t.UnixMilli()
+		}
+		record[i] = v
+	}
+	return e.w.Write(record)
+}
+
+func (e *parquetExportWriter) Close() error {
+	if err := e.w.WriteStop(); err != nil {
+		return err
+	}
+	return e.out.Flush()
+}
+
+// ExportItems is a handler to stream the items joined with their categories for GET /items/export .
+// The rows are written as they are read from the database, so the export is not limited by memory.
+func (s *Handlers) ExportItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	verr := &ValidationError{}
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		verr.add("format", "must be one of csv, jsonl and parquet")
+	}
+	columns, err := ParseExportColumns(query.Get("columns"))
+	if err != nil {
+		verr.add("columns", "%s", err.Error())
+	}
+	filter := ExportFilter{Category: query.Get("category"), Keyword: query.Get("keyword")}
+	if since := query.Get("updated_since"); since != "" {
+		filter.UpdatedSince, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			verr.add("updated_since", "must be a date-time such as 2025-01-02T15:04:05Z")
+		}
+	}
+	if len(verr.Fields) > 0 {
+		writeValidationError(w, r, verr)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="items.%s"`, format))
+	res := newExportResponse(w)
+	ew, err := NewExportWriter(res, format, columns)
+	if err == nil {
+		err = s.itemRepo.ExportItems(ctx, filter, ew.Write)
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		slog.Error("failed to export items", "error", err)
+		if !res.started {
+			w.Header().Del("Content-Disposition")
+			writeJSONError(w, r, http.StatusInternalServerError, "failed to export items")
+			return
+		}
+		// the status has been sent with the first rows, so abort the response
+		// for the client to see a truncated body instead of a complete export
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// exportResponse records whether any of the export has been sent, and gives each write exportWriteTimeout.
+// The writers buffer the first rows, so errors before the buffer is flushed can still be reported.
+type exportResponse struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	started bool
+}
+
+func newExportResponse(w http.ResponseWriter) *exportResponse {
+	return &exportResponse{ResponseWriter: w, rc: http.NewResponseController(w)}
+}
+
+func (e *exportResponse) Write(p []byte) (int, error) {
+	e.started = true
+	// not supported by test recorders and some middleware; the write is then unbounded as before
+	if err := e.rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return e.ResponseWriter.Write(p)
+}
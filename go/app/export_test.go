@@ -0,0 +1,366 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"go.uber.org/mock/gomock"
+)
+
+var exportTestItems = []*ExportItem{
+	{ID: 1, Name: `jacket, "blue"`, CategoryID: 1, Category: "fashion", Image: "default.jpg", UpdatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+	{ID: 2, Name: "ジャケット", CategoryID: 2, Category: "outer", Image: "a.jpg", UpdatedAt: time.Date(2025, 2, 3, 4, 5, 6, 0, time.UTC)},
+}
+
+func TestParseExportColumns(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		columns string
+		want    []string
+		wantErr bool
+	}{
+		"ok: all columns by default": {
+			columns: "",
+			want:    ExportColumns,
+		},
+		"ok: columns are sorted": {
+			columns: "updated_at, id,name",
+			want:    []string{"id", "name", "updated_at"},
+		},
+		"ng: unknown column": {
+			columns: "id,price",
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseExportColumns(tt.columns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected columns (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExportWriter(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		format  string
+		columns []string
+		want    string
+	}{
+		"csv": {
+			format:  "csv",
+			columns: ExportColumns,
+			want: "id,name,category_id,category,image_name,updated_at\n" +
+				"1,\"jacket, \"\"blue\"\"\",1,fashion,default.jpg,2025-01-02T03:04:05Z\n" +
+				"2,ジャケット,2,outer,a.jpg,2025-02-03T04:05:06Z\n",
+		},
+		"csv with columns": {
+			format:  "csv",
+			columns: []string{"id", "category"},
+			want:    "id,category\n1,fashion\n2,outer\n",
+		},
+		"jsonl": {
+			format:  "jsonl",
+			columns: []string{"id", "name", "updated_at"},
+			want: `{"id":1,"name":"jacket, \"blue\"","updated_at":"2025-01-02T03:04:05Z"}` + "\n" +
+				`{"id":2,"name":"ジャケット","updated_at":"2025-02-03T04:05:06Z"}` + "\n",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			ew, err := NewExportWriter(&buf, tt.format, tt.columns)
+			if err != nil {
+				t.Fatalf("failed to create writer: %v", err)
+			}
+			for _, item := range exportTestItems {
+				if err := ew.Write(item); err != nil {
+					t.Fatalf("failed to write item: %v", err)
+				}
+			}
+			if err := ew.Close(); err != nil {
+				t.Fatalf("failed to close writer: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, buf.String()); diff != "" {
+				t.Errorf("unexpected export (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParquetExportWriter(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	columns := []string{"id", "name", "updated_at"}
+	ew, err := NewExportWriter(&buf, "parquet", columns)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	for _, item := range exportTestItems {
+		if err := ew.Write(item); err != nil {
+			t.Fatalf("failed to write item: %v", err)
+		}
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+
+	f, err := buffer.NewBufferFile(buf.Bytes())
+	if err != nil {
+		t.Fatalf("failed to open export: %v", err)
+	}
+	pr, err := reader.NewParquetColumnReader(f, 1)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	if n := pr.GetNumRows(); n != int64(len(exportTestItems)) {
+		t.Errorf("unexpected number of rows. want=%d, got=%d", len(exportTestItems), n)
+	}
+	want := [][]any{
+		{int64(1), int64(2)},
+		{`jacket, "blue"`, "ジャケット"},
+		{exportTestItems[0].UpdatedAt.UnixMilli(), exportTestItems[1].UpdatedAt.UnixMilli()},
+	}
+	for i, column := range columns {
+		got, _, _, err := pr.ReadColumnByIndex(int64(i), int64(len(exportTestItems)))
+		if err != nil {
+			t.Fatalf("failed to read column %s: %v", column, err)
+		}
+		if diff := cmp.Diff(want[i], got); diff != "" {
+			t.Errorf("unexpected values of %s (-want +got):\n%s", column, diff)
+		}
+	}
+}
+
+func TestExportItems(t *testing.T) {
+	t.Parallel()
+
+	exportAll := func(m *MockItemRepository) {
+		m.EXPECT().ExportItems(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ context.Context, _ ExportFilter, fn func(*ExportItem) error) error {
+				for _, item := range exportTestItems {
+					if err := fn(item); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+	}
+	cases := map[string]struct {
+		target          string
+		injector        func(m *MockItemRepository)
+		wantCode        int
+		wantContentType string
+		wantBody        string
+	}{
+		"ok: csv by default": {
+			target: "/items/export?columns=id,name",
+			injector: func(m *MockItemRepository) {
+				exportAll(m)
+			},
+			wantCode:        http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody:        "id,name\n1,\"jacket, \"\"blue\"\"\"\n2,ジャケット\n",
+		},
+		"ok: filters are passed to the repository": {
+			target: "/items/export?format=jsonl&columns=id&category=outer&keyword=jacket&updated_since=2025-02-01T09:00:00%2B09:00",
+			injector: func(m *MockItemRepository) {
+				filter := ExportFilter{Category: "outer", Keyword: "jacket", UpdatedSince: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)}
+				m.EXPECT().ExportItems(gomock.Any(), gomock.Cond(func(f ExportFilter) bool {
+					return f.Category == filter.Category && f.Keyword == filter.Keyword && f.UpdatedSince.Equal(filter.UpdatedSince)
+				}), gomock.Any()).DoAndReturn(func(_ context.Context, _ ExportFilter, fn func(*ExportItem) error) error {
+					return fn(exportTestItems[1])
+				})
+			},
+			wantCode:        http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        `{"id":2}` + "\n",
+		},
+		"ng: invalid parameters": {
+			target:          "/items/export?format=xlsx&columns=price&updated_since=yesterday",
+			injector:        func(m *MockItemRepository) {},
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "application/json",
+		},
+		"ng: failed to export": {
+			target: "/items/export",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ExportItems(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("failed to query"))
+			},
+			wantCode:        http.StatusInternalServerError,
+			wantContentType: "application/json",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			res := httptest.NewRecorder()
+			h.ExportItems(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if got := res.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("unexpected Content-Type. want=%s, got=%s", tt.wantContentType, got)
+			}
+			if tt.wantBody != "" {
+				if diff := cmp.Diff(tt.wantBody, res.Body.String()); diff != "" {
+					t.Errorf("unexpected body (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+// TestExportItemsFilter checks the filters against SQLite, where updated_at is written in different formats.
+func TestExportItemsFilter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := context.Background()
+	repo := NewItemRepositoryWithDB(db)
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion", Image: defaultImageName},
+		{Name: "shirt", Category: "fashion", Image: defaultImageName},
+		{Name: "down jacket", Category: "outer", Image: defaultImageName},
+	} {
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	// written by CURRENT_TIMESTAMP as in the migrations
+	if _, err := db.Exec(`UPDATE items SET updated_at = '2025-01-02 03:04:05' WHERE id = 1`); err != nil {
+		t.Fatalf("failed to set updated_at: %v", err)
+	}
+
+	cases := map[string]struct {
+		filter ExportFilter
+		want   []int
+	}{
+		"all items": {
+			want: []int{1, 2, 3},
+		},
+		"category": {
+			filter: ExportFilter{Category: "fashion"},
+			want:   []int{1, 2},
+		},
+		"keyword": {
+			filter: ExportFilter{Keyword: "jacket"},
+			want:   []int{1, 3},
+		},
+		"updated since": {
+			filter: ExportFilter{UpdatedSince: time.Date(2025, 1, 2, 3, 4, 6, 0, time.UTC)},
+			want:   []int{2, 3},
+		},
+		"updated since the exact time": {
+			filter: ExportFilter{Category: "fashion", UpdatedSince: time.Date(2025, 1, 2, 12, 4, 5, 0, time.FixedZone("JST", 9*60*60))},
+			want:   []int{1, 2},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got []int
+			err := repo.ExportItems(ctx, tt.filter, func(item *ExportItem) error {
+				got = append(got, item.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("failed to export items: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestExportItemsBatches checks that the items are exported once each across the batches,
+// and that the database can be written while the export is waiting for fn.
+func TestExportItemsBatches(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := context.Background()
+	repo := NewItemRepositoryWithDB(db)
+	items := make([]*Item, 2*exportBatchSize+1)
+	for i := range items {
+		items[i] = &Item{Name: "jacket", Category: []string{"fashion", "outer"}[i%2], Image: defaultImageName}
+	}
+	if err := repo.InsertBatch(ctx, items); err != nil {
+		t.Fatalf("failed to insert items: %v", err)
+	}
+
+	var got []int
+	err = repo.ExportItems(ctx, ExportFilter{Category: "fashion"}, func(item *ExportItem) error {
+		got = append(got, item.ID)
+		if len(got) == exportBatchSize {
+			// added after the batches read so far, so it is exported as well
+			return repo.Insert(ctx, &Item{Name: "shirt", Category: "fashion", Image: defaultImageName})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to export items: %v", err)
+	}
+	var want []int
+	for id := 1; id <= len(items); id += 2 {
+		want = append(want, id)
+	}
+	want = append(want, len(items)+1)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+}
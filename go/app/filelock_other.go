@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package app
+
+import "os"
+
+// lockFile does nothing on platforms without file locks, such as wasip1.
+// Only one process may use the JSON file there.
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information. These are injected at build time, e.g.
+//
+//	go build -ldflags "-X mercari-build-training/app.commit=$(git rev-parse HEAD) -X mercari-build-training/app.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When they are not set, the VCS information embedded by the go command is used if available.
+var (
+	commit    = ""
+	buildTime = ""
+)
+
+type VersionResponse struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildVersion returns the build information of the running binary.
+func buildVersion() VersionResponse {
+	v := VersionResponse{
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && v.Commit == "":
+				v.Commit = s.Value
+			case s.Key == "vcs.time" && v.BuildTime == "":
+				v.BuildTime = s.Value
+			}
+		}
+	}
+	if v.Commit == "" {
+		v.Commit = "unknown"
+	}
+	if v.BuildTime == "" {
+		v.BuildTime = "unknown"
+	}
+	return v
+}
+
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz is a handler for the liveness probe GET /healthz .
+// It only reports that the process is able to serve HTTP requests.
+func (s *Handlers) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readyz is a handler for the readiness probe GET /readyz .
+// It reports whether the database is reachable, the schema is up to date
+// (or the JSON file is readable if items are stored in it) and the image directory is writable.
+func (s *Handlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	resp := HealthResponse{Status: "ok", Checks: map[string]string{}}
+	fail := func(check string, err error) {
+		slog.Warn("readiness check failed", "check", check, "error", err)
+		resp.Status = "unavailable"
+		resp.Checks[check] = err.Error()
+	}
+
+	if s.jsonStore != nil {
+		if err := s.jsonStore.Ping(ctx); err != nil {
+			fail("store", err)
+		} else {
+			resp.Checks["store"] = "ok"
+		}
+	} else if s.db == nil {
+		fail("database", fmt.Errorf("database is not configured"))
+	} else if err := s.db.PingContext(ctx); err != nil {
+		fail("database", err)
+	} else {
+		resp.Checks["database"] = "ok"
+
+		version, err := schemaVersion(ctx, s.db)
+		if err != nil {
+			fail("migrations", err)
+		} else if version != latestSchemaVersion() {
+			fail("migrations", fmt.Errorf("schema version is %d, want %d", version, latestSchemaVersion()))
+		} else {
+			resp.Checks["migrations"] = "ok"
+		}
+	}
+
+	if err := checkDirWritable(s.imgDirPath); err != nil {
+		fail("images", err)
+	} else {
+		resp.Checks["images"] = "ok"
+	}
+
+	code := http.StatusOK
+	if resp.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, resp)
+}
+
+// Version is a handler to return the build information for GET /version .
+func (s *Handlers) Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(buildVersion())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeHealth(w http.ResponseWriter, code int, resp HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to write health response", "error", err)
+	}
+}
+
+// checkDirWritable checks that a file can be created in dir.
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthz(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	res := httptest.NewRecorder()
+
+	h := &Handlers{}
+	h.Healthz(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Errorf("unexpected status code. want=%d, got=%d", http.StatusOK, res.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+		resp HealthResponse
+	}
+	cases := map[string]struct {
+		migrated bool
+		wants
+	}{
+		"ok: migrated database": {
+			migrated: true,
+			wants: wants{
+				code: http.StatusOK,
+				resp: HealthResponse{
+					Status: "ok",
+					Checks: map[string]string{"database": "ok", "migrations": "ok", "images": "ok"},
+				},
+			},
+		},
+		"ng: pending migrations": {
+			migrated: false,
+			wants: wants{
+				code: http.StatusServiceUnavailable,
+				resp: HealthResponse{
+					Status: "unavailable",
+					Checks: map[string]string{"database": "ok", "migrations": fmt.Sprintf("schema version is 0, want %d", latestSchemaVersion()), "images": "ok"},
+				},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			db, err := openSQLite(filepath.Join(dir, "mercari.sqlite3"), defaultSQLitePragmas)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if tt.migrated {
+				if err := migrate(context.Background(), db); err != nil {
+					t.Fatalf("failed to migrate database: %v", err)
+				}
+			}
+
+			req := httptest.NewRequest("GET", "/readyz", nil)
+			res := httptest.NewRecorder()
+
+			h := &Handlers{imgDirPath: dir, db: db}
+			h.Readyz(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Errorf("unexpected status code. want=%d, got=%d", tt.wants.code, res.Code)
+			}
+			var got HealthResponse
+			if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to unmarshal response body: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.resp, got); diff != "" {
+				t.Errorf("unexpected response body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadyzJSONStore(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store, err := NewJSONItemRepository(filepath.Join(dir, "mercari.json"))
+	if err != nil {
+		t.Fatalf("failed to open JSON store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	res := httptest.NewRecorder()
+
+	h := &Handlers{imgDirPath: dir, jsonStore: store}
+	h.Readyz(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Errorf("unexpected status code. want=%d, got=%d", http.StatusOK, res.Code)
+	}
+	var got HealthResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal response body: %v", err)
+	}
+	want := HealthResponse{Status: "ok", Checks: map[string]string{"store": "ok", "images": "ok"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected response body (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// immutableCacheControl is sent with content-addressed images.
+// The file name is the hash of the content, so the response for a name never changes.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// itemETag returns a strong ETag of an item, which changes with its version whenever the item is updated.
+func itemETag(item *Item) string {
+	return fmt.Sprintf(`"item-%d-v%d"`, item.ID, item.Version)
+}
+
+// ifMatchItem reports whether the If-Match header of r allows a change of the item with etag from itemETag.
+// The tags are compared without their W/ prefix: an item ETag names a version of the item rather than
+// the bytes of a response, and compressionMiddleware weakens it on compressed responses.
+// Callers check that the header is present; without it nothing matches.
+func ifMatchItem(r *http.Request, etag string) bool {
+	for _, value := range r.Header.Values("If-Match") {
+		for tag := range strings.SplitSeq(value, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// itemsETag returns a strong ETag of a list of items and the time the list was last modified.
+// The number of items is part of the tag so that a removed item changes it as well.
+func itemsETag(items []*Item) (string, time.Time) {
+	var lastModified time.Time
+	for _, item := range items {
+		if item.UpdatedAt.After(lastModified) {
+			lastModified = item.UpdatedAt
+		}
+	}
+	return fmt.Sprintf(`"items-%d-%s"`, len(items), strconv.FormatInt(lastModified.UnixNano(), 36)), lastModified
+}
+
+// writeCacheableJSON writes v as JSON with the validators etag and lastModified.
+// http.ServeContent answers If-None-Match and If-Modified-Since with 304 Not Modified.
+// A zero lastModified omits Last-Modified.
+func writeCacheableJSON(w http.ResponseWriter, r *http.Request, v any, etag string, lastModified time.Time) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	// clients may keep the response but must revalidate it, which is cheap with the ETag
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(body.Bytes()))
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+)
+
+func TestItemCaching(t *testing.T) {
+	t.Parallel()
+
+	updatedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion", Image: "default.jpg", UpdatedAt: updatedAt}
+	listETag, _ := itemsETag([]*Item{item})
+	// another item was deleted after item was changed
+	deletedAt := updatedAt.Add(time.Hour)
+
+	cases := map[string]struct {
+		target      string
+		header      map[string]string
+		wantCode    int
+		wantETag    string
+		wantModTime string
+	}{
+		"ok: item has validators": {
+			target:      "/items/1",
+			wantCode:    http.StatusOK,
+			wantETag:    itemETag(item),
+			wantModTime: updatedAt.Format(http.TimeFormat),
+		},
+		"ok: item is not modified": {
+			target:   "/items/1",
+			header:   map[string]string{"If-None-Match": itemETag(item)},
+			wantCode: http.StatusNotModified,
+			wantETag: itemETag(item),
+		},
+		"ok: item was modified": {
+			target:   "/items/1",
+			header:   map[string]string{"If-None-Match": `"item-1-0"`},
+			wantCode: http.StatusOK,
+			wantETag: itemETag(item),
+		},
+		"ok: item is not modified since": {
+			target:   "/items/1",
+			header:   map[string]string{"If-Modified-Since": updatedAt.Format(http.TimeFormat)},
+			wantCode: http.StatusNotModified,
+			wantETag: itemETag(item),
+		},
+		"ok: list was modified by a deletion": {
+			target:      "/items",
+			wantCode:    http.StatusOK,
+			wantETag:    listETag,
+			wantModTime: deletedAt.Format(http.TimeFormat),
+		},
+		"ok: list is modified since the item was changed": {
+			target:   "/items",
+			header:   map[string]string{"If-Modified-Since": updatedAt.Format(http.TimeFormat)},
+			wantCode: http.StatusOK,
+			wantETag: listETag,
+		},
+		"ok: list is not modified": {
+			target:   "/items",
+			header:   map[string]string{"If-None-Match": listETag},
+			wantCode: http.StatusNotModified,
+			wantETag: listETag,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			mockIR.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil).AnyTimes()
+			mockIR.EXPECT().GetAll(gomock.Any()).Return([]*Item{item}, nil).AnyTimes()
+			mockIR.EXPECT().LastModified(gomock.Any()).Return(deletedAt, nil).AnyTimes()
+			h := &Handlers{itemRepo: mockIR}
+
+			mux := http.NewServeMux()
+			for _, rt := range routes(h) {
+				mux.Handle(rt.pattern, rt.handler)
+			}
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d", tt.wantCode, res.Code)
+			}
+			if got := res.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("unexpected ETag. want=%s, got=%s", tt.wantETag, got)
+			}
+			if tt.wantModTime != "" && res.Header().Get("Last-Modified") != tt.wantModTime {
+				t.Errorf("unexpected Last-Modified. want=%s, got=%s", tt.wantModTime, res.Header().Get("Last-Modified"))
+			}
+		})
+	}
+}
+
+func TestGetImageCaching(t *testing.T) {
+	t.Parallel()
+
+	hash := strings.Repeat("a", 64)
+	dir := t.TempDir()
+	for _, name := range []string{hash + ".jpg", "default.jpg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+	h := &Handlers{imgDirPath: dir}
+
+	cases := map[string]struct {
+		target           string
+		header           map[string]string
+		wantCode         int
+		wantCacheControl string
+	}{
+		"ok: content-addressed image is immutable": {
+			target:           "/images/" + hash + ".jpg",
+			wantCode:         http.StatusOK,
+			wantCacheControl: immutableCacheControl,
+		},
+		"ok: content-addressed image is not modified": {
+			target:           "/images/" + hash + ".jpg",
+			header:           map[string]string{"If-None-Match": `"` + hash + `"`},
+			wantCode:         http.StatusNotModified,
+			wantCacheControl: immutableCacheControl,
+		},
+		"ok: default image is not immutable": {
+			target:   "/images/default.jpg",
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /images/{filename}", h.GetImage)
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d", tt.wantCode, res.Code)
+			}
+			if got := res.Header().Get("Cache-Control"); got != tt.wantCacheControl {
+				t.Errorf("unexpected Cache-Control. want=%q, got=%q", tt.wantCacheControl, got)
+			}
+		})
+	}
+}
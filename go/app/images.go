@@ -0,0 +1,117 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// parsePlaceholderImages parses the placeholder images per category, e.g. "fashion=fashion.jpg,phone=phone.jpg",
+// as set in PLACEHOLDER_IMAGES. The images are file names in the image directory.
+func parsePlaceholderImages(s string) (map[string]string, error) {
+	placeholders := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		category, name, ok := strings.Cut(pair, "=")
+		category, name = strings.TrimSpace(category), strings.TrimSpace(name)
+		if !ok || category == "" {
+			return nil, fmt.Errorf("invalid placeholder %q: want <category>=<image>", pair)
+		}
+		if name != filepath.Base(name) || filepath.Ext(name) != ".jpg" {
+			return nil, fmt.Errorf("invalid placeholder image %q for %s: want a .jpg file name", name, category)
+		}
+		placeholders[category] = name
+	}
+	return placeholders, nil
+}
+
+// PlaceholderImages returns the placeholder images per category set in PLACEHOLDER_IMAGES,
+// for tools that add items to the database without the server.
+func PlaceholderImages() (map[string]string, error) {
+	placeholders, err := parsePlaceholderImages(os.Getenv("PLACEHOLDER_IMAGES"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PLACEHOLDER_IMAGES: %w", err)
+	}
+	return placeholders, nil
+}
+
+// PlaceholderImage returns the image of items of the category without an image,
+// given the placeholders returned by PlaceholderImages.
+func PlaceholderImage(placeholders map[string]string, category string) string {
+	if name, ok := placeholders[category]; ok {
+		return name
+	}
+	return defaultImageName
+}
+
+// placeholderImage returns the image shown for items of the category without an image.
+func (s *Handlers) placeholderImage(category string) string {
+	return PlaceholderImage(s.placeholders, category)
+}
+
+// checkPlaceholderImages warns about placeholders missing from the image directory,
+// since GetImage cannot fall back to them.
+func (s *Handlers) checkPlaceholderImages() {
+	names := []string{defaultImageName}
+	for _, name := range s.placeholders {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(s.imgDirPath, name)); err != nil {
+			slog.Warn("placeholder image is not available", "image", name, "error", err)
+		}
+	}
+}
+
+// imageMetadata returns the metadata of an image to record in ImageRepository.
+// The dimensions and hashes are left zero if the image cannot be decoded.
+func imageMetadata(name string, data []byte) *Image {
+	img := &Image{
+		Name:     name,
+		Size:     int64(len(data)),
+		MIMEType: http.DetectContentType(data),
+	}
+	if decoded, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+		img.Width, img.Height = decoded.Bounds().Dx(), decoded.Bounds().Dy()
+		img.AHash, img.DHash = perceptualHashes(decoded)
+	}
+	return img
+}
+
+// ImageReport is a handler to return the storage used by images for GET /admin/images .
+// It requires the admin token.
+func (s *Handlers) ImageReport(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.imageRepo == nil {
+		writeJSONError(w, r, http.StatusNotFound, "image metadata is not available")
+		return
+	}
+
+	report, err := s.imageRepo.Report(r.Context())
+	if err != nil {
+		slog.Error("failed to report images", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "failed to report images")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
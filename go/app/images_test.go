@@ -0,0 +1,267 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestImageMetadata(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3, 2)), nil); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	cases := map[string]struct {
+		data []byte
+		want *Image
+	}{
+		"ok: jpeg": {
+			data: buf.Bytes(),
+			want: &Image{Name: "a.jpg", Size: int64(buf.Len()), MIMEType: "image/jpeg", Width: 3, Height: 2},
+		},
+		"ok: not an image": {
+			data: []byte("jacket"),
+			want: &Image{Name: "a.jpg", Size: 6, MIMEType: "text/plain; charset=utf-8"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if diff := cmp.Diff(tt.want, imageMetadata("a.jpg", tt.data)); diff != "" {
+				t.Errorf("unexpected metadata (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParsePlaceholderImages(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		"ok: empty": {
+			value: "",
+			want:  map[string]string{},
+		},
+		"ok: categories": {
+			value: "fashion=fashion.jpg, phone = phone.jpg",
+			want:  map[string]string{"fashion": "fashion.jpg", "phone": "phone.jpg"},
+		},
+		"ng: no image": {
+			value:   "fashion",
+			wantErr: true,
+		},
+		"ng: path": {
+			value:   "fashion=../fashion.jpg",
+			wantErr: true,
+		},
+		"ng: not a jpg": {
+			value:   "fashion=fashion.png",
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parsePlaceholderImages(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected placeholders (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestImageReportHandler(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		authorization string
+		injector      func(m *MockImageRepository)
+		noImageRepo   bool
+		wantCode      int
+	}{
+		"ok: reported to admin": {
+			authorization: "Bearer secret",
+			injector: func(m *MockImageRepository) {
+				m.EXPECT().Report(gomock.Any()).Return(&ImageReport{Images: 1}, nil)
+			},
+			wantCode: http.StatusOK,
+		},
+		"ng: no token": {
+			injector: func(m *MockImageRepository) {},
+			wantCode: http.StatusUnauthorized,
+		},
+		"ng: image metadata not available": {
+			authorization: "Bearer secret",
+			noImageRepo:   true,
+			wantCode:      http.StatusNotFound,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{adminToken: "secret"}
+			if !tt.noImageRepo {
+				ctrl := gomock.NewController(t)
+				mockIMR := NewMockImageRepository(ctrl)
+				tt.injector(mockIMR)
+				h.imageRepo = mockIMR
+			}
+
+			req := httptest.NewRequest("GET", "/admin/images", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res := httptest.NewRecorder()
+			h.ImageReport(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestImageRefCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := context.Background()
+	shared := strings.Repeat("a", 64) + ".jpg"
+	single := strings.Repeat("b", 64) + ".jpg"
+	unused := strings.Repeat("c", 64) + ".jpg"
+
+	imageRepo := NewImageRepositoryWithDB(db)
+	for _, name := range []string{shared, single, unused} {
+		if err := imageRepo.SaveImage(ctx, &Image{Name: name, Size: 100, MIMEType: "image/jpeg"}); err != nil {
+			t.Fatalf("failed to save image: %v", err)
+		}
+	}
+
+	itemRepo := NewItemRepositoryWithDB(db)
+	for _, name := range []string{shared, shared, shared, single, "default.jpg"} {
+		if err := itemRepo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", Image: name}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	report, err := imageRepo.Report(ctx)
+	if err != nil {
+		t.Fatalf("failed to report images: %v", err)
+	}
+	want := &ImageReport{
+		Images:          3,
+		Unreferenced:    1,
+		StoredBytes:     300,
+		ReferencedBytes: 500,
+		SavedBytes:      200,
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%s", diff)
+	}
+
+	// only unreferenced images can be deleted
+	for name, wantDeleted := range map[string]bool{shared: false, unused: true} {
+		deleted, err := imageRepo.DeleteUnreferencedImage(ctx, name)
+		if err != nil {
+			t.Fatalf("failed to delete image: %v", err)
+		}
+		if deleted != wantDeleted {
+			t.Errorf("unexpected deletion of %s. want=%v, got=%v", name, wantDeleted, deleted)
+		}
+	}
+}
+
+// TestMigrateImageRefCount checks that the migration counts the references of existing items.
+func TestMigrateImageRefCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "*.sqlite3")
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	f.Close()
+	db, err := openSQLite(f.Name(), defaultSQLitePragmas)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	shared := strings.Repeat("a", 64) + ".jpg"
+
+	// items written at schema version 2, before the images table existed
+	for _, m := range migrations[:2] {
+		if _, err := db.ExecContext(ctx, m); err != nil {
+			t.Fatalf("failed to apply migration: %v", err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, `PRAGMA user_version = 2`); err != nil {
+		t.Fatalf("failed to set schema version: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO categories (name) VALUES ('fashion')`); err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+	for _, name := range []string{shared, shared, "default.jpg"} {
+		if _, err := db.ExecContext(ctx, `INSERT INTO items (name, category_id, image_name) VALUES ('jacket', 1, ?)`, name); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	if err := migrate(ctx, db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	var images, refCount int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*), SUM(ref_count) FROM images WHERE name = ?`, shared).Scan(&images, &refCount)
+	if err != nil {
+		t.Fatalf("failed to read images: %v", err)
+	}
+	if images != 1 || refCount != 2 {
+		t.Errorf("unexpected reference count of %s. want=2, got=%d", shared, refCount)
+	}
+	var total int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM images`).Scan(&total); err != nil {
+		t.Fatalf("failed to read images: %v", err)
+	}
+	if total != 1 {
+		t.Errorf("only content-addressed images should be counted, got %d images", total)
+	}
+}
@@ -1,22 +1,48 @@
 package app
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
-	"sync"
-
-	_ "github.com/mattn/go-sqlite3"
+	"os"
+	"slices"
+	"time"
 )
 
 var errImageNotFound = errors.New("image not found")
 
+var (
+	errCategoryExists   = errors.New("category already exists")
+	errCategoryNotEmpty = errors.New("category has items")
+	errItemNotDeleted   = errors.New("item is not deleted")
+)
+
 type Item struct {
 	ID       int    `db:"id" json:"id"`
 	Name     string `db:"name" json:"name"`
 	Category string `db:"category" json:"category"`
 	Image    string `db:"image" json:"image_name"`
+	// UpdatedAt is the last time the item was changed; it is sent as ETag and Last-Modified, not in the body.
+	UpdatedAt time.Time `db:"updated_at" json:"-"`
+	// Version is incremented by every change of the item; it is sent in the ETag.
+	// Update and Delete change the item only if it is still at this version.
+	Version int `db:"version" json:"-"`
+}
+
+// ItemConflictError is returned by Update and Delete when the item was changed since it was read,
+// so that the change would overwrite the other one.
+type ItemConflictError struct {
+	ID int
+	// Version is the version the item was expected to be at.
+	Version int
+}
+
+func (e *ItemConflictError) Error() string {
+	return fmt.Sprintf("item %d was changed since version %d", e.ID, e.Version)
 }
 
 // Please run `go generate ./...` to generate the mock implementation
@@ -26,48 +52,191 @@ type Item struct {
 type ItemRepository interface {
 	CategoryInsert(ctx context.Context, categoryName string) (int, error)
 	Insert(ctx context.Context, item *Item) error
+	// InsertBatch inserts the items in a single transaction.
+	InsertBatch(ctx context.Context, items []*Item) error
+	// GetAll, GetByID, SearchByKeyword, ExportItems and SimilarItems do not return deleted items,
+	// unless ExportFilter.Deleted is set.
 	GetAll(ctx context.Context) ([]*Item, error)
 	GetByID(ctx context.Context, id int) (*Item, error)
+	// LastModified returns the last time an item was added, changed or deleted, deleted items included,
+	// or the zero time if there are no items. GetAll alone cannot tell when an item was last deleted.
+	LastModified(ctx context.Context) (time.Time, error)
 	SearchByKeyword(ctx context.Context, keyword string) ([]*Item, error)
+	// ExportItems calls fn with the items matching filter one at a time in ID order,
+	// without loading them all in memory. It stops at the first error returned by fn.
+	ExportItems(ctx context.Context, filter ExportFilter, fn func(*ExportItem) error) error
+	// CountByImage and ReferencedImages count deleted items as well, since their images are kept for Restore.
+	CountByImage(ctx context.Context, imageName string) (int, error)
+	// ReferencedImages returns the number of items referring to each image.
+	ReferencedImages(ctx context.Context) (map[string]int, error)
+	// ReplaceImage makes the items referring to oldName refer to newName and returns the number of items changed.
+	ReplaceImage(ctx context.Context, oldName, newName string) (int, error)
+	// SimilarItems returns the items whose image looks like imageName within maxDistance,
+	// sorted by distance and ID. The items referring to imageName itself are included with distance 0.
+	SimilarItems(ctx context.Context, imageName string, maxDistance int) ([]*SimilarItem, error)
+	// Update changes the name, category and image of the item with item.ID if it is still at item.Version,
+	// and increments item.Version. It returns sql.ErrNoRows if the item does not exist
+	// and *ItemConflictError if it was changed since.
+	Update(ctx context.Context, item *Item) error
+	// Delete marks the item as deleted if it is still at version. It returns sql.ErrNoRows
+	// if the item does not exist or is already deleted, and *ItemConflictError if it was changed since.
+	Delete(ctx context.Context, id, version int) error
+	// Restore brings back a deleted item. It returns sql.ErrNoRows if the item does not exist
+	// and errItemNotDeleted if it is not deleted.
+	Restore(ctx context.Context, id int) (*Item, error)
+	// History returns the events of the item in the order they happened, empty if there are none.
+	History(ctx context.Context, id int) ([]*ItemEvent, error)
+	// GetCategories returns all categories sorted by ID.
+	GetCategories(ctx context.Context) ([]*Category, error)
+	// RenameCategory renames the category. It returns sql.ErrNoRows if the category does not exist.
+	RenameCategory(ctx context.Context, id int, name string) error
+	// DeleteCategory deletes a category without items.
+	DeleteCategory(ctx context.Context, id int) error
+	// MergeCategories moves the items of category src to dst, deletes src and returns the number of items moved.
+	MergeCategories(ctx context.Context, src, dst int) (int, error)
+}
+
+// Category is a category with the number of items in it.
+type Category struct {
+	ID    int    `db:"id" json:"id"`
+	Name  string `db:"name" json:"name"`
+	Items int    `json:"items"`
+}
+
+// SimilarItem is an item whose image looks like another image.
+type SimilarItem struct {
+	*Item
+	// Distance is the larger Hamming distance of the aHashes and dHashes of the images, from 0 to 64.
+	Distance int `json:"distance"`
+}
+
+// ExportItem is a row of the items and categories join exported for analysis.
+type ExportItem struct {
+	ID         int
+	Name       string
+	CategoryID int
+	Category   string
+	Image      string
+	UpdatedAt  time.Time
+}
+
+// ExportFilter selects the items to export. The zero value selects all items.
+type ExportFilter struct {
+	// Category is the name of the category.
+	Category string
+	// Keyword is a part of the name.
+	Keyword string
+	// UpdatedSince selects the items changed at or after the time if set.
+	UpdatedSince time.Time
+	// Deleted includes the deleted items, e.g. to tell whether items were migrated before.
+	// It is not available through GET /items/export.
+	Deleted bool
+}
+
+// Image is the metadata of a content-addressed image in the image directory.
+// The metadata of images stored before it was recorded is unknown, so those fields may be zero.
+type Image struct {
+	// Name is "<sha256>.jpg".
+	Name     string `db:"name" json:"name"`
+	Size     int64  `db:"size" json:"size"`
+	MIMEType string `db:"mime_type" json:"mime_type"`
+	Width    int    `db:"width" json:"width"`
+	Height   int    `db:"height" json:"height"`
+	// RefCount is the number of items referring to the image.
+	// It is updated in the same transaction as the items.
+	RefCount int `db:"ref_count" json:"ref_count"`
+	// AHash and DHash are the perceptual hashes of the image, zero if unknown.
+	// Images that look the same have hashes with a small Hamming distance.
+	AHash uint64 `db:"ahash" json:"-"`
+	DHash uint64 `db:"dhash" json:"-"`
+}
+
+// ImageReport summarizes the storage used by images.
+type ImageReport struct {
+	Images int `json:"images"`
+	// Unreferenced is the number of images no item refers to, e.g. uploads not used yet.
+	Unreferenced int `json:"unreferenced"`
+	// UnknownSize is the number of images whose size has not been recorded and is not in the byte counts.
+	UnknownSize int `json:"unknown_size"`
+	// StoredBytes is the size of the image files.
+	StoredBytes int64 `json:"stored_bytes"`
+	// ReferencedBytes is the size the images would take if every item had its own copy
+	// (unreferenced images counted once).
+	ReferencedBytes int64 `json:"referenced_bytes"`
+	// SavedBytes is the size saved by sharing images between items.
+	SavedBytes int64 `json:"saved_bytes"`
+}
+
+// ImageRepository is an interface to manage the metadata of images.
+// The reference counts are maintained by ItemRepository when items are written.
+type ImageRepository interface {
+	// SaveImage records the metadata of an image without changing its reference count.
+	SaveImage(ctx context.Context, image *Image) error
+	// DeleteUnreferencedImage deletes the image if no item refers to it and reports whether it was deleted.
+	DeleteUnreferencedImage(ctx context.Context, name string) (bool, error)
+	Report(ctx context.Context) (*ImageReport, error)
 }
 
 // itemRepository is an implementation of ItemRepository
 type itemRepository struct {
-	// fileName is the path to the JSON file storing items.
-	fileName string
-	db       *sql.DB
+	// db is used for writes, read for reads outside of write transactions.
+	db   *sql.DB
+	read *sql.DB
 }
 
-// NewItemRepository creates a new itemRepository.
-func NewItemRepository() ItemRepository {
-	return &itemRepository{
-		fileName: "items.json",
-		db:       getDB(),
+// DefaultDBPath is the path to the database of the server, relative to the working directory.
+const DefaultDBPath = "db/mercari.sqlite3"
+
+// OpenDB opens the database at path with the pragmas of the server and applies pending migrations.
+// It is used by the commands working on the database of the server.
+func OpenDB(ctx context.Context, path string) (*sql.DB, error) {
+	db, err := openSQLite(path, defaultSQLitePragmas)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
 	}
+	return db, nil
 }
 
-var (
-	db   *sql.DB
-	once sync.Once
-)
+// OpenDBNoMigrate opens the existing database at path like OpenDB, but neither applies migrations
+// nor changes its journal mode, so that commands running alongside the server, such as backup,
+// leave the database as the server expects it. It refuses a schema newer than this binary supports.
+func OpenDBNoMigrate(ctx context.Context, path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	pragmas := defaultSQLitePragmas
+	pragmas.JournalMode = ""
+	db, err := openSQLite(path, pragmas)
+	if err != nil {
+		return nil, err
+	}
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version > latestSchemaVersion() {
+		db.Close()
+		return nil, fmt.Errorf("database schema version %d is newer than this binary supports (%d)", version, latestSchemaVersion())
+	}
+	return db, nil
+}
 
-// DBを読み込む
-func getDB() *sql.DB {
-	once.Do(func() { //１回だけDBを開く
-		var err error
-		db, err = sql.Open("sqlite3", "db/mercari.sqlite3")
-		if err != nil {
-			slog.Error("failed to connect to database", "error", err)
-		} else {
-			slog.Info("successfully connected to database")
-		}
-	})
-	return db
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 func (i *itemRepository) CategoryInsert(ctx context.Context, categoryName string) (int, error) {
-	db := i.db
+	return categoryInsert(ctx, i.db, categoryName)
+}
 
+func categoryInsert(ctx context.Context, db querier, categoryName string) (int, error) {
 	//既存のカテゴリIDを探す
 	var catID int
 	err := db.QueryRowContext(ctx,
@@ -94,35 +263,86 @@ func (i *itemRepository) CategoryInsert(ctx context.Context, categoryName string
 }
 
 // Insert inserts an item into the repository.
+// The reference count of the image is incremented in the same transaction.
 func (i *itemRepository) Insert(ctx context.Context, item *Item) error {
-	db := i.db
+	return i.InsertBatch(ctx, []*Item{item})
+}
+
+// InsertBatch inserts the items in a single transaction and sets their IDs.
+// Either all items are inserted or none.
+func (i *itemRepository) InsertBatch(ctx context.Context, items []*Item) error {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	catID, err := i.CategoryInsert(ctx, item.Category)
+	for _, item := range items {
+		if err := insertItem(ctx, tx, item); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func insertItem(ctx context.Context, tx querier, item *Item) error {
+	catID, err := categoryInsert(ctx, tx, item.Category)
 	if err != nil {
 		slog.Error("failed to CategoryInsert", "error", err)
 		return err
 	}
 
-	query := `INSERT INTO items (name, category_id, image_name) VALUES (?, ?, ?)`
-	_, err = db.ExecContext(ctx, query, item.Name, catID, item.Image)
+	if item.UpdatedAt.IsZero() {
+		item.UpdatedAt = time.Now().UTC()
+	}
+	query := `INSERT INTO items (name, category_id, image_name, updated_at) VALUES (?, ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, query, item.Name, catID, item.Image, item.UpdatedAt)
 
 	if err != nil {
 		slog.Error("failed to insert item", "error", err)
 		return err
 	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	item.ID, item.Version = int(id), 1
 
-	return nil
+	if err := addImageRef(ctx, tx, item.Image, 1); err != nil {
+		slog.Error("failed to count image reference", "error", err)
+		return err
+	}
+	return recordItemEvent(ctx, tx, item.ID, itemEventCreate, nil, item)
 }
 
-// GetAll：items.jsonから全商品を取得
+// addImageRef adds delta to the reference count of a content-addressed image.
+// Other images such as default.jpg are not tracked.
+func addImageRef(ctx context.Context, db querier, imageName string, delta int) error {
+	if !imageRefPattern.MatchString(imageName) || delta == 0 {
+		return nil
+	}
+	if delta < 0 {
+		// the counts of images referred to before the images table existed may be too small
+		_, err := db.ExecContext(ctx, `UPDATE images SET ref_count = MAX(ref_count + ?, 0) WHERE name = ?`, delta, imageName)
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO images (name, ref_count) VALUES (?, ?)
+            ON CONFLICT (name) DO UPDATE SET ref_count = ref_count + excluded.ref_count
+    `, imageName, delta)
+	return err
+}
+
+// GetAll：DBから全商品を取得
 func (i *itemRepository) GetAll(ctx context.Context) ([]*Item, error) {
-	db := i.db
+	db := i.read
 
 	//JOINでcategoryとitemテーブルをつなげて取得する
 	rows, err := db.QueryContext(ctx, `
-        SELECT i.id, i.name, c.name AS category, i.image_name
+        SELECT i.id, i.name, c.name AS category, i.image_name, i.updated_at, i.version
           FROM items i
           JOIN categories c ON i.category_id = c.id
+         WHERE i.deleted_at IS NULL
     `)
 	if err != nil {
 		return nil, err
@@ -133,7 +353,7 @@ func (i *itemRepository) GetAll(ctx context.Context) ([]*Item, error) {
 	var items []*Item
 	for rows.Next() {
 		var item Item
-		err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image)
+		err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image, &item.UpdatedAt, &item.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -145,17 +365,17 @@ func (i *itemRepository) GetAll(ctx context.Context) ([]*Item, error) {
 
 // IDから特定の商品を取得
 func (r *itemRepository) GetByID(ctx context.Context, id int) (*Item, error) {
-	db := r.db
+	db := r.read
 
 	row := db.QueryRowContext(ctx, `
-        SELECT i.id, i.name, c.name AS category, i.image_name
+        SELECT i.id, i.name, c.name AS category, i.image_name, i.updated_at, i.version
           FROM items i
           JOIN categories c ON i.category_id = c.id
-         WHERE i.id = ?
+         WHERE i.id = ? AND i.deleted_at IS NULL
     `, id)
 
 	var item Item
-	err := row.Scan(&item.ID, &item.Name, &item.Category, &item.Image)
+	err := row.Scan(&item.ID, &item.Name, &item.Category, &item.Image, &item.UpdatedAt, &item.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -172,7 +392,7 @@ func StoreImage(fileName string, image []byte) error {
 }
 
 func (r *itemRepository) SearchByKeyword(ctx context.Context, keyword string) ([]*Item, error) {
-	db := r.db
+	db := r.read
 
 	// ここではキーワードが無いなら エラーメッセージを返す
 	if keyword == "" {
@@ -181,11 +401,12 @@ func (r *itemRepository) SearchByKeyword(ctx context.Context, keyword string) ([
 
 	// LIKE で検索機能を実装('%' || ? || '%' で部分一致もできる)
 	rows, err := db.QueryContext(ctx, `
-        SELECT i.id, i.name, c.name AS category, i.image_name
+        SELECT i.id, i.name, c.name AS category, i.image_name, i.updated_at, i.version
           FROM items i
           JOIN categories c ON i.category_id = c.id
-         WHERE i.name LIKE '%' || ? || '%'
-		 	OR c.name LIKE '%' || ? || '%'
+         WHERE i.deleted_at IS NULL
+		   AND (i.name LIKE '%' || ? || '%'
+		 	OR c.name LIKE '%' || ? || '%')
     `, keyword, keyword)
 	if err != nil {
 		return nil, err
@@ -195,7 +416,7 @@ func (r *itemRepository) SearchByKeyword(ctx context.Context, keyword string) ([
 	var items []*Item
 	for rows.Next() {
 		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image); err != nil {
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image, &item.UpdatedAt, &item.Version); err != nil {
 			return nil, err
 		}
 		items = append(items, &item)
@@ -208,9 +429,629 @@ func (r *itemRepository) SearchByKeyword(ctx context.Context, keyword string) ([
 	return items, nil
 }
 
+// exportBatchSize is the number of items ExportItems reads at a time.
+const exportBatchSize = 500
+
+// ExportItems streams the items matching filter from the database to fn.
+// The items are read in batches by ID and the cursor is closed before fn is called,
+// so that a slow client does not hold a read connection or block the WAL checkpoints.
+// Items changed during the export appear as they were when their batch was read.
+func (r *itemRepository) ExportItems(ctx context.Context, filter ExportFilter, fn func(*ExportItem) error) error {
+	query := `
+        SELECT i.id, i.name, c.id, c.name, i.image_name, i.updated_at
+          FROM items i
+          JOIN categories c ON i.category_id = c.id
+         WHERE i.id > ?`
+	var args []any
+	if !filter.Deleted {
+		query += ` AND i.deleted_at IS NULL`
+	}
+	if filter.Category != "" {
+		query += ` AND c.name = ?`
+		args = append(args, filter.Category)
+	}
+	if filter.Keyword != "" {
+		query += ` AND i.name LIKE '%' || ? || '%'`
+		args = append(args, filter.Keyword)
+	}
+	if !filter.UpdatedSince.IsZero() {
+		// updated_at is written both by CURRENT_TIMESTAMP and by the driver, so compare it as a date
+		query += ` AND julianday(i.updated_at) >= julianday(?)`
+		args = append(args, filter.UpdatedSince.UTC().Format("2006-01-02 15:04:05.000"))
+	}
+	query += ` ORDER BY i.id LIMIT ?`
+
+	lastID := 0
+	for {
+		batch, err := r.exportBatch(ctx, query, append(append([]any{lastID}, args...), exportBatchSize))
+		if err != nil {
+			return err
+		}
+		for _, item := range batch {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+// exportBatch reads a batch of ExportItems.
+func (r *itemRepository) exportBatch(ctx context.Context, query string, args []any) ([]*ExportItem, error) {
+	rows, err := r.read.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batch := make([]*ExportItem, 0, exportBatchSize)
+	for rows.Next() {
+		var item ExportItem
+		err := rows.Scan(&item.ID, &item.Name, &item.CategoryID, &item.Category, &item.Image, &item.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, &item)
+	}
+	return batch, rows.Err()
+}
+
+func (r *itemRepository) LastModified(ctx context.Context) (time.Time, error) {
+	var lastModified time.Time
+	// updated_at is written both by CURRENT_TIMESTAMP and by the driver, so compare it as a date
+	err := r.read.QueryRowContext(ctx, `SELECT updated_at FROM items ORDER BY julianday(updated_at) DESC LIMIT 1`).Scan(&lastModified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return lastModified, err
+}
+
+// CountByImage returns the number of items referring to the image.
+func (r *itemRepository) CountByImage(ctx context.Context, imageName string) (int, error) {
+	var count int
+	err := r.read.QueryRowContext(ctx, `SELECT COUNT(*) FROM items WHERE image_name = ?`, imageName).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ReferencedImages returns the number of items referring to each image.
+func (r *itemRepository) ReferencedImages(ctx context.Context) (map[string]int, error) {
+	rows, err := r.read.QueryContext(ctx, `SELECT image_name, COUNT(*) FROM items WHERE image_name IS NOT NULL GROUP BY image_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := map[string]int{}
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		images[name] = count
+	}
+	return images, rows.Err()
+}
+
+// ReplaceImage makes the items referring to oldName refer to newName.
+// The reference counts of both images are updated in the same transaction.
+func (r *itemRepository) ReplaceImage(ctx context.Context, oldName, newName string) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before, err := queryItems(ctx, tx, `i.image_name = ?`, oldName)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE items SET image_name = ?, updated_at = ?, version = version + 1 WHERE image_name = ?`,
+		newName, time.Now().UTC(), oldName)
+	if err != nil {
+		return 0, err
+	}
+	n := len(before)
+	if err := addImageRef(ctx, tx, oldName, -n); err != nil {
+		return 0, err
+	}
+	if err := addImageRef(ctx, tx, newName, n); err != nil {
+		return 0, err
+	}
+	for _, item := range before {
+		after := *item
+		after.Image = newName
+		if err := recordItemEvent(ctx, tx, item.ID, itemEventUpdate, item, &after); err != nil {
+			return 0, err
+		}
+	}
+	return n, tx.Commit()
+}
+
+// SimilarItems returns the items whose image looks like imageName within maxDistance.
+// SQLite cannot count bits, so the distances are computed over all hashed images.
+func (r *itemRepository) SimilarItems(ctx context.Context, imageName string, maxDistance int) ([]*SimilarItem, error) {
+	var ahash, dhash sql.NullInt64
+	err := r.read.QueryRowContext(ctx, `SELECT ahash, dhash FROM images WHERE name = ?`, imageName).Scan(&ahash, &dhash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if !ahash.Valid || !dhash.Valid {
+		// unknown images and images without hashes cannot be compared
+		return []*SimilarItem{}, nil
+	}
+
+	rows, err := r.read.QueryContext(ctx, `
+        SELECT i.id, i.name, c.name AS category, i.image_name, i.updated_at, i.version, im.ahash, im.dhash
+          FROM items i
+          JOIN categories c ON i.category_id = c.id
+          JOIN images im ON i.image_name = im.name
+         WHERE im.ahash IS NOT NULL AND im.dhash IS NOT NULL AND i.deleted_at IS NULL
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	similar := []*SimilarItem{}
+	for rows.Next() {
+		var item Item
+		var a, d int64
+		err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image, &item.UpdatedAt, &item.Version, &a, &d)
+		if err != nil {
+			return nil, err
+		}
+		distance := max(hashDistance(uint64(ahash.Int64), uint64(a)), hashDistance(uint64(dhash.Int64), uint64(d)))
+		if distance <= maxDistance {
+			similar = append(similar, &SimilarItem{Item: &item, Distance: distance})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(similar, func(a, b *SimilarItem) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.ID, b.ID))
+	})
+	return similar, nil
+}
+
+// Update changes the item and moves its reference from the old image to the new one in the same transaction.
+// The version is compared and incremented by the UPDATE itself, so that no other change can come in between.
+func (r *itemRepository) Update(ctx context.Context, item *Item) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := activeItem(ctx, tx, item.ID)
+	if err != nil {
+		return err
+	}
+	catID, err := categoryInsert(ctx, tx, item.Category)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, `
+        UPDATE items SET name = ?, category_id = ?, image_name = ?, updated_at = ?, version = version + 1
+         WHERE id = ? AND version = ? AND deleted_at IS NULL
+    `, item.Name, catID, item.Image, updatedAt, item.ID, item.Version)
+	if err != nil {
+		return err
+	}
+	if err := checkItemVersion(res, item.ID, item.Version); err != nil {
+		return err
+	}
+	after := *item
+	after.UpdatedAt, after.Version = updatedAt, item.Version+1
+	if before.Image != item.Image {
+		if err := addImageRef(ctx, tx, before.Image, -1); err != nil {
+			return err
+		}
+		if err := addImageRef(ctx, tx, item.Image, 1); err != nil {
+			return err
+		}
+	}
+	if err := recordItemEvent(ctx, tx, item.ID, itemEventUpdate, before, &after); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// the item is changed only once it is stored
+	*item = after
+	return nil
+}
+
+// Delete marks the item as deleted. The reference count of its image is kept, so that the image
+// is not removed while the item can be restored.
+func (r *itemRepository) Delete(ctx context.Context, id, version int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := activeItem(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	// the deletion changes the list of items, for HTTP caching
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, `
+        UPDATE items SET deleted_at = ?, updated_at = ?, version = version + 1
+         WHERE id = ? AND version = ? AND deleted_at IS NULL
+    `, now, now, id, version)
+	if err != nil {
+		return err
+	}
+	if err := checkItemVersion(res, id, version); err != nil {
+		return err
+	}
+	if err := recordItemEvent(ctx, tx, id, itemEventDelete, before, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Restore clears the deletion of the item. The item is changed as of the restore, for HTTP caching.
+func (r *itemRepository) Restore(ctx context.Context, id int) (*Item, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	if err := tx.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM items WHERE id = ?`, id).Scan(&deleted); err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, errItemNotDeleted
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE items SET deleted_at = NULL, updated_at = ?, version = version + 1 WHERE id = ?`, time.Now().UTC(), id); err != nil {
+		return nil, err
+	}
+	item, err := activeItem(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordItemEvent(ctx, tx, id, itemEventRestore, nil, item); err != nil {
+		return nil, err
+	}
+	return item, tx.Commit()
+}
+
+// History returns the events of the item in the order they were written.
+func (r *itemRepository) History(ctx context.Context, id int) ([]*ItemEvent, error) {
+	rows, err := r.read.QueryContext(ctx, `
+        SELECT id, item_id, action, actor, request_id, before_json, after_json, created_at
+          FROM item_events
+         WHERE item_id = ?
+         ORDER BY id
+    `, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*ItemEvent{}
+	for rows.Next() {
+		var event ItemEvent
+		var requestID, before, after sql.NullString
+		err := rows.Scan(&event.ID, &event.ItemID, &event.Action, &event.Actor, &requestID, &before, &after, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.RequestID = requestID.String
+		if before.Valid {
+			event.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			event.After = json.RawMessage(after.String)
+		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+// queryItems returns the items matching the WHERE clause in the transaction, in ID order.
+// Deleted items are included unless the clause excludes them.
+func queryItems(ctx context.Context, tx *sql.Tx, where string, args ...any) ([]*Item, error) {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT i.id, i.name, c.name AS category, i.image_name, i.updated_at, i.version
+          FROM items i
+          JOIN categories c ON i.category_id = c.id
+         WHERE `+where+`
+         ORDER BY i.id
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*Item
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image, &item.UpdatedAt, &item.Version); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	return items, rows.Err()
+}
+
+// checkItemVersion returns *ItemConflictError if the compare-and-swap UPDATE of the item at version
+// changed no row. The caller has checked in the transaction that the item exists.
+func checkItemVersion(res sql.Result, id, version int) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &ItemConflictError{ID: id, Version: version}
+	}
+	return nil
+}
+
+// activeItem returns the item in the transaction, or sql.ErrNoRows if it does not exist or is deleted.
+func activeItem(ctx context.Context, tx *sql.Tx, id int) (*Item, error) {
+	items, err := queryItems(ctx, tx, `i.id = ? AND i.deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// GetCategories returns all categories with the number of items in them.
+func (r *itemRepository) GetCategories(ctx context.Context) ([]*Category, error) {
+	rows, err := r.read.QueryContext(ctx, `
+        SELECT c.id, c.name, COUNT(i.id)
+          FROM categories c
+          LEFT JOIN items i ON i.category_id = c.id AND i.deleted_at IS NULL
+         GROUP BY c.id
+         ORDER BY c.id
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Items); err != nil {
+			return nil, err
+		}
+		categories = append(categories, &c)
+	}
+	return categories, rows.Err()
+}
+
+// RenameCategory renames the category. Names are not unique in the schema,
+// so it returns errCategoryExists instead of creating a duplicate.
+func (r *itemRepository) RenameCategory(ctx context.Context, id int, name string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM categories WHERE name = ? AND id != ?`, name, id).Scan(&existing)
+	if err == nil {
+		return fmt.Errorf("%w: %s (id %d)", errCategoryExists, name, existing)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	before, err := queryItems(ctx, tx, `i.category_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE categories SET name = ? WHERE id = ?`, name, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	// the category is part of the items, so they are changed as well
+	_, err = tx.ExecContext(ctx, `UPDATE items SET updated_at = ?, version = version + 1 WHERE category_id = ?`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if err := recordCategoryChange(ctx, tx, before, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteCategory deletes the category. It returns errCategoryNotEmpty if items are in it;
+// merge it into another category instead.
+func (r *itemRepository) DeleteCategory(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// deleted items still refer to the category, so that they can be restored
+	var items, deleted int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*), COUNT(deleted_at) FROM items WHERE category_id = ?`, id).Scan(&items, &deleted)
+	if err != nil {
+		return err
+	}
+	if items > 0 {
+		return fmt.Errorf("%w: %d items (%d deleted)", errCategoryNotEmpty, items, deleted)
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// MergeCategories moves the items of src to dst and deletes src in the same transaction.
+// It returns sql.ErrNoRows if either category does not exist.
+func (r *itemRepository) MergeCategories(ctx context.Context, src, dst int) (int, error) {
+	if src == dst {
+		return 0, errors.New("cannot merge a category into itself")
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM categories WHERE id IN (?, ?)`, src, dst).Scan(&found)
+	if err != nil {
+		return 0, err
+	}
+	if found != 2 {
+		return 0, sql.ErrNoRows
+	}
+	var dstName string
+	if err := tx.QueryRowContext(ctx, `SELECT name FROM categories WHERE id = ?`, dst).Scan(&dstName); err != nil {
+		return 0, err
+	}
+
+	before, err := queryItems(ctx, tx, `i.category_id = ?`, src)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE items SET category_id = ?, updated_at = ?, version = version + 1 WHERE category_id = ?`, dst, time.Now().UTC(), src)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE id = ?`, src); err != nil {
+		return 0, err
+	}
+	if err := recordCategoryChange(ctx, tx, before, dstName); err != nil {
+		return 0, err
+	}
+	return len(before), tx.Commit()
+}
+
+// recordCategoryChange writes the events of the items moved to or renamed as the category.
+func recordCategoryChange(ctx context.Context, tx *sql.Tx, before []*Item, category string) error {
+	for _, item := range before {
+		after := *item
+		after.Category = category
+		if err := recordItemEvent(ctx, tx, item.ID, itemEventUpdate, item, &after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imageRepository is an implementation of ImageRepository
+type imageRepository struct {
+	// db is used for writes, read for reads.
+	db   *sql.DB
+	read *sql.DB
+}
+
+func NewImageRepositoryWithDB(db *sql.DB) ImageRepository {
+	return &imageRepository{db: db, read: db}
+}
+
+// NewImageRepositoryWithPools creates an imageRepository writing to write and reading from read.
+func NewImageRepositoryWithPools(write, read *sql.DB) ImageRepository {
+	return &imageRepository{db: write, read: read}
+}
+
+// SaveImage records the metadata of an image without changing its reference count.
+func (r *imageRepository) SaveImage(ctx context.Context, image *Image) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO images (name, size, mime_type, width, height, ahash, dhash) VALUES (?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT (name) DO UPDATE SET
+                size = excluded.size,
+                mime_type = excluded.mime_type,
+                width = excluded.width,
+                height = excluded.height,
+                ahash = excluded.ahash,
+                dhash = excluded.dhash
+    `, image.Name, image.Size, image.MIMEType, nullInt(image.Width), nullInt(image.Height),
+		nullHash(image.AHash), nullHash(image.DHash))
+	return err
+}
+
+// nullInt stores zero as NULL, for dimensions of images that could not be decoded.
+func nullInt(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
+
+// nullHash stores a perceptual hash as the signed 64-bit integer of SQLite, and zero as NULL.
+func nullHash(v uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
+
+// DeleteUnreferencedImage deletes the image if no item refers to it.
+func (r *imageRepository) DeleteUnreferencedImage(ctx context.Context, name string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM images WHERE name = ? AND ref_count = 0`, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Report summarizes the storage used by images.
+func (r *imageRepository) Report(ctx context.Context) (*ImageReport, error) {
+	var report ImageReport
+	err := r.read.QueryRowContext(ctx, `
+        SELECT COUNT(*),
+               COUNT(*) FILTER (WHERE ref_count = 0),
+               COUNT(*) FILTER (WHERE size IS NULL),
+               COALESCE(SUM(size), 0),
+               COALESCE(SUM(size * MAX(ref_count, 1)), 0),
+               COALESCE(SUM(size * MAX(ref_count - 1, 0)), 0)
+          FROM images
+    `).Scan(&report.Images, &report.Unreferenced, &report.UnknownSize,
+		&report.StoredBytes, &report.ReferencedBytes, &report.SavedBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
 func NewItemRepositoryWithDB(db *sql.DB) ItemRepository {
 	return &itemRepository{
-		fileName: "items.json",
-		db:       db,
+		db:   db,
+		read: db,
+	}
+}
+
+// NewItemRepositoryWithPools creates an itemRepository writing to write and reading from read,
+// e.g. the pools of the server, which see the same data in WAL mode once a write is committed.
+func NewItemRepositoryWithPools(write, read *sql.DB) ItemRepository {
+	return &itemRepository{
+		db:   write,
+		read: read,
 	}
 }
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestUpdateDeleteItem(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := context.Background()
+	repo := NewItemRepositoryWithDB(db)
+	oldImage := strings.Repeat("a", 64) + ".jpg"
+	newImage := strings.Repeat("b", 64) + ".jpg"
+	item := &Item{Name: "jacket", Category: "fashion", Image: oldImage}
+	if err := repo.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	item.Name, item.Category, item.Image = "blue jacket", "outer", newImage
+	if err := repo.Update(ctx, item); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	got, err := repo.GetByID(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+	if diff := cmp.Diff(item, got, cmpopts.IgnoreFields(Item{}, "UpdatedAt")); diff != "" {
+		t.Errorf("unexpected item (-want +got):\n%s", diff)
+	}
+	assertRefCounts(t, db, map[string]int{oldImage: 0, newImage: 1})
+
+	if err := repo.Delete(ctx, item.ID, item.Version); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	// deleted items keep their image so that they can be restored
+	assertRefCounts(t, db, map[string]int{oldImage: 0, newImage: 1})
+
+	if err := repo.Update(ctx, item); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error updating a deleted item: %v", err)
+	}
+	if err := repo.Delete(ctx, item.ID, item.Version); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error deleting a deleted item: %v", err)
+	}
+}
+
+func assertRefCounts(t *testing.T, db *sql.DB, want map[string]int) {
+	t.Helper()
+
+	got := map[string]int{}
+	for name := range want {
+		var count int
+		if err := db.QueryRow(`SELECT ref_count FROM images WHERE name = ?`, name).Scan(&count); err != nil {
+			t.Fatalf("failed to read reference count of %s: %v", name, err)
+		}
+		got[name] = count
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected reference counts (-want +got):\n%s", diff)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := context.Background()
+	repo := NewItemRepositoryWithDB(db)
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion", Image: defaultImageName},
+		{Name: "shirt", Category: "clothes", Image: defaultImageName},
+	} {
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	empty, err := repo.CategoryInsert(ctx, "empty")
+	if err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+
+	if err := repo.RenameCategory(ctx, 2, "fashion"); !errors.Is(err, errCategoryExists) {
+		t.Errorf("renaming to an existing name should fail: %v", err)
+	}
+	if err := repo.RenameCategory(ctx, 1, "outer"); err != nil {
+		t.Fatalf("failed to rename category: %v", err)
+	}
+	if err := repo.DeleteCategory(ctx, 2); !errors.Is(err, errCategoryNotEmpty) {
+		t.Errorf("deleting a category with items should fail: %v", err)
+	}
+	if err := repo.DeleteCategory(ctx, empty); err != nil {
+		t.Fatalf("failed to delete category: %v", err)
+	}
+	moved, err := repo.MergeCategories(ctx, 2, 1)
+	if err != nil {
+		t.Fatalf("failed to merge categories: %v", err)
+	}
+	if moved != 1 {
+		t.Errorf("unexpected number of items moved. want=1, got=%d", moved)
+	}
+	if _, err := repo.MergeCategories(ctx, 2, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("merging a deleted category should fail: %v", err)
+	}
+
+	categories, err := repo.GetCategories(ctx)
+	if err != nil {
+		t.Fatalf("failed to get categories: %v", err)
+	}
+	want := []*Category{{ID: 1, Name: "outer", Items: 2}}
+	if diff := cmp.Diff(want, categories); diff != "" {
+		t.Errorf("unexpected categories (-want +got):\n%s", diff)
+	}
+
+	items, err := repo.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("failed to get items: %v", err)
+	}
+	for _, item := range items {
+		if item.Category != "outer" {
+			t.Errorf("unexpected category of %s: %s", item.Name, item.Category)
+		}
+	}
+}
@@ -0,0 +1,817 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJSONStorePath is the file storing the items when ITEM_STORE=json, relative to the working directory.
+const DefaultJSONStorePath = "db/mercari.json"
+
+// itemStore selects the implementation of ItemRepository and ImageRepository used by the server.
+type itemStore string
+
+const (
+	// itemStoreSQLite stores items in the SQLite database. It is the default.
+	itemStoreSQLite itemStore = "sqlite"
+	// itemStoreJSON stores items in a JSON file with JSONItemRepository.
+	itemStoreJSON itemStore = "json"
+)
+
+func parseItemStore(s string) (itemStore, error) {
+	switch st := itemStore(s); st {
+	case "":
+		return itemStoreSQLite, nil
+	case itemStoreSQLite, itemStoreJSON:
+		return st, nil
+	}
+	return "", fmt.Errorf("invalid item store %q: want sqlite or json", s)
+}
+
+// JSONItemRepository is an implementation of ItemRepository and ImageRepository storing everything in a JSON file.
+// It needs no cgo, unlike the SQLite driver, so the server can be cross-compiled with CGO_ENABLED=0.
+//
+// The file is read into memory and indexed; each change rewrites the whole file to a temporary file
+// and renames it over the old one, so readers never see a partial file. A lock file next to it
+// serializes the writers of all processes, and a process reloads the file when another one replaced it.
+// It is meant for development and small catalogs: every change costs a write of the whole file.
+type JSONItemRepository struct {
+	// fileName is the path to the JSON file storing items.
+	fileName string
+	// lock is the lock file held while the file is read or written.
+	lock *os.File
+
+	// mu serializes the goroutines of this process; the lock file only serializes processes.
+	mu sync.Mutex
+	// loaded is the file the data was loaded from, to detect when another process replaced it.
+	loaded os.FileInfo
+	data   *jsonStoreData
+
+	// indexes of data
+	items       map[int]*jsonStoreItem
+	categories  map[int]*jsonStoreCategory
+	categoryIDs map[string]int
+	images      map[string]*jsonStoreImage
+	// refs is the number of items referring to each image.
+	refs map[string]int
+}
+
+// jsonStoreData is the content of the file.
+type jsonStoreData struct {
+	// Items are sorted by ID.
+	Items []*jsonStoreItem `json:"items"`
+	// Categories are sorted by ID.
+	Categories []*jsonStoreCategory `json:"categories"`
+	Images     []*jsonStoreImage    `json:"images"`
+	// Events are the audit trail of the items, sorted by ID.
+	Events []*ItemEvent `json:"events"`
+	// IDs are not reused after deletion, as with AUTOINCREMENT.
+	LastItemID     int `json:"last_item_id"`
+	LastCategoryID int `json:"last_category_id"`
+	LastEventID    int `json:"last_event_id"`
+}
+
+type jsonStoreItem struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	CategoryID int       `json:"category_id"`
+	Image      string    `json:"image_name"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// Version is 0 in files written before items had versions, which is read as 1.
+	Version int `json:"version"`
+	// DeletedAt is set on deleted items, which are kept so that they can be restored.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type jsonStoreCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// jsonStoreImage is the metadata of a content-addressed image.
+// The reference count is not stored since it is counted from the items.
+type jsonStoreImage struct {
+	Name string `json:"name"`
+	// Size is nil for images referred to by items before their metadata was recorded.
+	Size     *int64 `json:"size,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	AHash    uint64 `json:"ahash,omitempty"`
+	DHash    uint64 `json:"dhash,omitempty"`
+}
+
+// NewJSONItemRepository opens the JSON file storing items, creating it and its directory if they do not exist.
+// Close releases the lock file.
+func NewJSONItemRepository(fileName string) (*JSONItemRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory of %s: %w", fileName, err)
+	}
+	lock, err := os.OpenFile(fileName+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	r := &JSONItemRepository{fileName: fileName, lock: lock}
+	if _, err = os.Stat(fileName); errors.Is(err, os.ErrNotExist) {
+		// create the file so that other processes find it
+		err = r.write(func() error { return nil })
+	} else {
+		err = r.read(func() error { return nil })
+	}
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the lock file.
+func (r *JSONItemRepository) Close() error {
+	return r.lock.Close()
+}
+
+// Ping reports whether the file can be read, for the readiness probe.
+func (r *JSONItemRepository) Ping(ctx context.Context) error {
+	return r.read(func() error { return nil })
+}
+
+// read calls fn with the data up to date with the file.
+func (r *JSONItemRepository) read(fn func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := lockFile(r.lock, false); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", r.fileName, err)
+	}
+	defer unlockFile(r.lock)
+
+	if err := r.reload(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// write calls fn to change the data and writes it to the file if fn succeeds.
+// If either fails, the data is reloaded from the file by the next call, discarding the changes of fn.
+func (r *JSONItemRepository) write(fn func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := lockFile(r.lock, true); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", r.fileName, err)
+	}
+	defer unlockFile(r.lock)
+
+	if err := r.reload(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		r.loaded = nil
+		return err
+	}
+	if err := r.save(); err != nil {
+		r.loaded = nil
+		return err
+	}
+	return nil
+}
+
+// reload reads the file if it has not been loaded or was replaced since.
+func (r *JSONItemRepository) reload() error {
+	info, err := os.Stat(r.fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		r.loaded = nil
+		r.index(&jsonStoreData{Items: []*jsonStoreItem{}, Categories: []*jsonStoreCategory{}, Images: []*jsonStoreImage{}, Events: []*ItemEvent{}})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r.loaded != nil && os.SameFile(r.loaded, info) && r.loaded.ModTime().Equal(info.ModTime()) && r.loaded.Size() == info.Size() {
+		return nil
+	}
+
+	b, err := os.ReadFile(r.fileName)
+	if err != nil {
+		return err
+	}
+	var data jsonStoreData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return fmt.Errorf("invalid JSON in %s: %w", r.fileName, err)
+	}
+	r.index(&data)
+	r.loaded = info
+	return nil
+}
+
+// index builds the indexes of the data.
+func (r *JSONItemRepository) index(data *jsonStoreData) {
+	r.data = data
+	r.items = make(map[int]*jsonStoreItem, len(data.Items))
+	r.refs = map[string]int{}
+	for _, item := range data.Items {
+		item.Version = max(item.Version, 1)
+		r.items[item.ID] = item
+		r.refs[item.Image]++
+	}
+	r.categories = make(map[int]*jsonStoreCategory, len(data.Categories))
+	r.categoryIDs = make(map[string]int, len(data.Categories))
+	for _, c := range data.Categories {
+		r.categories[c.ID] = c
+		r.categoryIDs[c.Name] = c.ID
+	}
+	r.images = make(map[string]*jsonStoreImage, len(data.Images))
+	for _, image := range data.Images {
+		r.images[image.Name] = image
+	}
+}
+
+// save writes the data to a temporary file and renames it over the file.
+func (r *JSONItemRepository) save() error {
+	b, err := json.Marshal(r.data)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(r.fileName), filepath.Base(r.fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	// the data must be on disk before the rename makes it the file
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), r.fileName); err != nil {
+		return err
+	}
+	info, err := os.Stat(r.fileName)
+	if err != nil {
+		return err
+	}
+	r.loaded = info
+	return nil
+}
+
+// item returns the item with its category name.
+func (r *JSONItemRepository) item(item *jsonStoreItem) *Item {
+	return &Item{
+		ID:        item.ID,
+		Name:      item.Name,
+		Category:  r.categories[item.CategoryID].Name,
+		Image:     item.Image,
+		UpdatedAt: item.UpdatedAt,
+		Version:   item.Version,
+	}
+}
+
+// categoryID returns the ID of the category with the name, creating it if it does not exist.
+func (r *JSONItemRepository) categoryID(name string) int {
+	if id, ok := r.categoryIDs[name]; ok {
+		return id
+	}
+	r.data.LastCategoryID++
+	c := &jsonStoreCategory{ID: r.data.LastCategoryID, Name: name}
+	r.data.Categories = append(r.data.Categories, c)
+	r.categories[c.ID] = c
+	r.categoryIDs[name] = c.ID
+	return c.ID
+}
+
+// addRef adds delta to the number of items referring to the image.
+// Content-addressed images are recorded even without metadata, as addImageRef does.
+func (r *JSONItemRepository) addRef(name string, delta int) {
+	r.refs[name] += delta
+	if r.refs[name] == 0 {
+		delete(r.refs, name)
+	}
+	if delta > 0 && imageRefPattern.MatchString(name) && r.images[name] == nil {
+		image := &jsonStoreImage{Name: name}
+		r.data.Images = append(r.data.Images, image)
+		r.images[name] = image
+	}
+}
+
+// activeItem returns the item, or sql.ErrNoRows if it does not exist or is deleted.
+func (r *JSONItemRepository) activeItem(id int) (*jsonStoreItem, error) {
+	stored, ok := r.items[id]
+	if !ok || stored.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	return stored, nil
+}
+
+// recordEvent appends the event of a change of the item by the actor of ctx.
+func (r *JSONItemRepository) recordEvent(ctx context.Context, itemID int, action string, before, after *Item) error {
+	event, err := newItemEvent(ctx, itemID, action, before, after)
+	if err != nil {
+		return err
+	}
+	r.data.LastEventID++
+	event.ID = r.data.LastEventID
+	r.data.Events = append(r.data.Events, event)
+	return nil
+}
+
+func (r *JSONItemRepository) CategoryInsert(ctx context.Context, categoryName string) (int, error) {
+	var id int
+	err := r.write(func() error {
+		id = r.categoryID(categoryName)
+		return nil
+	})
+	return id, err
+}
+
+func (r *JSONItemRepository) Insert(ctx context.Context, item *Item) error {
+	return r.InsertBatch(ctx, []*Item{item})
+}
+
+// InsertBatch inserts the items in a single write of the file and sets their IDs.
+func (r *JSONItemRepository) InsertBatch(ctx context.Context, items []*Item) error {
+	stored := make([]*jsonStoreItem, len(items))
+	err := r.write(func() error {
+		for i, item := range items {
+			updatedAt := item.UpdatedAt
+			if updatedAt.IsZero() {
+				updatedAt = time.Now().UTC()
+			}
+			r.data.LastItemID++
+			stored[i] = &jsonStoreItem{
+				ID:         r.data.LastItemID,
+				Name:       item.Name,
+				CategoryID: r.categoryID(item.Category),
+				Image:      item.Image,
+				UpdatedAt:  updatedAt,
+				Version:    1,
+			}
+			r.data.Items = append(r.data.Items, stored[i])
+			r.items[stored[i].ID] = stored[i]
+			r.addRef(item.Image, 1)
+			if err := r.recordEvent(ctx, stored[i].ID, itemEventCreate, nil, r.item(stored[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the items are changed only once they are stored, as with a rolled back transaction
+	for i, item := range items {
+		item.ID, item.UpdatedAt, item.Version = stored[i].ID, stored[i].UpdatedAt, stored[i].Version
+	}
+	return nil
+}
+
+func (r *JSONItemRepository) GetAll(ctx context.Context) ([]*Item, error) {
+	var items []*Item
+	err := r.read(func() error {
+		for _, item := range r.data.Items {
+			if item.DeletedAt == nil {
+				items = append(items, r.item(item))
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+func (r *JSONItemRepository) LastModified(ctx context.Context) (time.Time, error) {
+	var lastModified time.Time
+	err := r.read(func() error {
+		for _, item := range r.data.Items {
+			if item.UpdatedAt.After(lastModified) {
+				lastModified = item.UpdatedAt
+			}
+		}
+		return nil
+	})
+	return lastModified, err
+}
+
+// GetByID returns sql.ErrNoRows if the item does not exist, as the SQLite implementation does.
+func (r *JSONItemRepository) GetByID(ctx context.Context, id int) (*Item, error) {
+	var item *Item
+	err := r.read(func() error {
+		stored, err := r.activeItem(id)
+		if err != nil {
+			return err
+		}
+		item = r.item(stored)
+		return nil
+	})
+	return item, err
+}
+
+// SearchByKeyword matches the keyword case-insensitively for ASCII letters, like LIKE in SQLite.
+func (r *JSONItemRepository) SearchByKeyword(ctx context.Context, keyword string) ([]*Item, error) {
+	if keyword == "" {
+		return nil, errors.New("keyword is required")
+	}
+	var items []*Item
+	err := r.read(func() error {
+		for _, stored := range r.data.Items {
+			if stored.DeletedAt != nil {
+				continue
+			}
+			item := r.item(stored)
+			if containsFold(item.Name, keyword) || containsFold(item.Category, keyword) {
+				items = append(items, item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("no items found matching the keyword")
+	}
+	return items, nil
+}
+
+// containsFold reports whether substr is in s, ignoring the case of ASCII letters.
+func containsFold(s, substr string) bool {
+	lower := func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}
+	return strings.Contains(strings.Map(lower, s), strings.Map(lower, substr))
+}
+
+// ExportItems copies the matching items before calling fn, so that a slow reader does not block writers.
+func (r *JSONItemRepository) ExportItems(ctx context.Context, filter ExportFilter, fn func(*ExportItem) error) error {
+	var items []*ExportItem
+	err := r.read(func() error {
+		for _, stored := range r.data.Items {
+			category := r.categories[stored.CategoryID].Name
+			switch {
+			case stored.DeletedAt != nil && !filter.Deleted:
+				continue
+			case filter.Category != "" && category != filter.Category:
+				continue
+			case filter.Keyword != "" && !containsFold(stored.Name, filter.Keyword):
+				continue
+			case !filter.UpdatedSince.IsZero() && stored.UpdatedAt.Before(filter.UpdatedSince):
+				continue
+			}
+			items = append(items, &ExportItem{
+				ID:         stored.ID,
+				Name:       stored.Name,
+				CategoryID: stored.CategoryID,
+				Category:   category,
+				Image:      stored.Image,
+				UpdatedAt:  stored.UpdatedAt,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *JSONItemRepository) CountByImage(ctx context.Context, imageName string) (int, error) {
+	var count int
+	err := r.read(func() error {
+		count = r.refs[imageName]
+		return nil
+	})
+	return count, err
+}
+
+func (r *JSONItemRepository) ReferencedImages(ctx context.Context) (map[string]int, error) {
+	var images map[string]int
+	err := r.read(func() error {
+		images = maps.Clone(r.refs)
+		return nil
+	})
+	return images, err
+}
+
+func (r *JSONItemRepository) ReplaceImage(ctx context.Context, oldName, newName string) (int, error) {
+	var n int
+	err := r.write(func() error {
+		now := time.Now().UTC()
+		for _, item := range r.data.Items {
+			if item.Image != oldName {
+				continue
+			}
+			before := r.item(item)
+			item.Image, item.UpdatedAt = newName, now
+			item.Version++
+			r.addRef(oldName, -1)
+			r.addRef(newName, 1)
+			if err := r.recordEvent(ctx, item.ID, itemEventUpdate, before, r.item(item)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (r *JSONItemRepository) SimilarItems(ctx context.Context, imageName string, maxDistance int) ([]*SimilarItem, error) {
+	similar := []*SimilarItem{}
+	err := r.read(func() error {
+		image := r.images[imageName]
+		if image == nil || image.AHash == 0 || image.DHash == 0 {
+			// unknown images and images without hashes cannot be compared
+			return nil
+		}
+		for _, stored := range r.data.Items {
+			other := r.images[stored.Image]
+			if stored.DeletedAt != nil || other == nil || other.AHash == 0 || other.DHash == 0 {
+				continue
+			}
+			distance := max(hashDistance(image.AHash, other.AHash), hashDistance(image.DHash, other.DHash))
+			if distance <= maxDistance {
+				similar = append(similar, &SimilarItem{Item: r.item(stored), Distance: distance})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(similar, func(a, b *SimilarItem) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.ID, b.ID))
+	})
+	return similar, nil
+}
+
+// Update compares the version under the lock of the file, which no other process can change in between.
+func (r *JSONItemRepository) Update(ctx context.Context, item *Item) error {
+	var after *Item
+	err := r.write(func() error {
+		stored, err := r.activeItem(item.ID)
+		if err != nil {
+			return err
+		}
+		if stored.Version != item.Version {
+			return &ItemConflictError{ID: item.ID, Version: item.Version}
+		}
+		before := r.item(stored)
+		if stored.Image != item.Image {
+			r.addRef(stored.Image, -1)
+			r.addRef(item.Image, 1)
+		}
+		stored.Name, stored.CategoryID, stored.Image, stored.UpdatedAt = item.Name, r.categoryID(item.Category), item.Image, time.Now().UTC()
+		stored.Version++
+		after = r.item(stored)
+		return r.recordEvent(ctx, item.ID, itemEventUpdate, before, after)
+	})
+	if err != nil {
+		return err
+	}
+	item.UpdatedAt, item.Version = after.UpdatedAt, after.Version
+	return nil
+}
+
+// Delete keeps the item and the reference to its image, as the SQLite implementation does.
+func (r *JSONItemRepository) Delete(ctx context.Context, id, version int) error {
+	return r.write(func() error {
+		stored, err := r.activeItem(id)
+		if err != nil {
+			return err
+		}
+		if stored.Version != version {
+			return &ItemConflictError{ID: id, Version: version}
+		}
+		before := r.item(stored)
+		now := time.Now().UTC()
+		stored.DeletedAt, stored.UpdatedAt = &now, now
+		stored.Version++
+		return r.recordEvent(ctx, id, itemEventDelete, before, nil)
+	})
+}
+
+func (r *JSONItemRepository) Restore(ctx context.Context, id int) (*Item, error) {
+	var item *Item
+	err := r.write(func() error {
+		stored, ok := r.items[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if stored.DeletedAt == nil {
+			return errItemNotDeleted
+		}
+		stored.DeletedAt, stored.UpdatedAt = nil, time.Now().UTC()
+		stored.Version++
+		item = r.item(stored)
+		return r.recordEvent(ctx, id, itemEventRestore, nil, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *JSONItemRepository) History(ctx context.Context, id int) ([]*ItemEvent, error) {
+	events := []*ItemEvent{}
+	err := r.read(func() error {
+		for _, event := range r.data.Events {
+			if event.ItemID == id {
+				e := *event
+				events = append(events, &e)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (r *JSONItemRepository) GetCategories(ctx context.Context) ([]*Category, error) {
+	categories := []*Category{}
+	err := r.read(func() error {
+		counts := map[int]int{}
+		for _, item := range r.data.Items {
+			if item.DeletedAt == nil {
+				counts[item.CategoryID]++
+			}
+		}
+		for _, c := range r.data.Categories {
+			categories = append(categories, &Category{ID: c.ID, Name: c.Name, Items: counts[c.ID]})
+		}
+		return nil
+	})
+	return categories, err
+}
+
+func (r *JSONItemRepository) RenameCategory(ctx context.Context, id int, name string) error {
+	return r.write(func() error {
+		if existing, ok := r.categoryIDs[name]; ok && existing != id {
+			return fmt.Errorf("%w: %s (id %d)", errCategoryExists, name, existing)
+		}
+		c, ok := r.categories[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		var before []*Item
+		for _, item := range r.data.Items {
+			if item.CategoryID == id {
+				before = append(before, r.item(item))
+			}
+		}
+		delete(r.categoryIDs, c.Name)
+		c.Name = name
+		r.categoryIDs[name] = id
+		// the category is part of the items, so they are changed as well
+		now := time.Now().UTC()
+		for _, item := range r.data.Items {
+			if item.CategoryID == id {
+				item.UpdatedAt = now
+				item.Version++
+			}
+		}
+		return r.recordCategoryChange(ctx, before)
+	})
+}
+
+func (r *JSONItemRepository) DeleteCategory(ctx context.Context, id int) error {
+	return r.write(func() error {
+		items, deleted := 0, 0
+		for _, item := range r.data.Items {
+			if item.CategoryID == id {
+				items++
+				if item.DeletedAt != nil {
+					deleted++
+				}
+			}
+		}
+		if items > 0 {
+			return fmt.Errorf("%w: %d items (%d deleted)", errCategoryNotEmpty, items, deleted)
+		}
+		return r.deleteCategory(id)
+	})
+}
+
+func (r *JSONItemRepository) deleteCategory(id int) error {
+	c, ok := r.categories[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	r.data.Categories = slices.DeleteFunc(r.data.Categories, func(c *jsonStoreCategory) bool { return c.ID == id })
+	delete(r.categories, id)
+	delete(r.categoryIDs, c.Name)
+	return nil
+}
+
+func (r *JSONItemRepository) MergeCategories(ctx context.Context, src, dst int) (int, error) {
+	if src == dst {
+		return 0, errors.New("cannot merge a category into itself")
+	}
+	var n int
+	err := r.write(func() error {
+		if r.categories[src] == nil || r.categories[dst] == nil {
+			return sql.ErrNoRows
+		}
+		var before []*Item
+		now := time.Now().UTC()
+		for _, item := range r.data.Items {
+			if item.CategoryID == src {
+				before = append(before, r.item(item))
+				item.CategoryID, item.UpdatedAt = dst, now
+				item.Version++
+			}
+		}
+		n = len(before)
+		if err := r.deleteCategory(src); err != nil {
+			return err
+		}
+		return r.recordCategoryChange(ctx, before)
+	})
+	return n, err
+}
+
+// recordCategoryChange appends the events of the items whose category was renamed or merged.
+func (r *JSONItemRepository) recordCategoryChange(ctx context.Context, before []*Item) error {
+	for _, item := range before {
+		if err := r.recordEvent(ctx, item.ID, itemEventUpdate, item, r.item(r.items[item.ID])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *JSONItemRepository) SaveImage(ctx context.Context, image *Image) error {
+	return r.write(func() error {
+		stored := r.images[image.Name]
+		if stored == nil {
+			stored = &jsonStoreImage{Name: image.Name}
+			r.data.Images = append(r.data.Images, stored)
+			r.images[image.Name] = stored
+		}
+		size := image.Size
+		stored.Size, stored.MIMEType, stored.Width, stored.Height = &size, image.MIMEType, image.Width, image.Height
+		stored.AHash, stored.DHash = image.AHash, image.DHash
+		return nil
+	})
+}
+
+func (r *JSONItemRepository) DeleteUnreferencedImage(ctx context.Context, name string) (bool, error) {
+	var deleted bool
+	err := r.write(func() error {
+		if r.images[name] == nil || r.refs[name] > 0 {
+			return nil
+		}
+		r.data.Images = slices.DeleteFunc(r.data.Images, func(image *jsonStoreImage) bool { return image.Name == name })
+		delete(r.images, name)
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+func (r *JSONItemRepository) Report(ctx context.Context) (*ImageReport, error) {
+	var report ImageReport
+	err := r.read(func() error {
+		for _, image := range r.data.Images {
+			report.Images++
+			refs := r.refs[image.Name]
+			if refs == 0 {
+				report.Unreferenced++
+			}
+			if image.Size == nil {
+				report.UnknownSize++
+				continue
+			}
+			size := *image.Size
+			report.StoredBytes += size
+			report.ReferencedBytes += size * int64(max(refs, 1))
+			report.SavedBytes += size * int64(max(refs-1, 0))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// LegacyItemsFile is the file the items were stored in before the database, relative to the working directory.
+const LegacyItemsFile = "items.json"
+
+// MigrateJSONOptions configures MigrateJSON.
+type MigrateJSONOptions struct {
+	// DryRun reports what would be migrated without writing to the database.
+	DryRun bool
+	// ResetMissingImages migrates the items whose image is missing with the placeholder image of their category.
+	// Otherwise they are skipped.
+	ResetMissingImages bool
+	// Placeholders are the images of items without an image per category, as returned by PlaceholderImages,
+	// so that migrated items get the same image as items added by POST /items.
+	Placeholders map[string]string
+}
+
+// MigrateJSONReport is the result of MigrateJSON.
+type MigrateJSONReport struct {
+	// Read is the number of items in the file.
+	Read int `json:"read"`
+	// Migrated is the number of items inserted into the database (or that would be, on a dry run).
+	Migrated int `json:"migrated"`
+	// Existing is the number of items skipped because they are already in the database,
+	// e.g. migrated by an earlier run.
+	Existing int `json:"existing"`
+	// Categories are the categories created for the migrated items.
+	Categories []string `json:"categories"`
+	// Invalid are the items skipped because they lack a name or category or their image is missing.
+	Invalid []LegacyItemError `json:"invalid"`
+	// MissingImages are the images referred to by items but not in the image directory, sorted by name.
+	MissingImages []string `json:"missing_images"`
+	// ResetItems is the number of items migrated with a placeholder image instead of a missing one.
+	ResetItems int `json:"reset_items"`
+}
+
+// LegacyItemError is the reason an item of the file was skipped.
+type LegacyItemError struct {
+	// Index is the position of the item in the file from 1.
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// OK reports whether every item of the file is in the database as it was in the file.
+func (r *MigrateJSONReport) OK() bool {
+	return len(r.Invalid) == 0 && len(r.MissingImages) == 0
+}
+
+// legacyItem is an item of items.json, in the format of GET /items.
+type legacyItem struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Image    string `json:"image_name"`
+}
+
+// MigrateJSON inserts the items of an items.json file into the repository.
+// Items already in the repository with the same name, category and image are not inserted again,
+// so the migration can be run again after fixing the items that were skipped. Items migrated with
+// the placeholder image because their image was missing are not inserted again either once it is restored.
+// The items are inserted in a single transaction.
+func MigrateJSON(ctx context.Context, repo ItemRepository, r io.Reader, imgDirPath string, opts MigrateJSONOptions) (*MigrateJSONReport, error) {
+	var file struct {
+		Items []*legacyItem `json:"items"`
+	}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid items file: %w", err)
+	}
+
+	report := &MigrateJSONReport{Read: len(file.Items), Categories: []string{}, Invalid: []LegacyItemError{}, MissingImages: []string{}}
+	items := make([]*Item, 0, len(file.Items))
+	missing := map[string]bool{}
+	reset := map[*Item]bool{}
+	for i, li := range file.Items {
+		item := &Item{Name: li.Name, Category: li.Category, Image: li.Image}
+		if item.Image == "" {
+			// items added without an image before the placeholder images existed
+			item.Image = PlaceholderImage(opts.Placeholders, item.Category)
+		}
+		if msg := validateLegacyItem(item); msg != "" {
+			report.Invalid = append(report.Invalid, LegacyItemError{Index: i + 1, Message: msg})
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(imgDirPath, item.Image)); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			missing[item.Image] = true
+			if !opts.ResetMissingImages {
+				report.Invalid = append(report.Invalid, LegacyItemError{Index: i + 1, Message: fmt.Sprintf("image %s is missing", item.Image)})
+				continue
+			}
+			item.Image = PlaceholderImage(opts.Placeholders, item.Category)
+			reset[item] = true
+		}
+		items = append(items, item)
+	}
+	for name := range missing {
+		report.MissingImages = append(report.MissingImages, name)
+	}
+	sort.Strings(report.MissingImages)
+
+	items, err := newLegacyItems(ctx, repo, items, opts.Placeholders)
+	if err != nil {
+		return nil, err
+	}
+	report.Existing = len(file.Items) - len(report.Invalid) - len(items)
+	report.Migrated = len(items)
+	for _, item := range items {
+		if reset[item] {
+			report.ResetItems++
+		}
+	}
+
+	categories, err := repo.GetCategories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+	known := map[string]bool{}
+	for _, c := range categories {
+		known[c.Name] = true
+	}
+	for _, item := range items {
+		if known[item.Category] {
+			continue
+		}
+		known[item.Category] = true
+		report.Categories = append(report.Categories, item.Category)
+		if opts.DryRun {
+			continue
+		}
+		if _, err := repo.CategoryInsert(ctx, item.Category); err != nil {
+			return nil, fmt.Errorf("failed to create category %s: %w", item.Category, err)
+		}
+	}
+
+	if opts.DryRun || len(items) == 0 {
+		return report, nil
+	}
+	if err := repo.InsertBatch(ctx, items); err != nil {
+		return nil, fmt.Errorf("failed to insert items: %w", err)
+	}
+	return report, nil
+}
+
+// validateLegacyItem returns why the item cannot be migrated, or "" if it can.
+func validateLegacyItem(item *Item) string {
+	switch {
+	case strings.TrimSpace(item.Name) == "":
+		return "name is required"
+	case strings.TrimSpace(item.Category) == "":
+		return "category is required"
+	case filepath.Base(item.Image) != item.Image || strings.ContainsAny(item.Image, `/\`) || item.Image == "." || item.Image == "..":
+		return fmt.Sprintf("image_name %q is not a file name", item.Image)
+	}
+	return ""
+}
+
+// newLegacyItems returns the items not in the repository yet.
+// Items are compared by name, category and image; duplicates in the file are kept
+// as long as the repository has fewer copies. Deleted items count as well,
+// so that an item deleted after a migration is not added again by the next one.
+// An item with the placeholder image of its category counts as an item of the same name and category
+// with any image, since it may have been migrated by -reset-missing-images before its image was restored.
+func newLegacyItems(ctx context.Context, repo ItemRepository, items []*Item, placeholders map[string]string) ([]*Item, error) {
+	type key struct{ name, category, image string }
+	counts := map[key]int{}
+	err := repo.ExportItems(ctx, ExportFilter{Deleted: true}, func(item *ExportItem) error {
+		counts[key{item.Name, item.Category, item.Image}]++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+
+	// exact matches first, so that items with the placeholder image in the file keep their copies
+	existing := map[*Item]bool{}
+	for _, item := range items {
+		k := key{item.Name, item.Category, item.Image}
+		if counts[k] > 0 {
+			counts[k]--
+			existing[item] = true
+		}
+	}
+	for _, item := range items {
+		k := key{item.Name, item.Category, PlaceholderImage(placeholders, item.Category)}
+		if !existing[item] && counts[k] > 0 {
+			counts[k]--
+			existing[item] = true
+		}
+	}
+	return slices.DeleteFunc(items, func(item *Item) bool { return existing[item] }), nil
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestMigrateJSON(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	imgDir := t.TempDir()
+	for _, name := range []string{defaultImageName, "hat.jpg", "jacket.jpg"} {
+		if err := os.WriteFile(filepath.Join(imgDir, name), []byte("image"), 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+	file := `{"items": [
+		{"id": 1, "name": "jacket", "category": "fashion", "image_name": "jacket.jpg"},
+		{"id": 2, "name": "jacket", "category": "fashion", "image_name": "jacket.jpg"},
+		{"id": 3, "name": "cap", "category": "hat", "image_name": ""},
+		{"id": 4, "name": "", "category": "hat", "image_name": ""},
+		{"id": 5, "name": "shoes", "category": "fashion", "image_name": "shoes.jpg"},
+		{"id": 6, "name": "secret", "category": "fashion", "image_name": "../items.json"}
+	]}`
+
+	placeholders := map[string]string{"hat": "hat.jpg"}
+
+	ctx := context.Background()
+	repo := NewItemRepositoryWithDB(db)
+	if _, err := repo.CategoryInsert(ctx, "fashion"); err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+	invalid := []LegacyItemError{
+		{Index: 4, Message: "name is required"},
+		{Index: 5, Message: "image shoes.jpg is missing"},
+		{Index: 6, Message: `image_name "../items.json" is not a file name`},
+	}
+
+	// a dry run writes nothing
+	report, err := MigrateJSON(ctx, repo, strings.NewReader(file), imgDir, MigrateJSONOptions{DryRun: true, Placeholders: placeholders})
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	want := &MigrateJSONReport{Read: 6, Migrated: 3, Categories: []string{"hat"}, Invalid: invalid, MissingImages: []string{"shoes.jpg"}}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report of dry run (-want +got):\n%s", diff)
+	}
+	if items, _ := repo.GetAll(ctx); len(items) != 0 {
+		t.Fatalf("dry run inserted %d items", len(items))
+	}
+
+	report, err = MigrateJSON(ctx, repo, strings.NewReader(file), imgDir, MigrateJSONOptions{Placeholders: placeholders})
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%s", diff)
+	}
+	if report.OK() {
+		t.Errorf("report with skipped items should not be OK")
+	}
+
+	// running again migrates only the items skipped before
+	report, err = MigrateJSON(ctx, repo, strings.NewReader(file), imgDir, MigrateJSONOptions{ResetMissingImages: true, Placeholders: placeholders})
+	if err != nil {
+		t.Fatalf("failed to migrate again: %v", err)
+	}
+	want = &MigrateJSONReport{Read: 6, Migrated: 1, Existing: 3, Categories: []string{}, Invalid: []LegacyItemError{invalid[0], invalid[2]}, MissingImages: []string{"shoes.jpg"}, ResetItems: 1}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report of second run (-want +got):\n%s", diff)
+	}
+
+	items, err := repo.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("failed to get items: %v", err)
+	}
+	wantItems := []*Item{
+		{ID: 1, Name: "jacket", Category: "fashion", Image: "jacket.jpg"},
+		{ID: 2, Name: "jacket", Category: "fashion", Image: "jacket.jpg"},
+		// the placeholder of the category, as POST /items would use
+		{ID: 3, Name: "cap", Category: "hat", Image: "hat.jpg"},
+		{ID: 4, Name: "shoes", Category: "fashion", Image: defaultImageName},
+	}
+	if diff := cmp.Diff(wantItems, items, cmpopts.IgnoreFields(Item{}, "UpdatedAt", "Version")); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+
+	// items deleted after the migration are not migrated again
+	if err := repo.Delete(ctx, 3, items[2].Version); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	report, err = MigrateJSON(ctx, repo, strings.NewReader(file), imgDir, MigrateJSONOptions{ResetMissingImages: true, Placeholders: placeholders})
+	if err != nil {
+		t.Fatalf("failed to migrate after a deletion: %v", err)
+	}
+	want.Migrated, want.Existing, want.ResetItems = 0, 4, 0
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report after a deletion (-want +got):\n%s", diff)
+	}
+
+	// items migrated with the placeholder are not migrated again once their image is restored
+	if err := os.WriteFile(filepath.Join(imgDir, "shoes.jpg"), []byte("image"), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	report, err = MigrateJSON(ctx, repo, strings.NewReader(file), imgDir, MigrateJSONOptions{Placeholders: placeholders})
+	if err != nil {
+		t.Fatalf("failed to migrate after restoring the image: %v", err)
+	}
+	want.MissingImages = []string{}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report after restoring the image (-want +got):\n%s", diff)
+	}
+	if report.OK() {
+		t.Errorf("report with invalid items should not be OK")
+	}
+	if items, err := repo.GetAll(ctx); err != nil || len(items) != 3 {
+		t.Errorf("expected the items to be kept as they were, got %d items: %v", len(items), err)
+	}
+}
+
+func TestMigrateJSONInvalidFile(t *testing.T) {
+	t.Parallel()
+
+	_, err := MigrateJSON(context.Background(), nil, strings.NewReader(`[{"name": "jacket"}]`), t.TempDir(), MigrateJSONOptions{})
+	if err == nil {
+		t.Errorf("expected an error for a file not in the format of GET /items")
+	}
+}
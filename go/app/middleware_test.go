@@ -0,0 +1,233 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		handler   http.HandlerFunc
+		timeout   time.Duration
+		wantCode  int
+		wantAbort bool
+	}{
+		"ok: no panic": {
+			handler:  func(w http.ResponseWriter, r *http.Request) {},
+			wantCode: http.StatusOK,
+		},
+		"ng: panic in handler": {
+			handler:  func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			wantCode: http.StatusInternalServerError,
+		},
+		"ng: panic in handler under timeout": {
+			handler:  func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			timeout:  time.Second,
+			wantCode: http.StatusInternalServerError,
+		},
+		"ng: aborted": {
+			handler:   func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			wantAbort: true,
+		},
+		"ng: aborted under timeout": {
+			handler:   func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			timeout:   time.Second,
+			wantAbort: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var next http.Handler = tt.handler
+			if tt.timeout > 0 {
+				next = timeoutMiddleware(next, tt.timeout)
+			}
+			h := requestIDMiddleware(recoveryMiddleware(next))
+
+			req := httptest.NewRequest("GET", "/items", nil)
+			req.Header.Set("X-Request-ID", "test-request")
+			res := httptest.NewRecorder()
+			if tt.wantAbort {
+				// the abort reaches net/http as is, so that it closes the connection without logging
+				defer func() {
+					if p := recover(); p != http.ErrAbortHandler {
+						t.Errorf("expected the response to be aborted, got panic %v with status %d", p, res.Code)
+					}
+				}()
+			}
+			h.ServeHTTP(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d", tt.wantCode, res.Code)
+			}
+			if tt.wantCode < 400 {
+				return
+			}
+
+			var got ErrorResponse
+			if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to unmarshal response body: %v", err)
+			}
+			want := ErrorResponse{Message: "internal server error", RequestID: "test-request"}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected response body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		handler  http.HandlerFunc
+		wantCode int
+		wantBody string
+	}{
+		"ok: handler finishes in time": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("created"))
+			},
+			wantCode: http.StatusCreated,
+			wantBody: "created",
+		},
+		"ng: handler exceeds the timeout": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				// a repository method would return once the context is canceled
+				<-r.Context().Done()
+				w.Write([]byte("too late"))
+			},
+			wantCode: http.StatusGatewayTimeout,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := timeoutMiddleware(tt.handler, 50*time.Millisecond)
+
+			req := httptest.NewRequest("GET", "/items", nil)
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d", tt.wantCode, res.Code)
+			}
+			if tt.wantBody != "" && res.Body.String() != tt.wantBody {
+				t.Errorf("unexpected response body. want=%q, got=%q", tt.wantBody, res.Body.String())
+			}
+		})
+	}
+}
+
+// TestTimeoutMiddlewareLatePanic checks that a panic raised after the timeout is logged with the request ID.
+// It replaces the default logger, so it does not run in parallel.
+func TestTimeoutMiddlewareLatePanic(t *testing.T) {
+	logs := make(logWriter, 10)
+	// slog.SetDefault redirects the log package as well, which restoring the default logger does not undo
+	defer log.SetOutput(log.Writer())
+	defer log.SetFlags(log.Flags())
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+
+	h := requestIDMiddleware(recoveryMiddleware(timeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		panic("boom")
+	}), 50*time.Millisecond)))
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("X-Request-ID", "test-request")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if res.Code != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code. want=%d, got=%d", http.StatusGatewayTimeout, res.Code)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-logs:
+			if strings.Contains(line, "panic recovered after the request timed out") {
+				if !strings.Contains(line, "request_id=test-request") || !strings.Contains(line, "panic=boom") {
+					t.Errorf("unexpected log line: %s", line)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("the panic after the timeout was not logged")
+		}
+	}
+}
+
+// logWriter sends each log line to the channel. Lines are dropped while the channel is full.
+type logWriter chan string
+
+func (w logWriter) Write(p []byte) (int, error) {
+	select {
+	case w <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body          string
+		contentLength int64
+		wantCode      int
+	}{
+		"ok: body within the limit": {
+			body:          "jacket",
+			contentLength: 6,
+			wantCode:      http.StatusOK,
+		},
+		"ng: Content-Length over the limit": {
+			body:          "blue jacket",
+			contentLength: 11,
+			wantCode:      http.StatusRequestEntityTooLarge,
+		},
+		"ng: chunked body over the limit": {
+			body:          "blue jacket",
+			contentLength: -1,
+			wantCode:      http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := bodyLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, err := io.ReadAll(r.Body); isBodyTooLarge(err) {
+					writeJSONError(w, r, http.StatusRequestEntityTooLarge, "too large")
+				}
+			}), 8)
+
+			req := httptest.NewRequest("POST", "/items", strings.NewReader(tt.body))
+			req.ContentLength = tt.contentLength
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d", tt.wantCode, res.Code)
+			}
+		})
+	}
+}
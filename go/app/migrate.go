@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// migrations is the ordered list of schema changes applied to the database.
+// The index of a migration plus one is the schema version it produces, and the
+// current version is tracked with SQLite's `PRAGMA user_version`.
+// Never edit a migration that has been released; append a new one instead.
+// db/items.sql shows the schema after all migrations and must be kept in sync, including its user_version,
+// so that a database created from it is not migrated again.
+var migrations = []string{
+	// 1: initial schema
+	`
+	CREATE TABLE IF NOT EXISTS "items" (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		category_id INTEGER,
+		image_name TEXT,
+		FOREIGN KEY (category_id) REFERENCES categories(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS categories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL
+	);
+	`,
+	// 2: updated_at for HTTP caching validators.
+	// ADD COLUMN only accepts a constant default, so existing rows are stamped with the migration time.
+	`
+	ALTER TABLE items ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';
+	UPDATE items SET updated_at = CURRENT_TIMESTAMP;
+	`,
+	// 3: images with the number of items referring to them.
+	// Only the reference counts of existing images can be computed in SQL;
+	// their metadata stays NULL until the image is stored again.
+	`
+	CREATE TABLE images (
+		name TEXT PRIMARY KEY,
+		size INTEGER,
+		mime_type TEXT,
+		width INTEGER,
+		height INTEGER,
+		ref_count INTEGER NOT NULL DEFAULT 0 CHECK (ref_count >= 0),
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	INSERT INTO images (name, ref_count)
+		SELECT image_name, COUNT(*) FROM items
+		 WHERE length(image_name) = 68
+		   AND image_name GLOB '*.jpg'
+		   AND NOT substr(image_name, 1, 64) GLOB '*[^0-9a-f]*'
+		 GROUP BY image_name;
+	`,
+	// 4: items added without an image referred to the hash of zero bytes;
+	// they now refer to the default image. The empty image is left to the upload GC.
+	`
+	UPDATE items SET image_name = 'default.jpg', updated_at = CURRENT_TIMESTAMP
+	 WHERE image_name = 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.jpg';
+	UPDATE images SET ref_count = 0
+	 WHERE name = 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.jpg';
+	`,
+	// 5: perceptual hashes to find images that look the same.
+	// They are computed when an image is stored, so existing images have none until uploaded again.
+	`
+	ALTER TABLE images ADD COLUMN ahash INTEGER;
+	ALTER TABLE images ADD COLUMN dhash INTEGER;
+	`,
+	// 6: soft delete and the audit trail of item changes.
+	// Deleted items keep their row and image reference so that they can be restored.
+	`
+	ALTER TABLE items ADD COLUMN deleted_at DATETIME;
+
+	CREATE TABLE item_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		request_id TEXT,
+		before_json TEXT,
+		after_json TEXT,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX item_events_item_id ON item_events (item_id, id);
+	`,
+	// 7: versions of items for optimistic concurrency control.
+	// Every change of an item increments its version, and updates only apply to the version they read.
+	`
+	ALTER TABLE items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	`,
+}
+
+// latestSchemaVersion is the schema version after applying all migrations.
+func latestSchemaVersion() int {
+	return len(migrations)
+}
+
+// schemaVersion returns the schema version of the database.
+func schemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate applies all pending migrations to the database.
+// Each migration runs in its own transaction together with the version bump,
+// so a failed migration leaves the database at the previous version.
+func migrate(ctx context.Context, db *sql.DB) error {
+	current, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, latestSchemaVersion())
+	}
+
+	for v := current + 1; v <= latestSchemaVersion(); v++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[v-1]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", v, err)
+		}
+		// PRAGMA does not accept placeholders
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, v)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to update schema version to %d: %w", v, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		slog.Info("applied database migration", "version", v)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// sqliteSchema returns the columns of every table and the indexes of the database,
+// in an order that does not depend on how the schema was created.
+func sqliteSchema(t *testing.T, db *sql.DB) map[string][]string {
+	t.Helper()
+
+	ctx := context.Background()
+	rows, err := db.QueryContext(ctx, `SELECT type, name FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		t.Fatalf("failed to read schema: %v", err)
+	}
+	defer rows.Close()
+
+	schema := map[string][]string{}
+	var tables []string
+	for rows.Next() {
+		var typ, name string
+		if err := rows.Scan(&typ, &name); err != nil {
+			t.Fatalf("failed to read schema: %v", err)
+		}
+		if typ == "table" {
+			tables = append(tables, name)
+		} else {
+			schema["indexes"] = append(schema["indexes"], name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("failed to read schema: %v", err)
+	}
+
+	for _, table := range tables {
+		cols, err := db.QueryContext(ctx, `SELECT name, type, "notnull", pk FROM pragma_table_info(?) ORDER BY name`, table)
+		if err != nil {
+			t.Fatalf("failed to read columns of %s: %v", table, err)
+		}
+		for cols.Next() {
+			var name, typ string
+			var notNull, pk int
+			if err := cols.Scan(&name, &typ, &notNull, &pk); err != nil {
+				t.Fatalf("failed to read columns of %s: %v", table, err)
+			}
+			column := name + " " + typ
+			if notNull == 1 {
+				column += " NOT NULL"
+			}
+			if pk > 0 {
+				column += " PRIMARY KEY"
+			}
+			schema[table] = append(schema[table], column)
+		}
+		if err := cols.Err(); err != nil {
+			t.Fatalf("failed to read columns of %s: %v", table, err)
+		}
+		cols.Close()
+	}
+	return schema
+}
+
+// TestItemsSQL checks that db/items.sql, which the course creates the database from,
+// has the schema and version the migrations produce, so that migrate leaves such a database as it is.
+func TestItemsSQL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	schemaSQL, err := os.ReadFile(filepath.Join("..", "db", "items.sql"))
+	if err != nil {
+		t.Fatalf("failed to read items.sql: %v", err)
+	}
+
+	ctx := context.Background()
+	open := func() *sql.DB {
+		db, err := openSQLite(filepath.Join(t.TempDir(), "mercari.sqlite3"), defaultSQLitePragmas)
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+
+	fromSQL := open()
+	if _, err := fromSQL.ExecContext(ctx, string(schemaSQL)); err != nil {
+		t.Fatalf("failed to create database from items.sql: %v", err)
+	}
+	version, err := schemaVersion(ctx, fromSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("unexpected user_version of items.sql. want=%d, got=%d", latestSchemaVersion(), version)
+	}
+	if err := migrate(ctx, fromSQL); err != nil {
+		t.Fatalf("failed to migrate database created from items.sql: %v", err)
+	}
+
+	migrated := open()
+	if err := migrate(ctx, migrated); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	if diff := cmp.Diff(sqliteSchema(t, migrated), sqliteSchema(t, fromSQL)); diff != "" {
+		t.Errorf("items.sql differs from the migrated schema (-migrations +items.sql):\n%s", diff)
+	}
+}
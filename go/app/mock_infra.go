@@ -11,7 +11,9 @@ package app
 
 import (
 	context "context"
+	sql "database/sql"
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
 )
@@ -55,6 +57,63 @@ func (mr *MockItemRepositoryMockRecorder) CategoryInsert(ctx, categoryName any)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CategoryInsert", reflect.TypeOf((*MockItemRepository)(nil).CategoryInsert), ctx, categoryName)
 }
 
+// CountByImage mocks base method.
+func (m *MockItemRepository) CountByImage(ctx context.Context, imageName string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountByImage", ctx, imageName)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountByImage indicates an expected call of CountByImage.
+func (mr *MockItemRepositoryMockRecorder) CountByImage(ctx, imageName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountByImage", reflect.TypeOf((*MockItemRepository)(nil).CountByImage), ctx, imageName)
+}
+
+// Delete mocks base method.
+func (m *MockItemRepository) Delete(ctx context.Context, id, version int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, id, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockItemRepositoryMockRecorder) Delete(ctx, id, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockItemRepository)(nil).Delete), ctx, id, version)
+}
+
+// DeleteCategory mocks base method.
+func (m *MockItemRepository) DeleteCategory(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCategory", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCategory indicates an expected call of DeleteCategory.
+func (mr *MockItemRepositoryMockRecorder) DeleteCategory(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCategory", reflect.TypeOf((*MockItemRepository)(nil).DeleteCategory), ctx, id)
+}
+
+// ExportItems mocks base method.
+func (m *MockItemRepository) ExportItems(ctx context.Context, filter ExportFilter, fn func(*ExportItem) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportItems", ctx, filter, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportItems indicates an expected call of ExportItems.
+func (mr *MockItemRepositoryMockRecorder) ExportItems(ctx, filter, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportItems", reflect.TypeOf((*MockItemRepository)(nil).ExportItems), ctx, filter, fn)
+}
+
 // GetAll mocks base method.
 func (m *MockItemRepository) GetAll(ctx context.Context) ([]*Item, error) {
 	m.ctrl.T.Helper()
@@ -85,6 +144,36 @@ func (mr *MockItemRepositoryMockRecorder) GetByID(ctx, id any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockItemRepository)(nil).GetByID), ctx, id)
 }
 
+// GetCategories mocks base method.
+func (m *MockItemRepository) GetCategories(ctx context.Context) ([]*Category, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCategories", ctx)
+	ret0, _ := ret[0].([]*Category)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCategories indicates an expected call of GetCategories.
+func (mr *MockItemRepositoryMockRecorder) GetCategories(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategories", reflect.TypeOf((*MockItemRepository)(nil).GetCategories), ctx)
+}
+
+// History mocks base method.
+func (m *MockItemRepository) History(ctx context.Context, id int) ([]*ItemEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "History", ctx, id)
+	ret0, _ := ret[0].([]*ItemEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// History indicates an expected call of History.
+func (mr *MockItemRepositoryMockRecorder) History(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "History", reflect.TypeOf((*MockItemRepository)(nil).History), ctx, id)
+}
+
 // Insert mocks base method.
 func (m *MockItemRepository) Insert(ctx context.Context, item *Item) error {
 	m.ctrl.T.Helper()
@@ -99,6 +188,109 @@ func (mr *MockItemRepositoryMockRecorder) Insert(ctx, item any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockItemRepository)(nil).Insert), ctx, item)
 }
 
+// InsertBatch mocks base method.
+func (m *MockItemRepository) InsertBatch(ctx context.Context, items []*Item) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertBatch", ctx, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InsertBatch indicates an expected call of InsertBatch.
+func (mr *MockItemRepositoryMockRecorder) InsertBatch(ctx, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertBatch", reflect.TypeOf((*MockItemRepository)(nil).InsertBatch), ctx, items)
+}
+
+// LastModified mocks base method.
+func (m *MockItemRepository) LastModified(ctx context.Context) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastModified", ctx)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LastModified indicates an expected call of LastModified.
+func (mr *MockItemRepositoryMockRecorder) LastModified(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastModified", reflect.TypeOf((*MockItemRepository)(nil).LastModified), ctx)
+}
+
+// MergeCategories mocks base method.
+func (m *MockItemRepository) MergeCategories(ctx context.Context, src, dst int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergeCategories", ctx, src, dst)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MergeCategories indicates an expected call of MergeCategories.
+func (mr *MockItemRepositoryMockRecorder) MergeCategories(ctx, src, dst any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeCategories", reflect.TypeOf((*MockItemRepository)(nil).MergeCategories), ctx, src, dst)
+}
+
+// ReferencedImages mocks base method.
+func (m *MockItemRepository) ReferencedImages(ctx context.Context) (map[string]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReferencedImages", ctx)
+	ret0, _ := ret[0].(map[string]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReferencedImages indicates an expected call of ReferencedImages.
+func (mr *MockItemRepositoryMockRecorder) ReferencedImages(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReferencedImages", reflect.TypeOf((*MockItemRepository)(nil).ReferencedImages), ctx)
+}
+
+// RenameCategory mocks base method.
+func (m *MockItemRepository) RenameCategory(ctx context.Context, id int, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameCategory", ctx, id, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameCategory indicates an expected call of RenameCategory.
+func (mr *MockItemRepositoryMockRecorder) RenameCategory(ctx, id, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameCategory", reflect.TypeOf((*MockItemRepository)(nil).RenameCategory), ctx, id, name)
+}
+
+// ReplaceImage mocks base method.
+func (m *MockItemRepository) ReplaceImage(ctx context.Context, oldName, newName string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceImage", ctx, oldName, newName)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReplaceImage indicates an expected call of ReplaceImage.
+func (mr *MockItemRepositoryMockRecorder) ReplaceImage(ctx, oldName, newName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceImage", reflect.TypeOf((*MockItemRepository)(nil).ReplaceImage), ctx, oldName, newName)
+}
+
+// Restore mocks base method.
+func (m *MockItemRepository) Restore(ctx context.Context, id int) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore", ctx, id)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Restore indicates an expected call of Restore.
+func (mr *MockItemRepositoryMockRecorder) Restore(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockItemRepository)(nil).Restore), ctx, id)
+}
+
 // SearchByKeyword mocks base method.
 func (m *MockItemRepository) SearchByKeyword(ctx context.Context, keyword string) ([]*Item, error) {
 	m.ctrl.T.Helper()
@@ -113,3 +305,163 @@ func (mr *MockItemRepositoryMockRecorder) SearchByKeyword(ctx, keyword any) *gom
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchByKeyword", reflect.TypeOf((*MockItemRepository)(nil).SearchByKeyword), ctx, keyword)
 }
+
+// SimilarItems mocks base method.
+func (m *MockItemRepository) SimilarItems(ctx context.Context, imageName string, maxDistance int) ([]*SimilarItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SimilarItems", ctx, imageName, maxDistance)
+	ret0, _ := ret[0].([]*SimilarItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SimilarItems indicates an expected call of SimilarItems.
+func (mr *MockItemRepositoryMockRecorder) SimilarItems(ctx, imageName, maxDistance any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SimilarItems", reflect.TypeOf((*MockItemRepository)(nil).SimilarItems), ctx, imageName, maxDistance)
+}
+
+// Update mocks base method.
+func (m *MockItemRepository) Update(ctx context.Context, item *Item) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, item)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockItemRepositoryMockRecorder) Update(ctx, item any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockItemRepository)(nil).Update), ctx, item)
+}
+
+// MockImageRepository is a mock of ImageRepository interface.
+type MockImageRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockImageRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockImageRepositoryMockRecorder is the mock recorder for MockImageRepository.
+type MockImageRepositoryMockRecorder struct {
+	mock *MockImageRepository
+}
+
+// NewMockImageRepository creates a new mock instance.
+func NewMockImageRepository(ctrl *gomock.Controller) *MockImageRepository {
+	mock := &MockImageRepository{ctrl: ctrl}
+	mock.recorder = &MockImageRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockImageRepository) EXPECT() *MockImageRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteUnreferencedImage mocks base method.
+func (m *MockImageRepository) DeleteUnreferencedImage(ctx context.Context, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUnreferencedImage", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteUnreferencedImage indicates an expected call of DeleteUnreferencedImage.
+func (mr *MockImageRepositoryMockRecorder) DeleteUnreferencedImage(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUnreferencedImage", reflect.TypeOf((*MockImageRepository)(nil).DeleteUnreferencedImage), ctx, name)
+}
+
+// Report mocks base method.
+func (m *MockImageRepository) Report(ctx context.Context) (*ImageReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Report", ctx)
+	ret0, _ := ret[0].(*ImageReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Report indicates an expected call of Report.
+func (mr *MockImageRepositoryMockRecorder) Report(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Report", reflect.TypeOf((*MockImageRepository)(nil).Report), ctx)
+}
+
+// SaveImage mocks base method.
+func (m *MockImageRepository) SaveImage(ctx context.Context, image *Image) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveImage", ctx, image)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveImage indicates an expected call of SaveImage.
+func (mr *MockImageRepositoryMockRecorder) SaveImage(ctx, image any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveImage", reflect.TypeOf((*MockImageRepository)(nil).SaveImage), ctx, image)
+}
+
+// Mockquerier is a mock of querier interface.
+type Mockquerier struct {
+	ctrl     *gomock.Controller
+	recorder *MockquerierMockRecorder
+	isgomock struct{}
+}
+
+// MockquerierMockRecorder is the mock recorder for Mockquerier.
+type MockquerierMockRecorder struct {
+	mock *Mockquerier
+}
+
+// NewMockquerier creates a new mock instance.
+func NewMockquerier(ctrl *gomock.Controller) *Mockquerier {
+	mock := &Mockquerier{ctrl: ctrl}
+	mock.recorder = &MockquerierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockquerier) EXPECT() *MockquerierMockRecorder {
+	return m.recorder
+}
+
+// ExecContext mocks base method.
+func (m *Mockquerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
+	ret0, _ := ret[0].(sql.Result)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExecContext indicates an expected call of ExecContext.
+func (mr *MockquerierMockRecorder) ExecContext(ctx, query any, args ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*Mockquerier)(nil).ExecContext), varargs...)
+}
+
+// QueryRowContext mocks base method.
+func (m *Mockquerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryRowContext", varargs...)
+	ret0, _ := ret[0].(*sql.Row)
+	return ret0
+}
+
+// QueryRowContext indicates an expected call of QueryRowContext.
+func (mr *MockquerierMockRecorder) QueryRowContext(ctx, query any, args ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRowContext", reflect.TypeOf((*Mockquerier)(nil).QueryRowContext), varargs...)
+}
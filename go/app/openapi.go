@@ -0,0 +1,653 @@
+package app
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// openAPIDocument is the OpenAPI 3 description of the API.
+// It must be kept in sync with the routes registered in routes; TestOpenAPIRoutes checks it.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// openAPISpec is the subset of an OpenAPI 3 document used for request and response validation.
+type openAPISpec struct {
+	Paths      map[string]*openAPIPathItem `json:"paths"`
+	Components struct {
+		Schemas    map[string]*openAPISchema    `json:"schemas"`
+		Parameters map[string]*openAPIParameter `json:"parameters"`
+		Responses  map[string]*openAPIResponse  `json:"responses"`
+	} `json:"components"`
+}
+
+type openAPIPathItem struct {
+	Get    *openAPIOperation `json:"get"`
+	Head   *openAPIOperation `json:"head"`
+	Post   *openAPIOperation `json:"post"`
+	Put    *openAPIOperation `json:"put"`
+	Patch  *openAPIOperation `json:"patch"`
+	Delete *openAPIOperation `json:"delete"`
+}
+
+// operations returns the operations of the path keyed by HTTP method.
+func (p *openAPIPathItem) operations() map[string]*openAPIOperation {
+	ops := map[string]*openAPIOperation{}
+	for method, op := range map[string]*openAPIOperation{
+		http.MethodGet:    p.Get,
+		http.MethodHead:   p.Head,
+		http.MethodPost:   p.Post,
+		http.MethodPut:    p.Put,
+		http.MethodPatch:  p.Patch,
+		http.MethodDelete: p.Delete,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Parameters  []*openAPIParameter         `json:"parameters"`
+	RequestBody *openAPIRequestBody         `json:"requestBody"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref      string         `json:"$ref"`
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Ref     string                       `json:"$ref"`
+	Content map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref"`
+	Type                 string                    `json:"type"`
+	Format               string                    `json:"format"`
+	Nullable             bool                      `json:"nullable"`
+	Enum                 []any                     `json:"enum"`
+	Required             []string                  `json:"required"`
+	Properties           map[string]*openAPISchema `json:"properties"`
+	AdditionalProperties json.RawMessage           `json:"additionalProperties"`
+	Items                *openAPISchema            `json:"items"`
+	MinLength            *int                      `json:"minLength"`
+	MaxLength            *int                      `json:"maxLength"`
+	Minimum              *float64                  `json:"minimum"`
+	Maximum              *float64                  `json:"maximum"`
+	Pattern              string                    `json:"pattern"`
+}
+
+// loadOpenAPISpec parses the embedded OpenAPI document.
+func loadOpenAPISpec() (*openAPISpec, error) {
+	var spec openAPISpec
+	if err := json.Unmarshal(openAPIDocument, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	return &spec, nil
+}
+
+// operation returns the operation for a route pattern such as "GET /items/{item_id}".
+func (s *openAPISpec) operation(pattern string) (*openAPIOperation, bool) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		return nil, false
+	}
+	item, ok := s.Paths[path]
+	if !ok {
+		return nil, false
+	}
+	op, ok := item.operations()[method]
+	return op, ok
+}
+
+func (s *openAPISpec) parameter(p *openAPIParameter) *openAPIParameter {
+	if p.Ref != "" {
+		return s.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+	}
+	return p
+}
+
+func (s *openAPISpec) response(r *openAPIResponse) *openAPIResponse {
+	if r.Ref != "" {
+		return s.Components.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]
+	}
+	return r
+}
+
+func (s *openAPISpec) schema(sc *openAPISchema) *openAPISchema {
+	if sc != nil && sc.Ref != "" {
+		return s.Components.Schemas[strings.TrimPrefix(sc.Ref, "#/components/schemas/")]
+	}
+	return sc
+}
+
+// openAPIValidationMiddleware rejects requests that do not match the operation
+// documented for the route pattern with 400 Bad Request (415 for an undocumented content type).
+// Routes without a documented operation are passed through unchanged.
+func openAPIValidationMiddleware(next http.Handler, spec *openAPISpec, pattern string) http.Handler {
+	op, ok := spec.operation(pattern)
+	if !ok {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, err := spec.validateRequest(op, r)
+		if err != nil {
+			slog.Warn("request does not match the OpenAPI document", "operation", op.OperationID, "error", err)
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				writeValidationError(w, r, verr)
+				return
+			}
+			writeJSONError(w, r, code, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateRequest validates the parameters and the body of r against op.
+// It returns the status code to respond with when the request is invalid.
+// Invalid parameters and fields are reported together as a *ValidationError.
+func (s *openAPISpec) validateRequest(op *openAPIOperation, r *http.Request) (int, error) {
+	verr := &ValidationError{}
+
+	for _, p := range op.Parameters {
+		p = s.parameter(p)
+
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value = r.PathValue(p.Name)
+			present = value != ""
+		case "query":
+			present = r.URL.Query().Has(p.Name)
+			value = r.URL.Query().Get(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+			present = value != ""
+		}
+
+		if !present {
+			if p.Required {
+				verr.add(p.Name, "is required")
+			}
+			continue
+		}
+		s.validateString(s.schema(p.Schema), value, p.Name, verr)
+	}
+
+	if op.RequestBody != nil {
+		if code, err := s.validateBody(op.RequestBody, r, verr); err != nil {
+			return code, err
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return http.StatusBadRequest, verr
+	}
+	return 0, nil
+}
+
+// validateBody validates the request body against the schema documented for its content type.
+func (s *openAPISpec) validateBody(body *openAPIRequestBody, r *http.Request, verr *ValidationError) (int, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		if body.Required {
+			return http.StatusBadRequest, errors.New("request body is required")
+		}
+		return 0, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("invalid Content-Type: %w", err)
+	}
+	content, ok := body.Content[mediaType]
+	if !ok {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("unsupported Content-Type %q", mediaType)
+	}
+	schema := s.schema(content.Schema)
+
+	switch mediaType {
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+			if isBodyTooLarge(err) {
+				return http.StatusRequestEntityTooLarge, errors.New("request body is too large")
+			}
+			return http.StatusBadRequest, fmt.Errorf("failed to parse form: %w", err)
+		}
+		s.validateForm(schema, r, verr)
+	case "application/json":
+		data, err := io.ReadAll(r.Body)
+		if isBodyTooLarge(err) {
+			return http.StatusRequestEntityTooLarge, errors.New("request body is too large")
+		}
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err)
+		}
+		// let the handler read the body again
+		r.Body = io.NopCloser(bytes.NewReader(data))
+
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		s.validateValue(schema, v, "", verr)
+	}
+
+	return 0, nil
+}
+
+// validateForm validates form fields against the properties of an object schema.
+// The form must already be parsed; it stays cached on r for the handler.
+func (s *openAPISpec) validateForm(schema *openAPISchema, r *http.Request, verr *ValidationError) {
+	for _, name := range slices.Sorted(maps.Keys(schema.Properties)) {
+		prop := s.schema(schema.Properties[name])
+		if prop.Format == "binary" {
+			if slices.Contains(schema.Required, name) {
+				if _, _, err := r.FormFile(name); err != nil {
+					verr.add(name, "is required")
+				}
+			}
+			continue
+		}
+
+		if _, ok := r.Form[name]; !ok {
+			if slices.Contains(schema.Required, name) {
+				verr.add(name, "is required")
+			}
+			continue
+		}
+		s.validateString(prop, r.Form.Get(name), name, verr)
+	}
+}
+
+// validateString validates a parameter or form value, which is always transferred as a string.
+func (s *openAPISpec) validateString(schema *openAPISchema, value, field string, verr *ValidationError) {
+	if schema == nil {
+		return
+	}
+
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			verr.add(field, "must be an integer")
+			return
+		}
+		s.validateValue(schema, float64(n), field, verr)
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			verr.add(field, "must be a number")
+			return
+		}
+		s.validateValue(schema, n, field, verr)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			verr.add(field, "must be a boolean")
+			return
+		}
+		s.validateValue(schema, b, field, verr)
+	default:
+		s.validateValue(schema, value, field, verr)
+	}
+}
+
+// validateValue validates a decoded JSON value against schema and adds the problems to verr.
+// field is the path of the value in the document, e.g. "items[0].name", and empty for the root.
+func (s *openAPISpec) validateValue(schema *openAPISchema, v any, field string, verr *ValidationError) {
+	schema = s.schema(schema)
+	if schema == nil {
+		return
+	}
+	if v == nil {
+		if !schema.Nullable && schema.Type != "" {
+			verr.add(field, "must not be null")
+		}
+		return
+	}
+
+	switch v.(type) {
+	case string, float64, bool:
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, v) {
+			verr.add(field, "must be one of %v", schema.Enum)
+			return
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			verr.add(field, "must be an object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				verr.add(joinField(field, name), "is required")
+			}
+		}
+		additional := s.additionalProperties(schema)
+		for _, name := range slices.Sorted(maps.Keys(obj)) {
+			prop, ok := schema.Properties[name]
+			if !ok {
+				if additional == nil {
+					verr.add(joinField(field, name), "is not allowed")
+					continue
+				}
+				prop = additional
+			}
+			s.validateValue(prop, obj[name], joinField(field, name), verr)
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			verr.add(field, "must be an array")
+			return
+		}
+		for i, e := range arr {
+			s.validateValue(schema.Items, e, fmt.Sprintf("%s[%d]", field, i), verr)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			verr.add(field, "must be a string")
+			return
+		}
+		if schema.MinLength != nil && len([]rune(str)) < *schema.MinLength {
+			if *schema.MinLength == 1 {
+				verr.add(field, "must not be empty")
+			} else {
+				verr.add(field, "must be at least %d characters", *schema.MinLength)
+			}
+		}
+		if schema.MaxLength != nil && len([]rune(str)) > *schema.MaxLength {
+			verr.add(field, "must be at most %d characters", *schema.MaxLength)
+		}
+		if schema.Pattern != "" {
+			if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(str) {
+				verr.add(field, "must match %s", schema.Pattern)
+			}
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			verr.add(field, "must be a %s", schema.Type)
+			return
+		}
+		if schema.Type == "integer" && n != float64(int64(n)) {
+			verr.add(field, "must be an integer")
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			verr.add(field, "must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			verr.add(field, "must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			verr.add(field, "must be a boolean")
+		}
+	}
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// additionalProperties returns the schema of properties not listed in schema.Properties,
+// or nil if they are not allowed. As in OpenAPI, they are allowed unless set to false.
+func (s *openAPISpec) additionalProperties(schema *openAPISchema) *openAPISchema {
+	raw := bytes.TrimSpace(schema.AdditionalProperties)
+	if bytes.Equal(raw, []byte("false")) {
+		return nil
+	}
+
+	var additional openAPISchema
+	if len(raw) > 0 && !bytes.Equal(raw, []byte("true")) {
+		// the document is embedded and checked by the tests, so it is always valid here
+		_ = json.Unmarshal(raw, &additional)
+	}
+	return &additional
+}
+
+// validateResponse validates a response body against the documented response of the operation.
+func (s *openAPISpec) validateResponse(op *openAPIOperation, code int, contentType string, body []byte) error {
+	resp, ok := op.Responses[strconv.Itoa(code)]
+	if !ok {
+		return fmt.Errorf("status code %d is not documented for %s", code, op.OperationID)
+	}
+	resp = s.response(resp)
+	if len(resp.Content) == 0 {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid Content-Type %q: %w", contentType, err)
+	}
+	content, ok := resp.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("Content-Type %q is not documented for %d of %s", mediaType, code, op.OperationID)
+	}
+	if mediaType != "application/json" {
+		return nil
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Errorf("invalid JSON response: %w", err)
+	}
+	verr := &ValidationError{}
+	s.validateValue(content.Schema, v, "", verr)
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// responseValidation is how responses are checked against the OpenAPI document, set by OPENAPI_RESPONSE_VALIDATION.
+type responseValidation string
+
+const (
+	// responseValidationOff does not check responses, as in production.
+	responseValidationOff responseValidation = "off"
+	// responseValidationLog logs the responses that do not match the document and sends them as they are.
+	responseValidationLog responseValidation = "log"
+	// responseValidationFail replaces the responses that do not match the document with 500 Internal Server Error,
+	// so that they fail tests in development and CI.
+	responseValidationFail responseValidation = "fail"
+)
+
+// parseResponseValidation parses OPENAPI_RESPONSE_VALIDATION, which is off if empty.
+func parseResponseValidation(value string) (responseValidation, error) {
+	switch mode := responseValidation(strings.ToLower(strings.TrimSpace(value))); mode {
+	case "":
+		return responseValidationOff, nil
+	case responseValidationOff, responseValidationLog, responseValidationFail:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown response validation %q; use off, log or fail", value)
+	}
+}
+
+// openAPIResponseValidationMiddleware checks the responses of the route against the operation documented for its pattern.
+// JSON responses are buffered to validate the body; other responses such as images and exports are streamed,
+// and only their status code and Content-Type are checked. Responses to HEAD requests are not checked.
+func openAPIResponseValidationMiddleware(next http.Handler, spec *openAPISpec, pattern string, mode responseValidation) http.Handler {
+	op, ok := spec.operation(pattern)
+	if !ok || mode == responseValidationOff {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		vw := &validatingWriter{ResponseWriter: w, r: r, spec: spec, op: op, mode: mode}
+		next.ServeHTTP(vw, r)
+		vw.finish()
+	})
+}
+
+// validatingWriter checks a response under openAPIResponseValidationMiddleware.
+// The decision to buffer is made when the header is written, since only then the Content-Type is known.
+type validatingWriter struct {
+	http.ResponseWriter
+	r    *http.Request
+	spec *openAPISpec
+	op   *openAPIOperation
+	mode responseValidation
+
+	code int
+	// buffered is set for JSON responses, whose body is kept in buf until the handler returns.
+	buffered bool
+	buf      bytes.Buffer
+	// discard drops the body of a streamed response that was replaced by an error.
+	discard bool
+}
+
+func (vw *validatingWriter) WriteHeader(code int) {
+	if vw.code != 0 {
+		return
+	}
+	vw.code = code
+
+	contentType := vw.Header().Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" {
+		vw.buffered = true
+		return
+	}
+	// nothing has been sent yet, so a streamed response can still be replaced
+	if err := vw.spec.validateResponse(vw.op, code, contentType, nil); err != nil && vw.reject(err) {
+		vw.discard = true
+		return
+	}
+	vw.ResponseWriter.WriteHeader(code)
+}
+
+func (vw *validatingWriter) Write(b []byte) (int, error) {
+	if vw.code == 0 {
+		if vw.Header().Get("Content-Type") == "" {
+			// same as net/http does for an unset Content-Type
+			vw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		vw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case vw.buffered:
+		return vw.buf.Write(b)
+	case vw.discard:
+		return len(b), nil
+	}
+	return vw.ResponseWriter.Write(b)
+}
+
+// Flush flushes streamed responses; buffered responses are written when the handler returns.
+func (vw *validatingWriter) Flush() {
+	if !vw.buffered && !vw.discard {
+		http.NewResponseController(vw.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (vw *validatingWriter) Unwrap() http.ResponseWriter {
+	return vw.ResponseWriter
+}
+
+// finish validates and writes a buffered response once the handler has returned.
+func (vw *validatingWriter) finish() {
+	if vw.code == 0 {
+		vw.WriteHeader(http.StatusOK)
+	}
+	if !vw.buffered {
+		return
+	}
+	err := vw.spec.validateResponse(vw.op, vw.code, vw.Header().Get("Content-Type"), vw.buf.Bytes())
+	if err != nil && vw.reject(err) {
+		return
+	}
+	vw.ResponseWriter.WriteHeader(vw.code)
+	vw.ResponseWriter.Write(vw.buf.Bytes())
+}
+
+// reject logs a response that does not match the document and, in fail mode, replaces it with 500.
+// It reports whether the response was replaced.
+func (vw *validatingWriter) reject(err error) bool {
+	slog.Error("response does not match the OpenAPI document", "operation", vw.op.OperationID, "status", vw.code, "error", err)
+	if vw.mode != responseValidationFail {
+		return false
+	}
+	h := vw.ResponseWriter.Header()
+	for _, key := range []string{"Content-Length", "Content-Disposition", "ETag", "Last-Modified", "Cache-Control"} {
+		h.Del(key)
+	}
+	writeJSONError(vw.ResponseWriter, vw.r, http.StatusInternalServerError, "response does not match the OpenAPI document: "+err.Error())
+	return true
+}
+
+// OpenAPI is a handler to return the OpenAPI document for GET /openapi.json .
+func (s *Handlers) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// swaggerUIPage renders the OpenAPI document with Swagger UI loaded from a CDN.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Simple Mercari API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// Docs is a handler to return the Swagger UI page for GET /docs .
+func (s *Handlers) Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, swaggerUIPage)
+}
@@ -0,0 +1,457 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+)
+
+// TestMain checks the responses of the servers built by the tests against the OpenAPI document,
+// unless OPENAPI_RESPONSE_VALIDATION is set otherwise.
+func TestMain(m *testing.M) {
+	if _, ok := os.LookupEnv("OPENAPI_RESPONSE_VALIDATION"); !ok {
+		os.Setenv("OPENAPI_RESPONSE_VALIDATION", string(responseValidationFail))
+	}
+	os.Exit(m.Run())
+}
+
+// TestOpenAPIRoutes checks that openapi.json documents exactly the registered routes
+// and that the routes with a body read by the validation limit it.
+func TestOpenAPIRoutes(t *testing.T) {
+	t.Parallel()
+
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatalf("failed to load OpenAPI document: %v", err)
+	}
+
+	registered := map[string]bool{}
+	for _, rt := range routes(&Handlers{}) {
+		registered[rt.pattern] = true
+		op, ok := spec.operation(rt.pattern)
+		if !ok {
+			t.Errorf("route %q is not documented in openapi.json", rt.pattern)
+			continue
+		}
+		// the validation reads JSON and form bodies, so they must be limited
+		if op.RequestBody != nil && rt.maxBodySize == 0 {
+			for mediaType := range op.RequestBody.Content {
+				if mediaType != "application/offset+octet-stream" {
+					t.Errorf("route %q accepts %s without maxBodySize", rt.pattern, mediaType)
+				}
+			}
+		}
+	}
+
+	for path, item := range spec.Paths {
+		for method := range item.operations() {
+			pattern := method + " " + path
+			if !registered[pattern] {
+				t.Errorf("operation %q is documented in openapi.json but not registered", pattern)
+			}
+		}
+	}
+}
+
+func TestOpenAPIValidationMiddleware(t *testing.T) {
+	t.Parallel()
+
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatalf("failed to load OpenAPI document: %v", err)
+	}
+
+	cases := map[string]struct {
+		pattern     string
+		method      string
+		target      string
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		"ok: valid item id": {
+			pattern:  "GET /items/{item_id}",
+			method:   "GET",
+			target:   "/items/1",
+			wantCode: http.StatusOK,
+		},
+		"ng: item id is not an integer": {
+			pattern:  "GET /items/{item_id}",
+			method:   "GET",
+			target:   "/items/abc",
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: item id is below the minimum": {
+			pattern:  "GET /items/{item_id}",
+			method:   "GET",
+			target:   "/items/0",
+			wantCode: http.StatusBadRequest,
+		},
+		"ok: search with keyword": {
+			pattern:  "GET /search",
+			method:   "GET",
+			target:   "/search?keyword=phone",
+			wantCode: http.StatusOK,
+		},
+		"ng: search without keyword": {
+			pattern:  "GET /search",
+			method:   "GET",
+			target:   "/search",
+			wantCode: http.StatusBadRequest,
+		},
+		"ok: valid form": {
+			pattern:     "POST /items",
+			method:      "POST",
+			target:      "/items",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"name": {"jacket"}, "category": {"fashion"}}.Encode(),
+			wantCode:    http.StatusOK,
+		},
+		"ng: form without name": {
+			pattern:     "POST /items",
+			method:      "POST",
+			target:      "/items",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"category": {"fashion"}}.Encode(),
+			wantCode:    http.StatusBadRequest,
+		},
+		"ok: valid JSON": {
+			pattern:     "POST /items",
+			method:      "POST",
+			target:      "/items",
+			contentType: "application/json",
+			body:        `{"name": "jacket", "category": "fashion"}`,
+			wantCode:    http.StatusOK,
+		},
+		"ng: JSON with unknown field": {
+			pattern:     "POST /items",
+			method:      "POST",
+			target:      "/items",
+			contentType: "application/json",
+			body:        `{"name": "jacket", "category": "fashion", "price": 100}`,
+			wantCode:    http.StatusBadRequest,
+		},
+		"ng: unsupported content type": {
+			pattern:     "POST /items",
+			method:      "POST",
+			target:      "/items",
+			contentType: "text/plain",
+			body:        "jacket",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			h := openAPIValidationMiddleware(next, spec, tt.pattern)
+			mux := http.NewServeMux()
+			mux.Handle(tt.pattern, h)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+// TestOpenAPIResponses checks that the handlers respond as documented in openapi.json.
+func TestOpenAPIResponseValidationMiddleware(t *testing.T) {
+	t.Parallel()
+
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatalf("failed to load OpenAPI document: %v", err)
+	}
+
+	writeJSON := func(code int, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			io.WriteString(w, body)
+		}
+	}
+	item := `{"id":1,"name":"jacket","category":"fashion","image_name":"default.jpg"}`
+	cases := map[string]struct {
+		pattern  string
+		target   string
+		handler  http.HandlerFunc
+		mode     responseValidation
+		wantCode int
+		wantBody string
+	}{
+		"ok: documented response": {
+			pattern:  "GET /items/{item_id}",
+			target:   "/items/1",
+			handler:  writeJSON(http.StatusOK, item),
+			mode:     responseValidationFail,
+			wantCode: http.StatusOK,
+			wantBody: item,
+		},
+		"ok: streamed response": {
+			pattern: "GET /images/{filename}",
+			target:  "/images/default.jpg",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/jpeg")
+				io.WriteString(w, "jpeg")
+				http.NewResponseController(w).Flush()
+			},
+			mode:     responseValidationFail,
+			wantCode: http.StatusOK,
+			wantBody: "jpeg",
+		},
+		"ok: mismatch is only logged": {
+			pattern:  "GET /items/{item_id}",
+			target:   "/items/1",
+			handler:  writeJSON(http.StatusOK, `{"id":"1"}`),
+			mode:     responseValidationLog,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"1"}`,
+		},
+		"ok: not validated": {
+			pattern:  "GET /items/{item_id}",
+			target:   "/items/1",
+			handler:  writeJSON(http.StatusTeapot, `{}`),
+			mode:     responseValidationOff,
+			wantCode: http.StatusTeapot,
+			wantBody: `{}`,
+		},
+		"ng: body does not match the schema": {
+			pattern:  "GET /items/{item_id}",
+			target:   "/items/1",
+			handler:  writeJSON(http.StatusOK, `{"id":"1"}`),
+			mode:     responseValidationFail,
+			wantCode: http.StatusInternalServerError,
+		},
+		"ng: undocumented status code": {
+			pattern:  "GET /items/{item_id}",
+			target:   "/items/1",
+			handler:  writeJSON(http.StatusTeapot, `{}`),
+			mode:     responseValidationFail,
+			wantCode: http.StatusInternalServerError,
+		},
+		"ng: undocumented content type of a streamed response": {
+			pattern: "GET /images/{filename}",
+			target:  "/images/default.jpg",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				io.WriteString(w, "png")
+			},
+			mode:     responseValidationFail,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mux := http.NewServeMux()
+			mux.Handle(tt.pattern, openAPIResponseValidationMiddleware(tt.handler, spec, tt.pattern, tt.mode))
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, httptest.NewRequest("GET", tt.target, nil))
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if tt.wantBody != "" && res.Body.String() != tt.wantBody {
+				t.Errorf("unexpected body. want=%s, got=%s", tt.wantBody, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseResponseValidation(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		value   string
+		want    responseValidation
+		wantErr bool
+	}{
+		"ok: off by default": {value: "", want: responseValidationOff},
+		"ok: log":            {value: "log", want: responseValidationLog},
+		"ok: fail":           {value: " FAIL ", want: responseValidationFail},
+		"ng: unknown mode":   {value: "strict", wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseResponseValidation(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected mode. want=%q, got=%q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	t.Parallel()
+
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatalf("failed to load OpenAPI document: %v", err)
+	}
+
+	items := []*Item{{ID: 1, Name: "jacket", Category: "fashion", Image: "default.jpg"}}
+	cases := map[string]struct {
+		pattern  string
+		target   string
+		header   map[string]string
+		body     string
+		injector func(m *MockItemRepository)
+	}{
+		"GET /items": {
+			pattern: "GET /items",
+			target:  "/items",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().LastModified(gomock.Any()).Return(time.Time{}, nil)
+				m.EXPECT().GetAll(gomock.Any()).Return(items, nil)
+			},
+		},
+		"GET /items with no items": {
+			pattern: "GET /items",
+			target:  "/items",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().LastModified(gomock.Any()).Return(time.Time{}, nil)
+				m.EXPECT().GetAll(gomock.Any()).Return(nil, nil)
+			},
+		},
+		"GET /items/{item_id}": {
+			pattern: "GET /items/{item_id}",
+			target:  "/items/1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(items[0], nil)
+			},
+		},
+		"PATCH /items/{item_id}": {
+			pattern: "PATCH /items/{item_id}",
+			target:  "/items/1",
+			header:  map[string]string{"If-Match": itemETag(items[0])},
+			body:    `{"name":"blue jacket"}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(copyItem(items[0]), nil)
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		"PATCH /items/{item_id} with a stale ETag": {
+			pattern: "PATCH /items/{item_id}",
+			target:  "/items/1",
+			header:  map[string]string{"If-Match": `"item-1-v0"`},
+			body:    `{"name":"blue jacket"}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(&Item{ID: 1, Version: 2}, nil)
+			},
+		},
+		"DELETE /items/{item_id} without If-Match": {
+			pattern:  "DELETE /items/{item_id}",
+			target:   "/items/1",
+			injector: func(m *MockItemRepository) {},
+		},
+		"DELETE /items/{item_id}": {
+			pattern: "DELETE /items/{item_id}",
+			target:  "/items/1",
+			header:  map[string]string{"If-Match": "*"},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(items[0], nil)
+				m.EXPECT().Delete(gomock.Any(), 1, 0).Return(&ItemConflictError{ID: 1})
+			},
+		},
+		"GET /items/{item_id}/history": {
+			pattern: "GET /items/{item_id}/history",
+			target:  "/items/1/history",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().History(gomock.Any(), 1).Return([]*ItemEvent{
+					{ID: 1, ItemID: 1, Action: "create", Actor: "api", After: json.RawMessage(`{"id":1,"name":"jacket","category":"fashion","image_name":"default.jpg"}`)},
+				}, nil)
+			},
+		},
+		"GET /items/export": {
+			pattern: "GET /items/export",
+			target:  "/items/export?format=parquet",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ExportItems(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		"GET /search": {
+			pattern: "GET /search",
+			target:  "/search?keyword=jacket",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SearchByKeyword(gomock.Any(), "jacket").Return(items, nil)
+			},
+		},
+		"GET /version": {
+			pattern:  "GET /version",
+			target:   "/version",
+			injector: func(m *MockItemRepository) {},
+		},
+		"GET /debug/cache": {
+			pattern:  "GET /debug/cache",
+			target:   "/debug/cache",
+			header:   map[string]string{"Authorization": "Bearer secret"},
+			injector: func(m *MockItemRepository) {},
+		},
+		"GET /admin/images without image metadata": {
+			pattern:  "GET /admin/images",
+			target:   "/admin/images",
+			header:   map[string]string{"Authorization": "Bearer secret"},
+			injector: func(m *MockItemRepository) {},
+		},
+		"GET /debug/cache without the admin token": {
+			pattern:  "GET /debug/cache",
+			target:   "/debug/cache",
+			injector: func(m *MockItemRepository) {},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR, adminToken: "secret"}
+
+			mux := http.NewServeMux()
+			for _, rt := range routes(h) {
+				mux.Handle(rt.pattern, rt.handler)
+			}
+
+			method, _, _ := strings.Cut(tt.pattern, " ")
+			req := httptest.NewRequest(method, tt.target, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+
+			op, _ := spec.operation(tt.pattern)
+			if err := spec.validateResponse(op, res.Code, res.Header().Get("Content-Type"), res.Body.Bytes()); err != nil {
+				t.Errorf("response does not match openapi.json: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitPolicy describes a token bucket applied to a route.
+type RateLimitPolicy struct {
+	// Name identifies the policy. Buckets are kept per policy and client,
+	// so routes with different policies do not share tokens.
+	Name string
+	// Rate is the number of tokens added to the bucket per second.
+	Rate float64
+	// Burst is the capacity of the bucket.
+	Burst int
+	// Key returns the client identifier the bucket is kept for.
+	// If nil, clientIPKey is used.
+	Key func(r *http.Request) string
+}
+
+// RateLimitResult is the state of a bucket after taking a token.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next token is available. It is zero when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets.
+// The in-memory implementation is enough for a single process; an implementation
+// backed by a shared store (e.g. Redis) is needed when running several replicas.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// full is the time it takes the bucket to refill from empty.
+	full time.Duration
+}
+
+// memoryRateLimitStore is an in-memory implementation of RateLimitStore.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	// now is replaceable for testing.
+	now func() time.Time
+}
+
+// sweepInterval is how often idle buckets are dropped from memory.
+const sweepInterval = time.Minute
+
+// NewMemoryRateLimitStore creates a new in-memory RateLimitStore.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *memoryRateLimitStore) Take(_ context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	burst := float64(policy.Burst)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now, full: secondsToDuration(burst / policy.Rate)}
+		m.buckets[key] = b
+	}
+
+	// refill tokens for the elapsed time
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*policy.Rate)
+	b.last = now
+
+	res := RateLimitResult{Limit: policy.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - b.tokens) / policy.Rate)
+	}
+	res.Remaining = int(math.Floor(b.tokens))
+	res.Reset = secondsToDuration((burst - b.tokens) / policy.Rate)
+
+	return res, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again,
+// which is the same as not having a bucket at all.
+// The caller must hold m.mu.
+func (m *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for k, b := range m.buckets {
+		if now.Sub(b.last) > b.full {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// clientIPKey identifies a client by the IP address of the connection.
+// X-Forwarded-For is not trusted because the server is exposed without a proxy.
+func clientIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitMiddleware rejects requests exceeding the policy with 429 Too Many Requests.
+// It sets the RateLimit-* headers on every response and Retry-After on rejected ones.
+// If the store fails, the request is let through so that an outage of a shared
+// store does not take the API down.
+func rateLimitMiddleware(next http.Handler, store RateLimitStore, policy RateLimitPolicy) http.Handler {
+	keyFunc := policy.Key
+	if keyFunc == nil {
+		keyFunc = clientIPKey
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := policy.Name + ":" + keyFunc(r)
+		res, err := store.Take(r.Context(), key, policy)
+		if err != nil {
+			slog.Error("failed to check rate limit", "policy", policy.Name, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			slog.Warn("rate limit exceeded", "policy", policy.Name, "key", key)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	type step struct {
+		remoteAddr string
+		advance    time.Duration
+		wantCode   int
+		wantRetry  string
+	}
+	cases := map[string]struct {
+		policy RateLimitPolicy
+		steps  []step
+	}{
+		"ok: burst is allowed then rejected": {
+			policy: RateLimitPolicy{Name: "test", Rate: 1, Burst: 2},
+			steps: []step{
+				{remoteAddr: "192.0.2.1:1234", wantCode: http.StatusOK},
+				{remoteAddr: "192.0.2.1:1234", wantCode: http.StatusOK},
+				{remoteAddr: "192.0.2.1:1234", wantCode: http.StatusTooManyRequests, wantRetry: "1"},
+			},
+		},
+		"ok: tokens are refilled over time": {
+			policy: RateLimitPolicy{Name: "test", Rate: 0.5, Burst: 1},
+			steps: []step{
+				{remoteAddr: "192.0.2.1:1234", wantCode: http.StatusOK},
+				{remoteAddr: "192.0.2.1:1234", wantCode: http.StatusTooManyRequests, wantRetry: "2"},
+				{remoteAddr: "192.0.2.1:1234", advance: 2 * time.Second, wantCode: http.StatusOK},
+			},
+		},
+		"ok: clients have separate buckets": {
+			policy: RateLimitPolicy{Name: "test", Rate: 1, Burst: 1},
+			steps: []step{
+				{remoteAddr: "192.0.2.1:1234", wantCode: http.StatusOK},
+				{remoteAddr: "192.0.2.2:1234", wantCode: http.StatusOK},
+				{remoteAddr: "192.0.2.1:5678", wantCode: http.StatusTooManyRequests, wantRetry: "1"},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			store := NewMemoryRateLimitStore().(*memoryRateLimitStore)
+			store.now = func() time.Time { return now }
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			h := rateLimitMiddleware(next, store, tt.policy)
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+
+				req := httptest.NewRequest("GET", "/search", nil)
+				req.RemoteAddr = s.remoteAddr
+				res := httptest.NewRecorder()
+				h.ServeHTTP(res, req)
+
+				if res.Code != s.wantCode {
+					t.Errorf("step %d: unexpected status code. want=%d, got=%d", i, s.wantCode, res.Code)
+				}
+				if got := res.Header().Get("Retry-After"); got != s.wantRetry {
+					t.Errorf("step %d: unexpected Retry-After. want=%q, got=%q", i, s.wantRetry, got)
+				}
+				if res.Header().Get("RateLimit-Limit") == "" {
+					t.Errorf("step %d: RateLimit-Limit header is missing", i)
+				}
+			}
+		})
+	}
+}
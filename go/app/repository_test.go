@@ -0,0 +1,711 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// repositoryBackend creates an empty store implementing both repositories.
+type repositoryBackend func(t *testing.T) (ItemRepository, ImageRepository)
+
+// repositoryBackends returns the stores selectable with ITEM_STORE, which must behave the same.
+// SQLite is checked with every driver linked into the test.
+func repositoryBackends() map[string]repositoryBackend {
+	backends := map[string]repositoryBackend{
+		"json": func(t *testing.T) (ItemRepository, ImageRepository) {
+			repo, err := NewJSONItemRepository(filepath.Join(t.TempDir(), "db", "mercari.json"))
+			if err != nil {
+				t.Fatalf("failed to open JSON store: %v", err)
+			}
+			t.Cleanup(func() { repo.Close() })
+			return repo, repo
+		},
+	}
+	for _, driver := range sqliteTestDrivers() {
+		backends["sqlite-"+sqliteDriverPackages[driver]] = func(t *testing.T) (ItemRepository, ImageRepository) {
+			if testing.Short() {
+				t.Skip("skipping e2e test")
+			}
+			// with the separate pools of the server, so that reads see the writes before them
+			db, err := openSQLiteDB(context.Background(), driver, filepath.Join(t.TempDir(), "mercari.sqlite3"), defaultSQLitePragmas)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if err := migrate(context.Background(), db.write); err != nil {
+				t.Fatalf("failed to migrate database: %v", err)
+			}
+			return NewItemRepositoryWithPools(db.write, db.read), NewImageRepositoryWithPools(db.write, db.read)
+		}
+	}
+	return backends
+}
+
+// TestRepositoryConformance runs the same checks against every backend.
+func TestRepositoryConformance(t *testing.T) {
+	t.Parallel()
+
+	checks := map[string]func(t *testing.T, items ItemRepository, images ImageRepository){
+		"items":      testRepositoryItems,
+		"search":     testRepositorySearch,
+		"categories": testRepositoryCategories,
+		"images":     testRepositoryImages,
+		"export":     testRepositoryExport,
+		"similar":    testRepositorySimilar,
+		"history":    testRepositoryHistory,
+		"versions":   testRepositoryVersions,
+		"modified":   testRepositoryLastModified,
+	}
+	for backend, newRepo := range repositoryBackends() {
+		for check, fn := range checks {
+			t.Run(backend+"/"+check, func(t *testing.T) {
+				t.Parallel()
+
+				items, images := newRepo(t)
+				fn(t, items, images)
+			})
+		}
+	}
+}
+
+func insertItems(t *testing.T, repo ItemRepository, items ...*Item) {
+	t.Helper()
+
+	for _, item := range items {
+		if err := repo.Insert(context.Background(), item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+}
+
+func testRepositoryItems(t *testing.T, repo ItemRepository, _ ImageRepository) {
+	ctx := context.Background()
+	item := &Item{Name: "jacket", Category: "fashion", Image: defaultImageName}
+	insertItems(t, repo, item)
+	if item.ID != 1 {
+		t.Errorf("unexpected ID of the first item: %d", item.ID)
+	}
+	batch := []*Item{
+		{Name: "shirt", Category: "fashion", Image: defaultImageName},
+		{Name: "cap", Category: "hat", Image: defaultImageName},
+	}
+	if err := repo.InsertBatch(ctx, batch); err != nil {
+		t.Fatalf("failed to insert items: %v", err)
+	}
+	if batch[0].ID != 2 || batch[1].ID != 3 {
+		t.Errorf("unexpected IDs of the batch: %d, %d", batch[0].ID, batch[1].ID)
+	}
+
+	got, err := repo.GetByID(ctx, 3)
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+	if diff := cmp.Diff(batch[1], got, cmpopts.IgnoreFields(Item{}, "UpdatedAt")); diff != "" {
+		t.Errorf("unexpected item (-want +got):\n%s", diff)
+	}
+	if got.UpdatedAt.IsZero() {
+		t.Errorf("updated_at is not set")
+	}
+	if _, err := repo.GetByID(ctx, 4); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error getting a missing item: %v", err)
+	}
+
+	item.Name, item.Category = "blue jacket", "outer"
+	if err := repo.Update(ctx, item); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	if err := repo.Delete(ctx, 2, batch[0].Version); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	all, err := repo.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("failed to get items: %v", err)
+	}
+	want := []*Item{item, batch[1]}
+	if diff := cmp.Diff(want, all, cmpopts.IgnoreFields(Item{}, "UpdatedAt")); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+
+	if err := repo.Update(ctx, batch[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error updating a deleted item: %v", err)
+	}
+	if err := repo.Delete(ctx, 2, batch[0].Version); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error deleting a deleted item: %v", err)
+	}
+	// IDs of deleted items are not reused
+	next := &Item{Name: "socks", Category: "fashion", Image: defaultImageName}
+	insertItems(t, repo, next)
+	if next.ID != 4 {
+		t.Errorf("unexpected ID after a deletion: %d", next.ID)
+	}
+}
+
+func testRepositorySearch(t *testing.T, repo ItemRepository, _ ImageRepository) {
+	ctx := context.Background()
+	insertItems(t, repo,
+		&Item{Name: "Jacket", Category: "fashion", Image: defaultImageName},
+		&Item{Name: "cap", Category: "hat", Image: defaultImageName},
+		&Item{Name: "shirt", Category: "Fashion wear", Image: defaultImageName},
+	)
+
+	items, err := repo.SearchByKeyword(ctx, "jack")
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(items) != 1 || items[0].Name != "Jacket" {
+		t.Errorf("unexpected items matching the name: %v", items)
+	}
+	items, err = repo.SearchByKeyword(ctx, "fashion")
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	if diff := cmp.Diff([]string{"Jacket", "shirt"}, names); diff != "" {
+		t.Errorf("unexpected items matching the category (-want +got):\n%s", diff)
+	}
+
+	if _, err := repo.SearchByKeyword(ctx, ""); err == nil {
+		t.Errorf("expected an error for an empty keyword")
+	}
+	if _, err := repo.SearchByKeyword(ctx, "shoes"); err == nil {
+		t.Errorf("expected an error when no items match")
+	}
+}
+
+func testRepositoryCategories(t *testing.T, repo ItemRepository, _ ImageRepository) {
+	ctx := context.Background()
+	insertItems(t, repo,
+		&Item{Name: "jacket", Category: "fashion", Image: defaultImageName},
+		&Item{Name: "shirt", Category: "clothes", Image: defaultImageName},
+	)
+	id, err := repo.CategoryInsert(ctx, "fashion")
+	if err != nil || id != 1 {
+		t.Errorf("inserting an existing category should return its ID: %d, %v", id, err)
+	}
+	empty, err := repo.CategoryInsert(ctx, "empty")
+	if err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+
+	if err := repo.RenameCategory(ctx, 2, "fashion"); !errors.Is(err, errCategoryExists) {
+		t.Errorf("renaming to an existing name should fail: %v", err)
+	}
+	if err := repo.RenameCategory(ctx, 9, "shoes"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("renaming a missing category should fail: %v", err)
+	}
+	if err := repo.RenameCategory(ctx, 1, "outer"); err != nil {
+		t.Fatalf("failed to rename category: %v", err)
+	}
+	if err := repo.DeleteCategory(ctx, 2); !errors.Is(err, errCategoryNotEmpty) {
+		t.Errorf("deleting a category with items should fail: %v", err)
+	}
+	if err := repo.DeleteCategory(ctx, empty); err != nil {
+		t.Fatalf("failed to delete category: %v", err)
+	}
+	if err := repo.DeleteCategory(ctx, empty); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting a deleted category should fail: %v", err)
+	}
+	moved, err := repo.MergeCategories(ctx, 2, 1)
+	if err != nil {
+		t.Fatalf("failed to merge categories: %v", err)
+	}
+	if moved != 1 {
+		t.Errorf("unexpected number of items moved. want=1, got=%d", moved)
+	}
+	if _, err := repo.MergeCategories(ctx, 2, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("merging a deleted category should fail: %v", err)
+	}
+
+	categories, err := repo.GetCategories(ctx)
+	if err != nil {
+		t.Fatalf("failed to get categories: %v", err)
+	}
+	want := []*Category{{ID: 1, Name: "outer", Items: 2}}
+	if diff := cmp.Diff(want, categories); diff != "" {
+		t.Errorf("unexpected categories (-want +got):\n%s", diff)
+	}
+}
+
+func testRepositoryImages(t *testing.T, repo ItemRepository, images ImageRepository) {
+	ctx := context.Background()
+	oldImage := strings.Repeat("a", 64) + ".jpg"
+	newImage := strings.Repeat("b", 64) + ".jpg"
+	unused := strings.Repeat("c", 64) + ".jpg"
+	for _, image := range []*Image{
+		{Name: oldImage, Size: 100, MIMEType: "image/jpeg", Width: 10, Height: 10},
+		{Name: unused, Size: 50, MIMEType: "image/jpeg", Width: 10, Height: 10},
+	} {
+		if err := images.SaveImage(ctx, image); err != nil {
+			t.Fatalf("failed to save image: %v", err)
+		}
+	}
+	insertItems(t, repo,
+		&Item{Name: "jacket", Category: "fashion", Image: oldImage},
+		&Item{Name: "shirt", Category: "fashion", Image: oldImage},
+		&Item{Name: "cap", Category: "hat", Image: defaultImageName},
+	)
+
+	if n, err := repo.CountByImage(ctx, oldImage); err != nil || n != 2 {
+		t.Errorf("unexpected count of %s: %d, %v", oldImage, n, err)
+	}
+	refs, err := repo.ReferencedImages(ctx)
+	if err != nil {
+		t.Fatalf("failed to get referenced images: %v", err)
+	}
+	if diff := cmp.Diff(map[string]int{oldImage: 2, defaultImageName: 1}, refs); diff != "" {
+		t.Errorf("unexpected referenced images (-want +got):\n%s", diff)
+	}
+
+	report, err := images.Report(ctx)
+	if err != nil {
+		t.Fatalf("failed to report images: %v", err)
+	}
+	want := &ImageReport{Images: 2, Unreferenced: 1, StoredBytes: 150, ReferencedBytes: 250, SavedBytes: 100}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%s", diff)
+	}
+
+	if deleted, err := images.DeleteUnreferencedImage(ctx, oldImage); err != nil || deleted {
+		t.Errorf("a referenced image should not be deleted: %v, %v", deleted, err)
+	}
+	if deleted, err := images.DeleteUnreferencedImage(ctx, unused); err != nil || !deleted {
+		t.Errorf("an unreferenced image should be deleted: %v, %v", deleted, err)
+	}
+
+	n, err := repo.ReplaceImage(ctx, oldImage, newImage)
+	if err != nil {
+		t.Fatalf("failed to replace image: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("unexpected number of items replaced. want=2, got=%d", n)
+	}
+	if n, _ := repo.CountByImage(ctx, oldImage); n != 0 {
+		t.Errorf("items still refer to the replaced image: %d", n)
+	}
+	report, err = images.Report(ctx)
+	if err != nil {
+		t.Fatalf("failed to report images: %v", err)
+	}
+	// the new image was not saved, so its size is unknown
+	want = &ImageReport{Images: 2, Unreferenced: 1, UnknownSize: 1, StoredBytes: 100, ReferencedBytes: 100}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report after replacing (-want +got):\n%s", diff)
+	}
+	if deleted, err := images.DeleteUnreferencedImage(ctx, oldImage); err != nil || !deleted {
+		t.Errorf("the replaced image should be deleted: %v, %v", deleted, err)
+	}
+}
+
+func testRepositoryExport(t *testing.T, repo ItemRepository, _ ImageRepository) {
+	ctx := context.Background()
+	insertItems(t, repo,
+		&Item{Name: "jacket", Category: "fashion", Image: defaultImageName},
+		&Item{Name: "shirt", Category: "fashion", Image: defaultImageName},
+		&Item{Name: "down jacket", Category: "outer", Image: defaultImageName},
+	)
+
+	cases := map[string]struct {
+		filter ExportFilter
+		want   []int
+	}{
+		"all items": {
+			want: []int{1, 2, 3},
+		},
+		"category": {
+			filter: ExportFilter{Category: "fashion"},
+			want:   []int{1, 2},
+		},
+		"keyword": {
+			filter: ExportFilter{Keyword: "JACKET"},
+			want:   []int{1, 3},
+		},
+		"updated since": {
+			filter: ExportFilter{UpdatedSince: time.Now().Add(-time.Hour)},
+			want:   []int{1, 2, 3},
+		},
+		"updated in the future": {
+			filter: ExportFilter{UpdatedSince: time.Now().Add(time.Hour)},
+		},
+	}
+	for name, tt := range cases {
+		var got []int
+		err := repo.ExportItems(ctx, tt.filter, func(item *ExportItem) error {
+			got = append(got, item.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to export items: %v", name, err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: unexpected items (-want +got):\n%s", name, diff)
+		}
+	}
+
+	stop := errors.New("stop")
+	err := repo.ExportItems(ctx, ExportFilter{}, func(*ExportItem) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("the error of fn should be returned: %v", err)
+	}
+}
+
+func testRepositorySimilar(t *testing.T, repo ItemRepository, images ImageRepository) {
+	ctx := context.Background()
+	names := []string{strings.Repeat("a", 64) + ".jpg", strings.Repeat("b", 64) + ".jpg", strings.Repeat("c", 64) + ".jpg"}
+	hashes := []uint64{0xff00, 0xff01, 0x00ff}
+	for i, name := range names {
+		if err := images.SaveImage(ctx, &Image{Name: name, Size: 1, MIMEType: "image/jpeg", AHash: hashes[i], DHash: hashes[i]}); err != nil {
+			t.Fatalf("failed to save image: %v", err)
+		}
+		insertItems(t, repo, &Item{Name: "item", Category: "fashion", Image: name})
+	}
+
+	similar, err := repo.SimilarItems(ctx, names[0], 4)
+	if err != nil {
+		t.Fatalf("failed to get similar items: %v", err)
+	}
+	var got [][2]int
+	for _, s := range similar {
+		got = append(got, [2]int{s.ID, s.Distance})
+	}
+	if diff := cmp.Diff([][2]int{{1, 0}, {2, 1}}, got); diff != "" {
+		t.Errorf("unexpected similar items (-want +got):\n%s", diff)
+	}
+
+	similar, err = repo.SimilarItems(ctx, defaultImageName, 64)
+	if err != nil || len(similar) != 0 {
+		t.Errorf("images without hashes should have no similar items: %v, %v", similar, err)
+	}
+}
+
+// testRepositoryHistory checks that deleted items are hidden until restored, and that every change is in the history.
+func testRepositoryHistory(t *testing.T, repo ItemRepository, _ ImageRepository) {
+	ctx := WithActor(context.Background(), "tester")
+	image := strings.Repeat("a", 64) + ".jpg"
+	jacket := &Item{Name: "jacket", Category: "fashion", Image: image}
+	if err := repo.Insert(ctx, jacket); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	insertItems(t, repo, &Item{Name: "shirt", Category: "fashion", Image: defaultImageName})
+	jacket.Name = "blue jacket"
+	if err := repo.Update(ctx, jacket); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	if err := repo.Delete(ctx, jacket.ID, jacket.Version); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	if _, err := repo.GetByID(ctx, jacket.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error getting a deleted item: %v", err)
+	}
+	all, err := repo.GetAll(ctx)
+	if err != nil || len(all) != 1 || all[0].Name != "shirt" {
+		t.Errorf("deleted items should not be listed: %v, %v", all, err)
+	}
+	if items, err := repo.SearchByKeyword(ctx, "jacket"); err == nil {
+		t.Errorf("deleted items should not be found: %v", items)
+	}
+	var exported []string
+	if err := repo.ExportItems(ctx, ExportFilter{}, func(item *ExportItem) error {
+		exported = append(exported, item.Name)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to export items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"shirt"}, exported); diff != "" {
+		t.Errorf("unexpected exported items (-want +got):\n%s", diff)
+	}
+	exported = nil
+	if err := repo.ExportItems(ctx, ExportFilter{Deleted: true}, func(item *ExportItem) error {
+		exported = append(exported, item.Name)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to export items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"blue jacket", "shirt"}, exported); diff != "" {
+		t.Errorf("unexpected exported items with the deleted ones (-want +got):\n%s", diff)
+	}
+	categories, err := repo.GetCategories(ctx)
+	if err != nil || len(categories) != 1 || categories[0].Items != 1 {
+		t.Errorf("deleted items should not be counted in categories: %v, %v", categories, err)
+	}
+	// the image is kept for the restore
+	if n, err := repo.CountByImage(ctx, image); err != nil || n != 1 {
+		t.Errorf("unexpected count of the image of a deleted item: %d, %v", n, err)
+	}
+
+	if _, err := repo.Restore(ctx, 2); !errors.Is(err, errItemNotDeleted) {
+		t.Errorf("unexpected error restoring an item that is not deleted: %v", err)
+	}
+	if _, err := repo.Restore(ctx, 9); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error restoring a missing item: %v", err)
+	}
+	restored, err := repo.Restore(ctx, jacket.ID)
+	if err != nil {
+		t.Fatalf("failed to restore item: %v", err)
+	}
+	if diff := cmp.Diff(jacket, restored, cmpopts.IgnoreFields(Item{}, "UpdatedAt", "Version")); diff != "" {
+		t.Errorf("unexpected restored item (-want +got):\n%s", diff)
+	}
+	// deleting and restoring are changes as well
+	if restored.Version != jacket.Version+2 {
+		t.Errorf("unexpected version of the restored item. want=%d, got=%d", jacket.Version+2, restored.Version)
+	}
+	if err := repo.RenameCategory(ctx, 1, "clothes"); err != nil {
+		t.Fatalf("failed to rename category: %v", err)
+	}
+
+	events, err := repo.History(ctx, jacket.ID)
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	type change struct {
+		Action, Actor string
+		Before, After *Item
+	}
+	var got []change
+	for _, e := range events {
+		c := change{Action: e.Action, Actor: e.Actor}
+		for raw, item := range map[*json.RawMessage]**Item{&e.Before: &c.Before, &e.After: &c.After} {
+			if len(*raw) == 0 {
+				continue
+			}
+			if err := json.Unmarshal(*raw, item); err != nil {
+				t.Fatalf("invalid item in event: %v", err)
+			}
+		}
+		if e.ItemID != jacket.ID || e.CreatedAt.IsZero() {
+			t.Errorf("unexpected event: %+v", e)
+		}
+		got = append(got, c)
+	}
+	v1 := &Item{ID: jacket.ID, Name: "jacket", Category: "fashion", Image: image}
+	v2 := &Item{ID: jacket.ID, Name: "blue jacket", Category: "fashion", Image: image}
+	v3 := &Item{ID: jacket.ID, Name: "blue jacket", Category: "clothes", Image: image}
+	want := []change{
+		{Action: "create", Actor: "tester", After: v1},
+		{Action: "update", Actor: "tester", Before: v1, After: v2},
+		{Action: "delete", Actor: "tester", Before: v2},
+		{Action: "restore", Actor: "tester", After: v2},
+		{Action: "update", Actor: "tester", Before: v2, After: v3},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected history (-want +got):\n%s", diff)
+	}
+	if events, err := repo.History(ctx, 2); err != nil || len(events) != 2 || events[0].Actor != "system" {
+		t.Errorf("unexpected history of an item changed without an actor: %v, %v", events, err)
+	}
+	if events, err := repo.History(ctx, 9); err != nil || len(events) != 0 {
+		t.Errorf("unexpected history of a missing item: %v, %v", events, err)
+	}
+
+	// deleted items keep their category so that they can be restored
+	for _, id := range []int{1, 2} {
+		item, err := repo.GetByID(ctx, id)
+		if err != nil {
+			t.Fatalf("failed to get item: %v", err)
+		}
+		if err := repo.Delete(ctx, id, item.Version); err != nil {
+			t.Fatalf("failed to delete item: %v", err)
+		}
+	}
+	if err := repo.DeleteCategory(ctx, 1); !errors.Is(err, errCategoryNotEmpty) {
+		t.Errorf("unexpected error deleting a category of deleted items: %v", err)
+	}
+}
+
+// testRepositoryLastModified checks that deleting an item modifies the items, though the item is no longer listed.
+func testRepositoryLastModified(t *testing.T, repo ItemRepository, _ ImageRepository) {
+	ctx := context.Background()
+	if modified, err := repo.LastModified(ctx); err != nil || !modified.IsZero() {
+		t.Errorf("unexpected last modified time without items: %v, %v", modified, err)
+	}
+	jacket := &Item{Name: "jacket", Category: "fashion", Image: defaultImageName}
+	shirt := &Item{Name: "shirt", Category: "fashion", Image: defaultImageName}
+	insertItems(t, repo, jacket, shirt)
+	if added, err := repo.LastModified(ctx); err != nil || added.IsZero() {
+		t.Errorf("expected the last modified time to be set by the insert: %v, %v", added, err)
+	}
+
+	beforeDelete := time.Now()
+	if err := repo.Delete(ctx, jacket.ID, jacket.Version); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	deleted, err := repo.LastModified(ctx)
+	if err != nil {
+		t.Fatalf("failed to get last modified time: %v", err)
+	}
+	if deleted.Before(beforeDelete) {
+		t.Errorf("expected the deletion to modify the items. deleted before %v, got=%v", beforeDelete, deleted)
+	}
+	restored, err := repo.Restore(ctx, jacket.ID)
+	if err != nil {
+		t.Fatalf("failed to restore item: %v", err)
+	}
+	if restored.UpdatedAt.Before(deleted) {
+		t.Errorf("expected the restore to modify the item. deleted=%v, restored=%v", deleted, restored.UpdatedAt)
+	}
+}
+
+// testRepositoryVersions checks that items are only changed at the version they were read at,
+// so that concurrent changes do not overwrite each other.
+func testRepositoryVersions(t *testing.T, repo ItemRepository, _ ImageRepository) {
+	ctx := context.Background()
+	item := &Item{Name: "jacket", Category: "fashion", Image: defaultImageName}
+	insertItems(t, repo, item)
+	if item.Version != 1 {
+		t.Errorf("unexpected version of a new item: %d", item.Version)
+	}
+
+	stale := *item
+	item.Name = "blue jacket"
+	if err := repo.Update(ctx, item); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	if item.Version != 2 {
+		t.Errorf("unexpected version after an update: %d", item.Version)
+	}
+	stale.Name = "red jacket"
+	var conflict *ItemConflictError
+	if err := repo.Update(ctx, &stale); !errors.As(err, &conflict) || conflict.ID != item.ID || conflict.Version != 1 {
+		t.Errorf("unexpected error updating a stale item: %v", err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("expected a failed update not to change the version: %d", stale.Version)
+	}
+	if err := repo.Delete(ctx, item.ID, 1); !errors.As(err, &conflict) {
+		t.Errorf("unexpected error deleting a stale item: %v", err)
+	}
+	got, err := repo.GetByID(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+	if diff := cmp.Diff(item, got, cmpopts.IgnoreFields(Item{}, "UpdatedAt")); diff != "" {
+		t.Errorf("unexpected item after the conflicts (-want +got):\n%s", diff)
+	}
+
+	// the category is part of the item
+	if err := repo.RenameCategory(ctx, 1, "clothes"); err != nil {
+		t.Fatalf("failed to rename category: %v", err)
+	}
+	if err := repo.Update(ctx, item); !errors.As(err, &conflict) {
+		t.Errorf("unexpected error updating an item whose category was renamed: %v", err)
+	}
+
+	// only one of concurrent updates of the same version wins
+	current, err := repo.GetByID(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+	const writers = 8
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			update := *current
+			update.Name = fmt.Sprintf("jacket %d", i)
+			errs <- repo.Update(ctx, &update)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	updated := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			updated++
+		case !errors.As(err, &conflict):
+			t.Errorf("unexpected error of a concurrent update: %v", err)
+		}
+	}
+	if updated != 1 {
+		t.Errorf("unexpected number of concurrent updates applied. want=1, got=%d", updated)
+	}
+	if got, err := repo.GetByID(ctx, item.ID); err != nil || got.Version != current.Version+1 {
+		t.Errorf("unexpected version after concurrent updates: %+v, %v", got, err)
+	}
+}
+
+func TestJSONItemRepositoryShared(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "mercari.json")
+	first, err := NewJSONItemRepository(path)
+	if err != nil {
+		t.Fatalf("failed to open JSON store: %v", err)
+	}
+	defer first.Close()
+	second, err := NewJSONItemRepository(path)
+	if err != nil {
+		t.Fatalf("failed to open JSON store again: %v", err)
+	}
+	defer second.Close()
+
+	insertItems(t, first, &Item{Name: "jacket", Category: "fashion", Image: defaultImageName})
+	// the second instance, e.g. another process, sees the item and does not reuse its ID
+	insertItems(t, second, &Item{Name: "shirt", Category: "fashion", Image: defaultImageName})
+	items, err := first.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("failed to get items: %v", err)
+	}
+	var got []string
+	for _, item := range items {
+		got = append(got, item.Name)
+	}
+	if diff := cmp.Diff([]string{"jacket", "shirt"}, got); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read store: %v", err)
+	}
+	if !json.Valid(b) {
+		t.Errorf("store is not valid JSON:\n%s", b)
+	}
+}
+
+func TestParseItemStore(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		value   string
+		want    itemStore
+		wantErr bool
+	}{
+		"ok: sqlite by default": {value: "", want: itemStoreSQLite},
+		"ok: json":              {value: "json", want: itemStoreJSON},
+		"ng: unknown store":     {value: "mysql", wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseItemStore(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected store. want=%s, got=%s", tt.want, got)
+			}
+		})
+	}
+}
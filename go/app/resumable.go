@@ -0,0 +1,335 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads follow the core protocol of tus (https://tus.io/protocols/resumable-upload):
+//
+//  1. POST /uploads with Upload-Length and Upload-Sha256 headers creates an upload
+//     and returns its URL in Location.
+//  2. PATCH /uploads/{upload_id} with Upload-Offset appends a chunk to the upload.
+//     If the connection drops, HEAD /uploads/{upload_id} returns the Upload-Offset to resume from.
+//  3. The PATCH that completes the upload verifies the SHA-256 of the whole file and
+//     stores it like POST /images, returning the image name and upload token.
+//
+// Chunks are appended to "<upload_id>.part" in the uploads directory, next to a
+// "<upload_id>.json" file holding the metadata of the upload.
+
+// uploadsDirName is the directory under the image directory storing incomplete uploads.
+const uploadsDirName = ".uploads"
+
+// uploadIDPattern matches the IDs generated by CreateUpload.
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+var errUploadNotFound = errors.New("upload not found")
+
+// resumableUpload is the metadata of an incomplete upload.
+type resumableUpload struct {
+	ID string `json:"id"`
+	// Length is the size of the complete file in bytes.
+	Length int64 `json:"length"`
+	// SHA256 is the hex encoded SHA-256 of the complete file, as sent by the client.
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// resumableUploads stores incomplete uploads on disk.
+type resumableUploads struct {
+	dir string
+	// locks serializes requests to the same upload.
+	locks sync.Map // upload ID -> *sync.Mutex
+}
+
+func newResumableUploads(imgDirPath string) *resumableUploads {
+	return &resumableUploads{dir: filepath.Join(imgDirPath, uploadsDirName)}
+}
+
+func (u *resumableUploads) lock(id string) func() {
+	m, _ := u.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := m.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// acquire locks an existing upload and returns it with the number of bytes received so far.
+// Unknown IDs are not locked, so that requests for made-up IDs do not leave entries in locks.
+func (u *resumableUploads) acquire(id string) (*resumableUpload, int64, func(), error) {
+	if _, _, err := u.get(id); err != nil {
+		return nil, 0, nil, err
+	}
+	unlock := u.lock(id)
+	up, offset, err := u.get(id)
+	if err != nil {
+		// removed while waiting for the lock
+		u.locks.Delete(id)
+		unlock()
+		return nil, 0, nil, err
+	}
+	return up, offset, unlock, nil
+}
+
+func (u *resumableUploads) metaPath(id string) string {
+	return filepath.Join(u.dir, id+".json")
+}
+
+func (u *resumableUploads) partPath(id string) string {
+	return filepath.Join(u.dir, id+".part")
+}
+
+// create starts a new upload.
+func (u *resumableUploads) create(length int64, sum string) (*resumableUpload, error) {
+	if err := os.MkdirAll(u.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	up := &resumableUpload{ID: hex.EncodeToString(b), Length: length, SHA256: sum, CreatedAt: time.Now().UTC()}
+
+	meta, err := json.Marshal(up)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(u.partPath(up.ID), nil, 0644); err != nil {
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	if err := os.WriteFile(u.metaPath(up.ID), meta, 0644); err != nil {
+		os.Remove(u.partPath(up.ID))
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	return up, nil
+}
+
+// get returns the upload and the number of bytes received so far.
+func (u *resumableUploads) get(id string) (*resumableUpload, int64, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, 0, errUploadNotFound
+	}
+
+	meta, err := os.ReadFile(u.metaPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, errUploadNotFound
+		}
+		return nil, 0, err
+	}
+	var up resumableUpload
+	if err := json.Unmarshal(meta, &up); err != nil {
+		return nil, 0, fmt.Errorf("failed to read upload %s: %w", id, err)
+	}
+
+	info, err := os.Stat(u.partPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	return &up, info.Size(), nil
+}
+
+// appendChunk appends up to the remaining length of the upload from r and returns the new offset.
+func (u *resumableUploads) appendChunk(up *resumableUpload, offset int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(u.partPath(up.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(r, up.Length-offset))
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	// keep what was received even if the connection dropped; the client resumes from the new offset
+	return offset + n, err
+}
+
+// remove deletes the upload.
+func (u *resumableUploads) remove(id string) {
+	os.Remove(u.partPath(id))
+	os.Remove(u.metaPath(id))
+	u.locks.Delete(id)
+}
+
+// removeExpired deletes uploads created before the deadline and returns their IDs.
+func (u *resumableUploads) removeExpired(deadline time.Time) ([]string, error) {
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var removed []string
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		up, _, err := u.get(id)
+		if err != nil || !up.CreatedAt.Before(deadline) {
+			continue
+		}
+		unlock := u.lock(id)
+		u.remove(id)
+		unlock()
+		removed = append(removed, id)
+	}
+	return removed, nil
+}
+
+// CreateUpload is a handler to start a resumable upload for POST /uploads .
+func (s *Handlers) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	verr := &ValidationError{}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		verr.add("Upload-Length", "must be a positive integer")
+	} else if length > maxAddItemBodySize {
+		verr.add("Upload-Length", "must be at most %d", maxAddItemBodySize)
+	}
+	sum := r.Header.Get("Upload-Sha256")
+	if !sha256HexPattern.MatchString(sum) {
+		verr.add("Upload-Sha256", "must be a hex encoded SHA-256")
+	}
+	if len(verr.Fields) > 0 {
+		writeValidationError(w, r, verr)
+		return
+	}
+
+	up, err := s.uploads.create(length, sum)
+	if err != nil {
+		slog.Error("failed to create upload", "error", err)
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("upload created", "upload_id", up.ID, "length", up.Length)
+	// keep the version prefix the upload was created with
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+up.ID)
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// sha256HexPattern matches a hex encoded SHA-256 as sent in Upload-Sha256.
+var sha256HexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// GetUploadOffset is a handler to return the progress of an upload for HEAD /uploads/{upload_id} .
+func (s *Handlers) GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	up, offset, unlock, err := s.uploads.acquire(r.PathValue("upload_id"))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	defer unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload is a handler to append a chunk to an upload for PATCH /uploads/{upload_id} .
+// Upload-Offset must match the number of bytes received so far.
+// When the upload is complete, the image is stored and UploadImageResponse is returned.
+func (s *Handlers) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	up, offset, unlock, err := s.uploads.acquire(r.PathValue("upload_id"))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	defer unlock()
+	if r.Header.Get("Upload-Offset") != strconv.FormatInt(offset, 10) {
+		// the client has a stale offset; it should ask with HEAD and resume from there
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+
+	offset, err = s.uploads.appendChunk(up, offset, r.Body)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		slog.Warn("upload interrupted", "upload_id", up.ID, "offset", offset, "error", err)
+		http.Error(w, "failed to receive chunk", http.StatusBadRequest)
+		return
+	}
+	if offset < up.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.completeUpload(w, r, up)
+}
+
+// completeUpload verifies the received file and stores it in the image directory.
+// The upload is removed whether or not it is valid; a corrupt upload has to be started over.
+func (s *Handlers) completeUpload(w http.ResponseWriter, r *http.Request, up *resumableUpload) {
+	defer s.uploads.remove(up.ID)
+
+	image, err := os.ReadFile(s.uploads.partPath(up.ID))
+	if err != nil {
+		slog.Error("failed to read upload", "upload_id", up.ID, "error", err)
+		http.Error(w, "failed to read upload", http.StatusInternalServerError)
+		return
+	}
+
+	// the hash of the file as uploaded; the image name is the hash after storeImage re-encodes it
+	hash := sha256.Sum256(image)
+	if hex.EncodeToString(hash[:]) != up.SHA256 {
+		slog.Warn("upload checksum mismatch", "upload_id", up.ID)
+		verr := &ValidationError{}
+		verr.add("Upload-Sha256", "does not match the uploaded file")
+		writeValidationError(w, r, verr)
+		return
+	}
+
+	fileName, err := s.storeImage(r.Context(), image)
+	if err != nil {
+		writeStoreImageError(w, r, "image", err)
+		return
+	}
+
+	slog.Info("upload completed", "upload_id", up.ID, "image_name", fileName)
+	expiresAt := time.Now().Add(uploadTokenTTL).Truncate(time.Second)
+	resp := UploadImageResponse{
+		ImageName:   fileName,
+		UploadToken: signUploadToken(s.uploadSecret, fileName, expiresAt),
+		ExpiresAt:   expiresAt.UTC(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUploadNotFound) {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	slog.Error("failed to read upload", "error", err)
+	http.Error(w, "failed to read upload", http.StatusInternalServerError)
+}
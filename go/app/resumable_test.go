@@ -0,0 +1,181 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newUploadTestServer(t *testing.T) (*Handlers, *http.ServeMux) {
+	t.Helper()
+
+	dir := t.TempDir()
+	h := &Handlers{imgDirPath: dir, uploadSecret: []byte("secret"), uploads: newResumableUploads(dir)}
+	mux := http.NewServeMux()
+	for _, rt := range routes(h) {
+		mux.Handle(rt.pattern, rt.handler)
+	}
+	return h, mux
+}
+
+func createUpload(t *testing.T, mux *http.ServeMux, image []byte) string {
+	t.Helper()
+
+	hash := sha256.Sum256(image)
+	req := httptest.NewRequest("POST", "/uploads", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(image)))
+	req.Header.Set("Upload-Sha256", hex.EncodeToString(hash[:]))
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if res.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code. want=%d, got=%d: %s", http.StatusCreated, res.Code, res.Body.String())
+	}
+	return res.Header().Get("Location")
+}
+
+func patchUpload(mux *http.ServeMux, location string, offset int, chunk string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PATCH", location, strings.NewReader(chunk))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	return res
+}
+
+func TestResumableUpload(t *testing.T) {
+	t.Parallel()
+
+	_, mux := newUploadTestServer(t)
+	image := encodeTestJPEG(t, 8, 8, nil)
+	location := createUpload(t, mux, image)
+
+	// first chunk
+	res := patchUpload(mux, location, 0, string(image[:6]))
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status code. want=%d, got=%d: %s", http.StatusNoContent, res.Code, res.Body.String())
+	}
+
+	// the client lost the response and asks where to resume from
+	req := httptest.NewRequest("HEAD", location, nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if got := res.Header().Get("Upload-Offset"); got != "6" {
+		t.Fatalf("unexpected Upload-Offset. want=6, got=%s", got)
+	}
+
+	// a stale offset is rejected
+	res = patchUpload(mux, location, 0, string(image[:6]))
+	if res.Code != http.StatusConflict {
+		t.Fatalf("unexpected status code. want=%d, got=%d: %s", http.StatusConflict, res.Code, res.Body.String())
+	}
+
+	// the last chunk completes the upload
+	res = patchUpload(mux, location, 6, string(image[6:]))
+	if res.Code != http.StatusOK {
+		t.Fatalf("unexpected status code. want=%d, got=%d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	var uploaded UploadImageResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &uploaded); err != nil {
+		t.Fatalf("failed to unmarshal response body: %v", err)
+	}
+	// the name is the hash of the sanitized image rather than the uploaded file
+	sanitized, err := sanitizeImage(image, imageSanitizeOptions{})
+	if err != nil {
+		t.Fatalf("failed to sanitize image: %v", err)
+	}
+	hash := sha256.Sum256(sanitized)
+	if want := hex.EncodeToString(hash[:]) + ".jpg"; uploaded.ImageName != want {
+		t.Errorf("unexpected image name. want=%s, got=%s", want, uploaded.ImageName)
+	}
+
+	// the upload is gone once it is complete
+	res = patchUpload(mux, location, len(image), "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code. want=%d, got=%d: %s", http.StatusNotFound, res.Code, res.Body.String())
+	}
+}
+
+func TestResumableUploadErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		location func(t *testing.T, mux *http.ServeMux) string
+		chunk    string
+		wantCode int
+	}{
+		"ng: unknown upload": {
+			location: func(t *testing.T, mux *http.ServeMux) string {
+				return "/uploads/" + strings.Repeat("0", 32)
+			},
+			chunk:    "jacket image",
+			wantCode: http.StatusNotFound,
+		},
+		"ng: checksum mismatch": {
+			location: func(t *testing.T, mux *http.ServeMux) string {
+				return createUpload(t, mux, []byte("jacket image"))
+			},
+			chunk:    "jacket imagf",
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: not an image": {
+			location: func(t *testing.T, mux *http.ServeMux) string {
+				return createUpload(t, mux, []byte("jacket image"))
+			},
+			chunk:    "jacket image",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h, mux := newUploadTestServer(t)
+			res := patchUpload(mux, tt.location(t, mux), 0, tt.chunk)
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			// the upload is gone or never existed, so no lock may be left for it
+			h.uploads.locks.Range(func(id, _ any) bool {
+				t.Errorf("lock left for upload %s", id)
+				return true
+			})
+		})
+	}
+}
+
+func TestRemoveExpiredUploads(t *testing.T) {
+	t.Parallel()
+
+	uploads := newResumableUploads(t.TempDir())
+	up, err := uploads.create(10, strings.Repeat("a", 64))
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+
+	removed, err := uploads.removeExpired(up.CreatedAt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("upload should not have expired yet: %v", removed)
+	}
+
+	removed, err = uploads.removeExpired(up.CreatedAt.Add(time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != up.ID {
+		t.Errorf("unexpected removed uploads: %v", removed)
+	}
+	if _, _, err := uploads.get(up.ID); err != errUploadNotFound {
+		t.Errorf("upload should have been removed: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"strconv"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Defaults of imageSanitizeOptions.
+const (
+	defaultImageQuality      = 85
+	defaultImageMaxDimension = 2048
+)
+
+// maxImagePixels rejects images that would take too much memory to decode, e.g. decompression bombs.
+const maxImagePixels = 50_000_000
+
+var errInvalidImage = errors.New("not a supported image")
+
+// invalidImageMessage is the validation error of fields with an image that is not supported.
+const invalidImageMessage = "must be a JPEG, PNG or GIF image"
+
+// imageSanitizeOptions configures how uploaded images are re-encoded.
+// Zero fields use the defaults.
+type imageSanitizeOptions struct {
+	// Quality is the JPEG quality of stored images, from 1 to 100.
+	Quality int
+	// MaxDimension is the maximum width and height of stored images.
+	// Larger images are scaled down keeping the aspect ratio.
+	MaxDimension int
+}
+
+func (o imageSanitizeOptions) withDefaults() imageSanitizeOptions {
+	if o.Quality == 0 {
+		o.Quality = defaultImageQuality
+	}
+	if o.MaxDimension == 0 {
+		o.MaxDimension = defaultImageMaxDimension
+	}
+	return o
+}
+
+// parseImageSanitizeOptions parses the options as set in IMAGE_QUALITY and IMAGE_MAX_DIMENSION.
+// Empty values use the defaults.
+func parseImageSanitizeOptions(quality, maxDimension string) (imageSanitizeOptions, error) {
+	var opts imageSanitizeOptions
+	if quality != "" {
+		q, err := strconv.Atoi(quality)
+		if err != nil || q < 1 || q > 100 {
+			return opts, fmt.Errorf("invalid image quality %q: want 1 to 100", quality)
+		}
+		opts.Quality = q
+	}
+	if maxDimension != "" {
+		d, err := strconv.Atoi(maxDimension)
+		if err != nil || d < 1 {
+			return opts, fmt.Errorf("invalid maximum image dimension %q: want a positive number of pixels", maxDimension)
+		}
+		opts.MaxDimension = d
+	}
+	return opts.withDefaults(), nil
+}
+
+// sanitizeImage decodes an uploaded image and re-encodes it as a JPEG.
+// Re-encoding drops all metadata such as the GPS coordinates in EXIF, and anything
+// appended to the image that is not part of it, e.g. in polyglot files.
+// The EXIF orientation is applied to the pixels since it is dropped as well.
+// It returns errInvalidImage if data is not a JPEG, PNG or GIF image.
+func sanitizeImage(data []byte, opts imageSanitizeOptions) ([]byte, error) {
+	opts = opts.withDefaults()
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels is too large", errInvalidImage, cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidImage
+	}
+
+	img := flattenImage(src)
+	if format == "jpeg" {
+		img = orientImage(img, jpegOrientation(data))
+	}
+	img = fitImage(img, opts.MaxDimension)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.Quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// flattenImage draws img on a white background, since JPEG has no transparency.
+func flattenImage(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// fitImage scales img down so that neither side exceeds maxDimension.
+func fitImage(img *image.RGBA, maxDimension int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= maxDimension && h <= maxDimension {
+		return img
+	}
+
+	scale := math.Min(float64(maxDimension)/float64(w), float64(maxDimension)/float64(h))
+	dw := max(1, int(math.Round(float64(w)*scale)))
+	dh := max(1, int(math.Round(float64(h)*scale)))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+// orientImage transforms img so that it is displayed upright without the EXIF orientation.
+// See the Orientation tag of the EXIF specification for the values.
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// the image is rotated by 90 degrees
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated by 180 degrees
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated by 90 degrees clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated by 90 degrees counterclockwise to display
+				dx, dy = y, w-1-x
+			}
+			si, di := img.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], img.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, or 1 (upright) if it has none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	// walk the segments until the image data starts
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation returns the Orientation tag in the first IFD of EXIF data, or 1 if it has none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := range entries {
+		e := ifd + 2 + k*12
+		if e+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 { // Orientation, a SHORT stored in the value field
+			if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encodeTestJPEG returns a w x h JPEG image with app1 inserted as its first segment if set.
+func encodeTestJPEG(t *testing.T, w, h int, app1 []byte) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	data := buf.Bytes()
+	// right after the SOI marker
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+// exifSegment returns an APP1 segment with the orientation and a GPS latitude reference.
+func exifSegment(orientation uint16) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8)
+	// IFD0 at 8: Orientation and the pointer to the GPS IFD at 38
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = le.AppendUint16(tiff, 0x0112)
+	tiff = le.AppendUint16(tiff, 3)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint16(tiff, orientation)
+	tiff = le.AppendUint16(tiff, 0)
+	tiff = le.AppendUint16(tiff, 0x8825)
+	tiff = le.AppendUint16(tiff, 4)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, 38)
+	tiff = le.AppendUint32(tiff, 0)
+	// GPS IFD: GPSLatitudeRef "N"
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 0x0001)
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = le.AppendUint32(tiff, 2)
+	tiff = append(tiff, 'N', 0, 0, 0)
+	tiff = le.AppendUint32(tiff, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+// pngHeader returns the start of a PNG image claiming to be w x h, enough for image.DecodeConfig.
+func pngHeader(w, h uint32) []byte {
+	ihdr := []byte("IHDR")
+	ihdr = binary.BigEndian.AppendUint32(ihdr, w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0) // 8 bit RGBA
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)-4))
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestSanitizeImage(t *testing.T) {
+	t.Parallel()
+
+	var transparent bytes.Buffer
+	if err := png.Encode(&transparent, image.NewNRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	type wants struct {
+		width, height int
+		err           bool
+	}
+	cases := map[string]struct {
+		data []byte
+		opts imageSanitizeOptions
+		wants
+	}{
+		"ok: jpeg without metadata": {
+			data:  encodeTestJPEG(t, 4, 2, nil),
+			wants: wants{width: 4, height: 2},
+		},
+		"ok: exif is stripped": {
+			data:  encodeTestJPEG(t, 4, 2, exifSegment(1)),
+			wants: wants{width: 4, height: 2},
+		},
+		"ok: orientation is applied": {
+			data:  encodeTestJPEG(t, 4, 2, exifSegment(6)),
+			wants: wants{width: 2, height: 4},
+		},
+		"ok: transparent png": {
+			data:  transparent.Bytes(),
+			wants: wants{width: 3, height: 2},
+		},
+		"ok: scaled down": {
+			data:  encodeTestJPEG(t, 40, 20, nil),
+			opts:  imageSanitizeOptions{MaxDimension: 10},
+			wants: wants{width: 10, height: 5},
+		},
+		"ok: appended data is dropped": {
+			data:  append(encodeTestJPEG(t, 4, 2, nil), "<script>"...),
+			wants: wants{width: 4, height: 2},
+		},
+		"ng: not an image": {
+			data:  []byte("jacket image"),
+			wants: wants{err: true},
+		},
+		"ng: data before the image": {
+			data:  append([]byte("jacket"), encodeTestJPEG(t, 4, 2, nil)...),
+			wants: wants{err: true},
+		},
+		"ng: too many pixels": {
+			data:  pngHeader(100_000, 100_000),
+			wants: wants{err: true},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := sanitizeImage(tt.data, tt.opts)
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err {
+				return
+			}
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("failed to decode sanitized image: %v", err)
+			}
+			if format != "jpeg" {
+				t.Errorf("unexpected format. want=jpeg, got=%s", format)
+			}
+			if cfg.Width != tt.width || cfg.Height != tt.height {
+				t.Errorf("unexpected size. want=%dx%d, got=%dx%d", tt.width, tt.height, cfg.Width, cfg.Height)
+			}
+			for _, s := range []string{"Exif", "<script>"} {
+				if bytes.Contains(got, []byte(s)) {
+					t.Errorf("sanitized image should not contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		data []byte
+		want int
+	}{
+		"ok: no exif": {
+			data: encodeTestJPEG(t, 1, 1, nil),
+			want: 1,
+		},
+		"ok: rotated": {
+			data: encodeTestJPEG(t, 1, 1, exifSegment(8)),
+			want: 8,
+		},
+		"ok: out of range": {
+			data: encodeTestJPEG(t, 1, 1, exifSegment(9)),
+			want: 1,
+		},
+		"ok: truncated": {
+			data: encodeTestJPEG(t, 1, 1, exifSegment(6))[:20],
+			want: 1,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("unexpected orientation. want=%d, got=%d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseImageSanitizeOptions(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		quality, maxDimension string
+		want                  imageSanitizeOptions
+		wantErr               bool
+	}{
+		"ok: defaults": {
+			want: imageSanitizeOptions{Quality: defaultImageQuality, MaxDimension: defaultImageMaxDimension},
+		},
+		"ok: set": {
+			quality: "70", maxDimension: "1024",
+			want: imageSanitizeOptions{Quality: 70, MaxDimension: 1024},
+		},
+		"ng: quality out of range": {
+			quality: "0",
+			wantErr: true,
+		},
+		"ng: not a number": {
+			maxDimension: "large",
+			wantErr:      true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseImageSanitizeOptions(tt.quality, tt.maxDimension)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("unexpected options. want=%+v, got=%+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,18 +1,25 @@
 package app
 
 import (
+	"cmp"
+	"context"
 	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Server struct {
@@ -20,8 +27,38 @@ type Server struct {
 	Port string
 	// ImageDirPath is the path to the directory storing images.
 	ImageDirPath string
+	// DBPath is the path to the SQLite database. If empty, DefaultDBPath is used.
+	DBPath string
+	// RateLimitStore keeps the rate limit state. If nil, an in-memory store is used.
+	RateLimitStore RateLimitStore
 }
 
+// Rate limit policies for the routes that are expensive to serve.
+var (
+	// addItemRateLimit allows 10 items per minute with bursts of 5, since each request may write an image to disk.
+	addItemRateLimit = RateLimitPolicy{Name: "add-item", Rate: 10.0 / 60, Burst: 5}
+	// uploadImageRateLimit is the same as addItemRateLimit, since each request writes an image to disk.
+	uploadImageRateLimit = RateLimitPolicy{Name: "upload-image", Rate: 10.0 / 60, Burst: 5}
+	// bulkAddItemsRateLimit allows 2 imports in a row and then 1 per minute, since each request may add thousands of items.
+	bulkAddItemsRateLimit = RateLimitPolicy{Name: "bulk-add-items", Rate: 1.0 / 60, Burst: 2}
+	// exportItemsRateLimit allows 5 exports in a row and then 1 per minute, since each export reads the whole catalog.
+	exportItemsRateLimit = RateLimitPolicy{Name: "export-items", Rate: 1.0 / 60, Burst: 5}
+	// backupRateLimit allows 2 backups in a row and then 1 per 10 minutes, since each backup copies the database and every image.
+	backupRateLimit = RateLimitPolicy{Name: "backup", Rate: 1.0 / 600, Burst: 2}
+	// editItemRateLimit allows 30 changes per minute with bursts of 10, since each change writes the item and its audit record.
+	editItemRateLimit = RateLimitPolicy{Name: "edit-item", Rate: 30.0 / 60, Burst: 10}
+	// searchRateLimit allows 5 searches per second with bursts of 20, since each search scans the items table.
+	searchRateLimit = RateLimitPolicy{Name: "search", Rate: 5, Burst: 20}
+)
+
+// Request timeouts. Adding an item gets more time since the request carries an image.
+const (
+	defaultRequestTimeout = 5 * time.Second
+	addItemRequestTimeout = 30 * time.Second
+	// bulkAddItemsRequestTimeout is long enough to store the images of maxBulkItems rows.
+	bulkAddItemsRequestTimeout = 5 * time.Minute
+)
+
 // Run is a method to start the server.
 // This method returns 0 if the server started successfully, and 1 otherwise.
 func (s Server) Run() int {
@@ -34,42 +71,271 @@ func (s Server) Run() int {
 	// STEP 4-6: set the log level to DEBUG
 	slog.SetLogLoggerLevel(slog.LevelInfo)
 
+	handler, closeStores, err := s.Handler(context.Background())
+	if err != nil {
+		slog.Error("failed to set up server: ", "error", err)
+		return 1
+	}
+	defer closeStores()
+
+	// start the server
+	slog.Info("http server started on", "port", s.Port)
+	srv := &http.Server{
+		Addr:              ":" + s.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	err = srv.ListenAndServe()
+	if err != nil {
+		slog.Error("failed to start server: ", "error", err)
+		return 1
+	}
+
+	return 0
+}
+
+// Handler builds the handler of the API with the repositories of the server.
+// Background work such as the upload GC stops when ctx is done, and closeStores releases the repositories.
+// Each server owns its repositories, so servers with different DBPath and ImageDirPath can run in one process.
+func (s Server) Handler(ctx context.Context) (handler http.Handler, closeStores func() error, err error) {
 	// set up CORS settings
 	frontURL, found := os.LookupEnv("FRONT_URL")
 	if !found {
 		frontURL = "http://localhost:3000"
 	}
 
-	// STEP 5-1: set up the database connection
+	placeholders, err := PlaceholderImages()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	imageOptions, err := parseImageSanitizeOptions(os.Getenv("IMAGE_QUALITY"), os.Getenv("IMAGE_MAX_DIMENSION"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse image options: %w", err)
+	}
+
+	similarImages, err := parseSimilarImagePolicy(os.Getenv("SIMILAR_IMAGE_POLICY"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse SIMILAR_IMAGE_POLICY: %w", err)
+	}
+
+	store, err := parseItemStore(os.Getenv("ITEM_STORE"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ITEM_STORE: %w", err)
+	}
+
+	pragmas, err := parseSQLitePragmas(os.Getenv("SQLITE_PRAGMAS"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse SQLITE_PRAGMAS: %w", err)
+	}
+
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load OpenAPI document: %w", err)
+	}
+	validation, err := parseResponseValidation(os.Getenv("OPENAPI_RESPONSE_VALIDATION"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse OPENAPI_RESPONSE_VALIDATION: %w", err)
+	}
+
+	// open the repositories last, so that nothing is left open on the errors above
+	stores, err := s.openStores(ctx, store, pragmas)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// set up handlers
-	itemRepo := NewItemRepository()
-	h := &Handlers{imgDirPath: s.ImageDirPath, itemRepo: itemRepo}
+	itemCache := NewCachedItemRepository(stores.items, defaultItemCacheSize, defaultItemCacheTTL)
+	h := &Handlers{
+		imgDirPath:    s.ImageDirPath,
+		itemRepo:      itemCache,
+		itemCache:     itemCache,
+		imageRepo:     stores.images,
+		db:            stores.db,
+		jsonStore:     stores.jsonStore,
+		uploadSecret:  newUploadSecret(),
+		uploads:       newResumableUploads(s.ImageDirPath),
+		placeholders:  placeholders,
+		imageOptions:  imageOptions,
+		similarImages: similarImages,
+		adminToken:    os.Getenv("ADMIN_TOKEN"),
+	}
+	h.checkPlaceholderImages()
+
+	// remove uploaded images that were never used by an item
+	go h.runUploadGC(ctx, uploadGCInterval, uploadGCTTL)
+
+	limiter := s.RateLimitStore
+	if limiter == nil {
+		limiter = NewMemoryRateLimitStore()
+	}
 
 	// set up routes
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /", h.Hello)
-	mux.HandleFunc("POST /items", h.AddItem)
-	mux.HandleFunc("GET /items", h.GetItems)
-	mux.HandleFunc("GET /items/{item_id}", h.GetItem)
-	mux.HandleFunc("GET /images/{filename}", h.GetImage)
-	mux.HandleFunc("GET /search", h.Search)
+	for _, rt := range routes(h) {
+		mux.Handle(rt.pattern, rt.build(spec, validation, limiter))
+		if rt.versioned {
+			mux.Handle(rt.versionedPattern(), rt.build(spec, validation, limiter))
+		}
+	}
 
-	// start the server
-	slog.Info("http server started on", "port", s.Port)
-	err := http.ListenAndServe(":"+s.Port, simpleCORSMiddleware(simpleLoggerMiddleware(mux), frontURL, []string{"GET", "HEAD", "POST", "OPTIONS"}))
+	handler = requestIDMiddleware(simpleLoggerMiddleware(actorMiddleware(recoveryMiddleware(compressionMiddleware(mux)), "api")))
+	handler = simpleCORSMiddleware(handler, frontURL, []string{"GET", "HEAD", "POST", "PATCH", "DELETE", "OPTIONS"})
+	return handler, stores.close, nil
+}
+
+// stores are the repositories of the server.
+type stores struct {
+	items  ItemRepository
+	images ImageRepository
+	// db or jsonStore is checked by the readiness probe.
+	db        *sql.DB
+	jsonStore *JSONItemRepository
+	// close releases the database or the lock of the JSON file.
+	close func() error
+}
+
+// openStores opens the repositories selected by ITEM_STORE and applies pending migrations.
+// It fails if the database cannot be opened, so that a bad path stops the server at startup.
+func (s Server) openStores(ctx context.Context, store itemStore, pragmas sqlitePragmas) (*stores, error) {
+	if store == itemStoreJSON {
+		jsonStore, err := NewJSONItemRepository(cmp.Or(os.Getenv("JSON_STORE_PATH"), DefaultJSONStorePath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open JSON store: %w", err)
+		}
+		return &stores{items: jsonStore, images: jsonStore, jsonStore: jsonStore, close: jsonStore.Close}, nil
+	}
+
+	// STEP 5-1: set up the database connection
+	path := cmp.Or(s.DBPath, DefaultDBPath)
+	db, err := openSQLiteDB(ctx, sqliteDriver, path, pragmas)
 	if err != nil {
-		slog.Error("failed to start server: ", "error", err)
-		return 1
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	slog.Info("successfully connected to database", "path", path)
+	if err := migrate(ctx, db.write); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
+	if n, err := foreignKeyViolations(ctx, db.write); err != nil {
+		slog.Warn("failed to check foreign keys", "error", err)
+	} else if n > 0 {
+		slog.Warn("rows violate foreign keys; they fail to update until fixed", "rows", n)
+	}
+	return &stores{
+		items:  NewItemRepositoryWithPools(db.write, db.read),
+		images: NewImageRepositoryWithPools(db.write, db.read),
+		db:     db.write,
+		close:  db.Close,
+	}, nil
+}
 
-	return 0
+// route is a handler registered on the server mux together with its middleware.
+type route struct {
+	// pattern is a http.ServeMux pattern such as "GET /items/{item_id}".
+	// The same path must be documented in openapi.json.
+	pattern string
+	handler http.HandlerFunc
+	// timeout cancels the request context after the duration if set.
+	timeout time.Duration
+	// rateLimit throttles the route if set.
+	rateLimit *RateLimitPolicy
+	// maxBodySize rejects larger request bodies if set.
+	maxBodySize int64
+	// versioned routes are also served under the /v1 prefix.
+	// The unprefixed paths are kept for existing clients.
+	versioned bool
+}
+
+// routes returns all routes of the API.
+func routes(h *Handlers) []route {
+	return []route{
+		{pattern: "GET /", handler: h.Hello},
+		{pattern: "POST /items", handler: h.AddItem, timeout: addItemRequestTimeout, rateLimit: &addItemRateLimit, maxBodySize: maxAddItemBodySize, versioned: true},
+		{pattern: "POST /items:bulk", handler: h.BulkAddItems, timeout: bulkAddItemsRequestTimeout, rateLimit: &bulkAddItemsRateLimit, maxBodySize: maxBulkBodySize, versioned: true},
+		{pattern: "GET /items", handler: h.GetItems, timeout: defaultRequestTimeout, versioned: true},
+		// no timeout since the export is streamed; the timeout middleware would buffer it
+		{pattern: "GET /items/export", handler: h.ExportItems, rateLimit: &exportItemsRateLimit, versioned: true},
+		{pattern: "GET /items/{item_id}", handler: h.GetItem, timeout: defaultRequestTimeout, versioned: true},
+		{pattern: "PATCH /items/{item_id}", handler: h.UpdateItem, timeout: defaultRequestTimeout, rateLimit: &editItemRateLimit, maxBodySize: maxAddItemBodySize, versioned: true},
+		{pattern: "DELETE /items/{item_id}", handler: h.DeleteItem, timeout: defaultRequestTimeout, rateLimit: &editItemRateLimit, maxBodySize: maxAddItemBodySize, versioned: true},
+		{pattern: "GET /items/{item_id}/similar-images", handler: h.GetSimilarImages, timeout: defaultRequestTimeout, versioned: true},
+		{pattern: "GET /items/{item_id}/history", handler: h.GetItemHistory, timeout: defaultRequestTimeout, versioned: true},
+		{pattern: "POST /images", handler: h.UploadImage, timeout: addItemRequestTimeout, rateLimit: &uploadImageRateLimit, maxBodySize: maxAddItemBodySize, versioned: true},
+		{pattern: "GET /images/{filename}", handler: h.GetImage, versioned: true},
+		{pattern: "POST /uploads", handler: h.CreateUpload, rateLimit: &uploadImageRateLimit, versioned: true},
+		{pattern: "HEAD /uploads/{upload_id}", handler: h.GetUploadOffset, versioned: true},
+		// no timeout since a chunk may take long on a slow network; the handler keeps what it received
+		{pattern: "PATCH /uploads/{upload_id}", handler: h.PatchUpload, versioned: true},
+		{pattern: "GET /search", handler: h.Search, timeout: defaultRequestTimeout, rateLimit: &searchRateLimit, versioned: true},
+		{pattern: "GET /healthz", handler: h.Healthz},
+		{pattern: "GET /readyz", handler: h.Readyz},
+		{pattern: "GET /version", handler: h.Version},
+		{pattern: "GET /openapi.json", handler: h.OpenAPI},
+		{pattern: "GET /docs", handler: h.Docs},
+		{pattern: "GET /debug/cache", handler: h.CacheStats},
+		{pattern: "GET /admin/images", handler: h.ImageReport, timeout: defaultRequestTimeout},
+		// no timeout since the archive is streamed
+		{pattern: "GET /admin/backup", handler: h.BackupDatabase, rateLimit: &backupRateLimit},
+		{pattern: "POST /admin/items/{item_id}/restore", handler: h.RestoreItem, timeout: defaultRequestTimeout},
+	}
+}
+
+// build wraps the handler with the middleware of the route.
+// The rate limit is checked first so that throttled requests are not parsed,
+// then the size of the body so that the validation does not read more than the handler would,
+// and the request is validated against the OpenAPI document before the handler runs.
+// Responses are checked last, including those of the rate limit and the validation, if validation is not off.
+func (rt route) build(spec *openAPISpec, validation responseValidation, limiter RateLimitStore) http.Handler {
+	var h http.Handler = rt.handler
+	if rt.timeout > 0 {
+		h = timeoutMiddleware(h, rt.timeout)
+	}
+	h = openAPIValidationMiddleware(h, spec, rt.pattern)
+	if rt.maxBodySize > 0 {
+		h = bodyLimitMiddleware(h, rt.maxBodySize)
+	}
+	if rt.rateLimit != nil {
+		h = rateLimitMiddleware(h, limiter, *rt.rateLimit)
+	}
+	return openAPIResponseValidationMiddleware(h, spec, rt.pattern, validation)
+}
+
+// apiVersionPrefix is the path prefix of the current API version.
+const apiVersionPrefix = "/v1"
+
+// versionedPattern returns the pattern of the route under apiVersionPrefix,
+// e.g. "GET /items" becomes "GET /v1/items".
+func (rt route) versionedPattern() string {
+	method, path, _ := strings.Cut(rt.pattern, " ")
+	return method + " " + apiVersionPrefix + path
 }
 
 type Handlers struct {
 	// imgDirPath is the path to the directory storing images.
 	imgDirPath string
 	itemRepo   ItemRepository
+	// db is used by the readiness probe. It is nil if items are stored in jsonStore.
+	db *sql.DB
+	// jsonStore is used by the readiness probe instead of db if items are stored in a JSON file.
+	jsonStore *JSONItemRepository
+	// uploadSecret is the key to sign upload tokens.
+	uploadSecret []byte
+	// uploads stores incomplete resumable uploads.
+	uploads *resumableUploads
+	// itemCache is itemRepo if it is cached, for reporting the statistics.
+	itemCache *CachedItemRepository
+	// imageRepo records the metadata of stored images if set.
+	imageRepo ImageRepository
+	// placeholders are the images of items without an image per category.
+	// Categories not in it use defaultImageName.
+	placeholders map[string]string
+	// imageOptions configures the re-encoding of uploaded images.
+	imageOptions imageSanitizeOptions
+	// similarImages is the policy for new items with an image like the image of another item.
+	similarImages similarImagePolicy
+	// adminToken is the bearer token of the admin routes. They are disabled if it is empty.
+	adminToken string
 }
 
 type HelloResponse struct {
@@ -79,6 +345,7 @@ type HelloResponse struct {
 // Hello is a handler to return a Hello, world! message for GET / .
 func (s *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
 	resp := HelloResponse{Message: "Hello, world!"}
+	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(resp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -91,35 +358,82 @@ type AddItemRequest struct {
 	// Category string form:"category" // STEP 4-2: add a category field
 	Category  string `form:"category"`
 	ImageName []byte `form:"image_name"` // STEP 4-4: add an image field
+	// ImageRef is the name of an image uploaded by POST /images, e.g. "<sha256>.jpg".
+	ImageRef string
+	// UploadToken is the token returned by POST /images for ImageRef.
+	UploadToken string
+}
+
+// addItemJSONRequest is the body of POST /items sent as application/json.
+type addItemJSONRequest struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// Image is the base64 encoded image.
+	Image string `json:"image"`
+	// ImageName refers to an image uploaded by POST /images, e.g. "<sha256>.jpg".
+	ImageName   string `json:"image_name"`
+	UploadToken string `json:"upload_token"`
 }
 
 type AddItemResponse struct {
 	Message string `json:"message"`
+	// SimilarItemIDs are the items whose image looks like the image of the new item,
+	// if they are flagged by the similar image policy.
+	SimilarItemIDs []int `json:"similar_item_ids,omitempty"`
 }
 
+// maxAddItemBodySize limits the size of the body of POST /items, whether a form or JSON, of POST /images
+// and of PATCH and DELETE /items/{item_id}.
+const maxAddItemBodySize = 32 << 20
+
+// imageRefPattern matches the content-addressed file names produced by storeImage.
+var imageRefPattern = regexp.MustCompile(`^[0-9a-f]{64}\.jpg$`)
+
 // parseAddItemRequest parses and validates the request to add an item.
+// The body may be a form (multipart or URL-encoded) or JSON.
+// Invalid fields are reported together as a *ValidationError.
 func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
-	req := &AddItemRequest{
-		Name: r.FormValue("name"),
-		// STEP 4-2: add a category field
-		Category: r.FormValue("category"),
+	var req *AddItemRequest
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		req, err = parseAddItemJSON(r)
+	} else {
+		req, err = parseAddItemForm(r)
+	}
+	if err != nil {
+		return nil, err
 	}
-
-	// STEP 4-4: add an image field
 
 	// validate the request
-	if req.Name == "" {
-		return nil, errors.New("name is required")
+	if err := validateAddItemRequest(req); err != nil {
+		return nil, err
 	}
+	return req, nil
+}
 
-	// STEP 4-2: validate the category field
-	if req.Category == "" {
-		return nil, errors.New("category is required")
+// parseAddItemForm parses a multipart or URL-encoded form.
+func parseAddItemForm(r *http.Request) (*AddItemRequest, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxAddItemBodySize)
+	if err := r.ParseMultipartForm(maxAddItemBodySize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, fmt.Errorf("failed to parse form: %w", err)
 	}
-	// STEP 4-4: validate the image field
+	req := &AddItemRequest{
+		Name: r.FormValue("name"),
+		// STEP 4-2: add a category field
+		Category:    r.FormValue("category"),
+		ImageRef:    r.FormValue("image_name"),
+		UploadToken: r.FormValue("upload_token"),
+	}
+
+	// STEP 4-4: add an image field
 	file, _, err := r.FormFile("image")
 	if err != nil {
-		return req, nil
+		if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+			return req, nil
+		}
+		return nil, err
 	}
 	defer file.Close()
 
@@ -133,22 +447,149 @@ func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
 	return req, nil
 }
 
+// parseAddItemJSON parses a JSON body. Unknown fields are rejected.
+func parseAddItemJSON(r *http.Request) (*AddItemRequest, error) {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxAddItemBodySize))
+	dec.DisallowUnknownFields()
+
+	var body addItemJSONRequest
+	if err := dec.Decode(&body); err != nil {
+		return nil, jsonDecodeError(err)
+	}
+	if dec.More() {
+		return nil, errors.New("request body must contain a single JSON object")
+	}
+
+	req := &AddItemRequest{
+		Name:        body.Name,
+		Category:    body.Category,
+		ImageRef:    body.ImageName,
+		UploadToken: body.UploadToken,
+	}
+	if body.Image != "" {
+		image, err := base64.StdEncoding.DecodeString(body.Image)
+		if err != nil {
+			verr := &ValidationError{}
+			verr.add("image", "must be base64 encoded")
+			return nil, verr
+		}
+		req.ImageName = image
+	}
+	return req, nil
+}
+
+// jsonDecodeError converts errors of json.Decoder into field errors where possible.
+func jsonDecodeError(err error) error {
+	verr := &ValidationError{}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		verr.add(typeErr.Field, "must be a %s", typeErr.Type.Kind())
+		return verr
+	}
+	// json.Decoder reports unknown fields only as a formatted message
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		verr.add(strings.Trim(field, `"`), "is not allowed")
+		return verr
+	}
+	return fmt.Errorf("invalid JSON body: %w", err)
+}
+
+// validateAddItemRequest checks the fields of the request to add an item.
+func validateAddItemRequest(req *AddItemRequest) error {
+	verr := &ValidationError{}
+
+	if req.Name == "" {
+		verr.add("name", "is required")
+	}
+	// STEP 4-2: validate the category field
+	if req.Category == "" {
+		verr.add("category", "is required")
+	}
+	// STEP 4-4: validate the image field
+	if req.ImageRef != "" {
+		if len(req.ImageName) > 0 {
+			verr.add("image_name", "must not be set together with image")
+		} else if !imageRefPattern.MatchString(req.ImageRef) {
+			verr.add("image_name", "must be the name of an uploaded image")
+		}
+		if req.UploadToken == "" {
+			verr.add("upload_token", "is required with image_name")
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
 // AddItem is a handler to add a new item for POST /items .
 func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	req, err := parseAddItemRequest(r)
 	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			writeValidationError(w, r, verr)
+			return
+		}
+		if isBodyTooLarge(err) {
+			writeJSONError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must be at most %d bytes", maxAddItemBodySize))
+			return
+		}
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// STEP 4-4: uncomment on adding an implementation to store an image
-	fileName, err := s.storeImage(req.ImageName)
+	var fileName string
+	if req.ImageRef != "" {
+		// the image was uploaded by POST /images
+		fileName = req.ImageRef
+		if err := verifyUploadToken(s.uploadSecret, fileName, req.UploadToken, time.Now()); err != nil {
+			verr := &ValidationError{}
+			verr.add("upload_token", "is invalid or expired")
+			writeValidationError(w, r, verr)
+			return
+		}
+		if err := s.checkImageExists(fileName); err != nil {
+			if errors.Is(err, errImageNotFound) {
+				verr := &ValidationError{}
+				verr.add("image_name", "refers to an unknown image")
+				writeValidationError(w, r, verr)
+				return
+			}
+			slog.Error("failed to check image", "error", err)
+			http.Error(w, "failed to check image", http.StatusInternalServerError)
+			return
+		}
+	} else if len(req.ImageName) > 0 {
+		// STEP 4-4: uncomment on adding an implementation to store an image
+		fileName, err = s.storeImage(ctx, req.ImageName)
+	} else {
+		// refer to the placeholder explicitly rather than storing an empty image
+		fileName = s.placeholderImage(req.Category)
+	}
+
+	if err != nil {
+		writeStoreImageError(w, r, "image", err)
+		return
+	}
 
+	imageField := "image"
+	if req.ImageRef != "" {
+		imageField = "image_name"
+	}
+	similar, err := s.checkSimilarImages(ctx, imageField, fileName)
 	if err != nil {
-		slog.Error("failed to store image", "error", err)
-		http.Error(w, "failed to store image", http.StatusInternalServerError)
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			writeValidationError(w, r, verr)
+			return
+		}
+		slog.Error("failed to check similar images", "error", err)
+		http.Error(w, "failed to check similar images", http.StatusInternalServerError)
 		return
 	}
 
@@ -170,7 +611,11 @@ func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := AddItemResponse{Message: message}
+	if len(similar) > 0 {
+		slog.Warn("item flagged for a similar image", "item_id", item.ID, "image_name", item.Image, "similar_item_ids", similar)
+	}
+
+	resp := AddItemResponse{Message: message, SimilarItemIDs: similar}
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
@@ -184,19 +629,29 @@ func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 func (s *Handlers) GetItems(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	// read before the items, so that a change in between is not covered by Last-Modified without being in the list
+	modified, err := s.itemRepo.LastModified(ctx)
+	if err != nil {
+		http.Error(w, "failed to retrieve items", http.StatusInternalServerError)
+		return
+	}
 	items, err := s.itemRepo.GetAll(ctx)
 	if err != nil {
 		http.Error(w, "failed to retrieve items", http.StatusInternalServerError)
 		return
 	}
+	if items == nil {
+		// return an empty list instead of null
+		items = []*Item{}
+	}
 
 	resp := map[string][]*Item{"items": items}
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(resp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	etag, lastModified := itemsETag(items)
+	// deleted items are not in the list, but deleting one modifies it
+	if modified.After(lastModified) {
+		lastModified = modified
 	}
+	writeCacheableJSON(w, r, resp, etag, lastModified)
 }
 
 // step4-5
@@ -205,31 +660,43 @@ func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
 
 	//itemIdをセットする
 	itemIDstr := r.PathValue("item_id")
-	itemID, err := strconv.Atoi(itemIDstr)
 	if itemIDstr == "" {
 		http.Error(w, "item_id is required", http.StatusBadRequest)
 		return
 	}
+	itemID, err := strconv.Atoi(itemIDstr)
+	if err != nil {
+		http.Error(w, "item_id must be an integer", http.StatusBadRequest)
+		return
+	}
 	//全商品を取得
 	item, err := s.itemRepo.GetByID(ctx, itemID)
-	if err != nil {
-		http.Error(w, "failed to retrieve items", http.StatusInternalServerError)
+	if errors.Is(err, sql.ErrNoRows) {
+		// including deleted items
+		http.Error(w, "item not found", http.StatusNotFound)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(item)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "failed to retrieve items", http.StatusInternalServerError)
 		return
 	}
+
+	writeCacheableJSON(w, r, item, itemETag(item), item.UpdatedAt)
 }
 
 // storeImage stores an image and returns the file path and an error if any.
 // this method calculates the hash sum of the image as a file name to avoid the duplication of a same file
 // and stores it in the image directory.
-func (s *Handlers) storeImage(image []byte) (filePath string, err error) {
+// The image is re-encoded by sanitizeImage before hashing, and errInvalidImage is returned if it is not an image.
+// The metadata of the image is recorded in imageRepo whether or not the file already existed.
+func (s *Handlers) storeImage(ctx context.Context, image []byte) (filePath string, err error) {
 	// STEP 4-4: add an implementation to store an image
+	// strip the metadata such as the location before anything is written
+	image, err = sanitizeImage(image, s.imageOptions)
+	if err != nil {
+		return "", err
+	}
+
 	// 画像のハッシュ値を計算
 	hash := sha256.Sum256(image)
 	hashHex := hex.EncodeToString(hash[:])
@@ -240,16 +707,17 @@ func (s *Handlers) storeImage(image []byte) (filePath string, err error) {
 
 	filePath, err = s.buildImagePath(fileName)
 	slog.Info("Saving image to", "path", filePath)
-	if err != nil {
-		return "", err
-	}
-
-	if _, err := os.Stat(filePath); err == nil {
+	if err == nil {
 		//すでに同じ画像ファイルがある場合は、そのファイル名をそのまま返す（保存はしない）
-		return fileName, nil
-	} else if !errors.Is(err, os.ErrNotExist) {
-		// ファイル存在確認で予期せぬエラーが発生した場合はエラーを返す
-		return "", fmt.Errorf("failed to check file existence: %w", err)
+		// refresh the modification time so that collectUploads does not remove the image
+		// before an item refers to it
+		now := time.Now()
+		if err := os.Chtimes(filePath, now, now); err != nil {
+			return "", fmt.Errorf("failed to touch image: %w", err)
+		}
+		return fileName, s.saveImageMetadata(ctx, fileName, image)
+	} else if !errors.Is(err, errImageNotFound) {
+		return "", err
 	}
 
 	// 画像を保存
@@ -259,9 +727,41 @@ func (s *Handlers) storeImage(image []byte) (filePath string, err error) {
 	}
 
 	// 保存したファイル名を返す
-	return fileName, nil
+	return fileName, s.saveImageMetadata(ctx, fileName, image)
+}
+
+// writeStoreImageError writes the error returned by storeImage.
+// Invalid images are reported as a validation error of the field.
+func writeStoreImageError(w http.ResponseWriter, r *http.Request, field string, err error) {
+	if errors.Is(err, errInvalidImage) {
+		verr := &ValidationError{}
+		verr.add(field, invalidImageMessage)
+		writeValidationError(w, r, verr)
+		return
+	}
+	slog.Error("failed to store image", "error", err)
+	http.Error(w, "failed to store image", http.StatusInternalServerError)
+}
+
+func (s *Handlers) saveImageMetadata(ctx context.Context, fileName string, image []byte) error {
+	if s.imageRepo == nil {
+		return nil
+	}
+	if err := s.imageRepo.SaveImage(ctx, imageMetadata(fileName, image)); err != nil {
+		return fmt.Errorf("failed to save image metadata: %w", err)
+	}
+	return nil
 }
 
+// checkImageExists returns errImageNotFound if the image is not in the image directory.
+func (s *Handlers) checkImageExists(fileName string) error {
+	_, err := s.buildImagePath(fileName)
+	return err
+}
+
+// defaultImageName is the image returned when the requested image is not found.
+const defaultImageName = "default.jpg"
+
 type GetImageRequest struct {
 	FileName string // path value
 }
@@ -281,7 +781,8 @@ func parseGetImageRequest(r *http.Request) (*GetImageRequest, error) {
 }
 
 // GetImage is a handler to return an image for GET /images/{filename} .
-// If the specified image is not found, it returns the default image.
+// If the specified image is not found, it returns the placeholder of the category
+// given in the query, or the default image, and tells it in X-Image-Fallback.
 func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
 	req, err := parseGetImageRequest(r)
 	if err != nil {
@@ -291,16 +792,26 @@ func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	imgPath, err := s.buildImagePath(req.FileName)
-	if err != nil {
-		if !errors.Is(err, errImageNotFound) {
-			slog.Warn("failed to build image path: ", "error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+	switch {
+	case err == nil:
+		if hash, ok := strings.CutSuffix(req.FileName, ".jpg"); ok && imageRefPattern.MatchString(req.FileName) {
+			// the name is the hash of the content, so it is a strong validator that never changes.
+			// ServeFile drops these headers if it fails to serve the file.
+			w.Header().Set("Cache-Control", immutableCacheControl)
+			w.Header().Set("ETag", `"`+hash+`"`)
 		}
-
-		// when the image is not found, it returns the default image without an error.
-		slog.Debug("image not found", "filename", imgPath)
-		imgPath = filepath.Join(s.imgDirPath, "default.jpg")
+	case errors.Is(err, errImageNotFound):
+		// when the image is not found, it returns the placeholder without an error.
+		fallback := s.placeholderImage(r.URL.Query().Get("category"))
+		slog.Debug("image not found", "filename", imgPath, "fallback", fallback)
+		imgPath = filepath.Join(s.imgDirPath, fallback)
+		w.Header().Set("X-Image-Fallback", fallback)
+		// the image may be stored later
+		w.Header().Set("Cache-Control", "no-cache")
+	default:
+		slog.Warn("failed to build image path: ", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	slog.Info("returned image", "path", imgPath)
@@ -308,6 +819,7 @@ func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
 }
 
 // buildImagePath builds the image path and validates it.
+// It returns errImageNotFound with the path if the image does not exist.
 func (s *Handlers) buildImagePath(imageFileName string) (string, error) {
 	imgPath := filepath.Join(s.imgDirPath, filepath.Clean(imageFileName))
 
@@ -323,10 +835,17 @@ func (s *Handlers) buildImagePath(imageFileName string) (string, error) {
 	}
 
 	// check if the image exists
-	// _, err = os.Stat(imgPath)
-	// if err != nil {
-	// 	return imgPath, errImageNotFound
-	// }
+	// the path is returned with errImageNotFound, since storeImage uses it to store a new image
+	info, err := os.Stat(imgPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return imgPath, errImageNotFound
+		}
+		return "", fmt.Errorf("failed to check image: %w", err)
+	}
+	if info.IsDir() {
+		return imgPath, errImageNotFound
+	}
 
 	return imgPath, nil
 }
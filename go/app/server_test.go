@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -8,6 +9,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -84,6 +86,109 @@ func TestParseAddItemRequest(t *testing.T) {
 	}
 }
 
+func TestParseAddItemJSONRequest(t *testing.T) {
+	t.Parallel()
+
+	imageRef := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.jpg"
+
+	type wants struct {
+		req    *AddItemRequest
+		fields []FieldError
+		err    bool
+	}
+	cases := map[string]struct {
+		body string
+		wants
+	}{
+		"ok: base64 image": {
+			body: `{"name": "jacket", "category": "fashion", "image": "aW1hZ2U="}`,
+			wants: wants{
+				req: &AddItemRequest{Name: "jacket", Category: "fashion", ImageName: []byte("image")},
+			},
+		},
+		"ok: uploaded image reference": {
+			body: `{"name": "jacket", "category": "fashion", "image_name": "` + imageRef + `", "upload_token": "token"}`,
+			wants: wants{
+				req: &AddItemRequest{Name: "jacket", Category: "fashion", ImageRef: imageRef, UploadToken: "token"},
+			},
+		},
+		"ng: image reference without token": {
+			body: `{"name": "jacket", "category": "fashion", "image_name": "` + imageRef + `"}`,
+			wants: wants{
+				fields: []FieldError{{Field: "upload_token", Message: "is required with image_name"}},
+			},
+		},
+		"ng: missing fields are listed together": {
+			body: `{}`,
+			wants: wants{
+				fields: []FieldError{
+					{Field: "name", Message: "is required"},
+					{Field: "category", Message: "is required"},
+				},
+			},
+		},
+		"ng: unknown field": {
+			body: `{"name": "jacket", "category": "fashion", "price": 100}`,
+			wants: wants{
+				fields: []FieldError{{Field: "price", Message: "is not allowed"}},
+			},
+		},
+		"ng: wrong type": {
+			body: `{"name": 1, "category": "fashion"}`,
+			wants: wants{
+				fields: []FieldError{{Field: "name", Message: "must be a string"}},
+			},
+		},
+		"ng: invalid base64": {
+			body: `{"name": "jacket", "category": "fashion", "image": "%%%"}`,
+			wants: wants{
+				fields: []FieldError{{Field: "image", Message: "must be base64 encoded"}},
+			},
+		},
+		"ng: malformed image reference": {
+			body: `{"name": "jacket", "category": "fashion", "image_name": "../server.log", "upload_token": "token"}`,
+			wants: wants{
+				fields: []FieldError{{Field: "image_name", Message: "must be the name of an uploaded image"}},
+			},
+		},
+		"ng: malformed JSON": {
+			body: `{"name": "jacket",`,
+			wants: wants{
+				err: true,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("POST", "/v1/items", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			got, err := parseAddItemRequest(req)
+			if err != nil {
+				var verr *ValidationError
+				switch {
+				case errors.As(err, &verr):
+					if diff := cmp.Diff(tt.wants.fields, verr.Fields); diff != "" {
+						t.Errorf("unexpected field errors (-want +got):\n%s", diff)
+					}
+				case !tt.wants.err:
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.wants.fields != nil || tt.wants.err {
+				t.Fatalf("expected an error, got %+v", got)
+			}
+			if diff := cmp.Diff(tt.wants.req, got); diff != "" {
+				t.Errorf("unexpected request (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestHelloHandler(t *testing.T) {
 	t.Parallel()
 
@@ -153,7 +258,7 @@ func TestAddItem(t *testing.T) {
 				item := &Item{
 					Name:     "used iPhone 16e",
 					Category: "phone",
-					Image:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.jpg",
+					Image:    "default.jpg",
 				}
 				m.EXPECT().Insert(gomock.Any(), item).Return(errors.New("failed to insert"))
 			},
@@ -161,6 +266,20 @@ func TestAddItem(t *testing.T) {
 				code: http.StatusInternalServerError,
 			},
 		},
+		"ok: item without an image refers to the placeholder of the category": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+			},
+			injector: func(m *MockItemRepository) {
+				item := &Item{Name: "jacket", Category: "fashion", Image: "fashion.jpg"}
+				m.EXPECT().Insert(gomock.Any(), item).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+				body: map[string]string{"message": "item received: jacket"},
+			},
+		},
 	}
 
 	for name, tt := range cases {
@@ -172,7 +291,7 @@ func TestAddItem(t *testing.T) {
 
 			mockIR := NewMockItemRepository(ctrl)
 			tt.injector(mockIR)
-			h := &Handlers{itemRepo: mockIR}
+			h := &Handlers{itemRepo: mockIR, placeholders: map[string]string{"fashion": "fashion.jpg"}}
 
 			values := url.Values{}
 			for k, v := range tt.args {
@@ -202,6 +321,71 @@ func TestAddItem(t *testing.T) {
 	}
 }
 
+func TestGetImage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"jacket.jpg", "default.jpg", "fashion.jpg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+	h := &Handlers{imgDirPath: dir, placeholders: map[string]string{"fashion": "fashion.jpg"}}
+
+	type wants struct {
+		code     int
+		body     string
+		fallback string
+	}
+	cases := map[string]struct {
+		target string
+		wants
+	}{
+		"ok: image exists": {
+			target: "/images/jacket.jpg",
+			wants:  wants{code: http.StatusOK, body: "jacket.jpg"},
+		},
+		"ok: missing image falls back to the default image": {
+			target: "/images/missing.jpg",
+			wants:  wants{code: http.StatusOK, body: "default.jpg", fallback: "default.jpg"},
+		},
+		"ok: missing image falls back to the placeholder of the category": {
+			target: "/images/missing.jpg?category=fashion",
+			wants:  wants{code: http.StatusOK, body: "fashion.jpg", fallback: "fashion.jpg"},
+		},
+		"ng: not a jpg": {
+			target: "/images/jacket.png",
+			wants:  wants{code: http.StatusBadRequest},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /images/{filename}", h.GetImage)
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+			if got := res.Body.String(); got != tt.wants.body {
+				t.Errorf("unexpected image. want=%s, got=%s", tt.wants.body, got)
+			}
+			if got := res.Header().Get("X-Image-Fallback"); got != tt.wants.fallback {
+				t.Errorf("unexpected X-Image-Fallback. want=%q, got=%q", tt.wants.fallback, got)
+			}
+		})
+	}
+}
+
 // STEP 6-4: uncomment this test
 func TestAddItemE2e(t *testing.T) {
 	// 環境によっては E2E テストをスキップできるようにする
@@ -287,6 +471,13 @@ func TestAddItemE2e(t *testing.T) {
 func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
 	t.Helper()
 
+	return setupDBWithDriver(t, sqliteDriver)
+}
+
+// setupDBWithDriver is setupDB with the given SQLite driver, to check that the drivers behave the same.
+func setupDBWithDriver(t *testing.T, driver string) (db *sql.DB, closers []func(), e error) {
+	t.Helper()
+
 	defer func() {
 		if e != nil {
 			for _, c := range closers {
@@ -296,7 +487,7 @@ func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
 	}()
 
 	// create a temporary file for e2e testing
-	f, err := os.CreateTemp(".", "*.sqlite3")
+	f, err := os.CreateTemp(t.TempDir(), "*.sqlite3")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -306,7 +497,7 @@ func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
 	})
 
 	// set up tables
-	db, err = sql.Open("sqlite3", f.Name())
+	db, err = sql.Open(driver, sqliteDSN(driver, f.Name(), defaultSQLitePragmas, false))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -314,27 +505,70 @@ func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
 		db.Close()
 	})
 
-	// TODO: replace it with real SQL statements.
-	cmd := `
-		CREATE TABLE IF NOT EXISTS "items" (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			category_id INTEGER,
-			image_name TEXT,
-			FOREIGN KEY (category_id) REFERENCES categories(id)
-		);
+	// set up tables with the same migrations as the server
+	if err := migrate(context.Background(), db); err != nil {
+		return nil, nil, err
+	}
 
-		CREATE TABLE categories (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL
-		);
+	return db, closers, nil
+}
 
-		`
+// TestServerHandler runs two servers with their own databases in the same process.
+func TestServerHandler(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+	t.Parallel()
 
-	_, err = db.Exec(cmd)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	newServer := func() *httptest.Server {
+		dir := t.TempDir()
+		handler, closeStores, err := Server{ImageDirPath: dir, DBPath: filepath.Join(dir, "mercari.sqlite3")}.Handler(ctx)
+		if err != nil {
+			t.Fatalf("failed to set up server: %v", err)
+		}
+		t.Cleanup(func() { closeStores() })
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	first, second := newServer(), newServer()
+
+	res, err := http.PostForm(first.URL+"/items", url.Values{"name": {"jacket"}, "category": {"fashion"}})
 	if err != nil {
-		return nil, nil, err
+		t.Fatalf("failed to add item: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code. want=%d, got=%d", http.StatusOK, res.StatusCode)
 	}
 
-	return db, closers, nil
+	for srv, want := range map[*httptest.Server]int{first: 1, second: 0} {
+		res, err := http.Get(srv.URL + "/items")
+		if err != nil {
+			t.Fatalf("failed to get items: %v", err)
+		}
+		var body struct {
+			Items []*Item `json:"items"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to decode items: %v", err)
+		}
+		if len(body.Items) != want {
+			t.Errorf("unexpected number of items of %s. want=%d, got=%d", srv.URL, want, len(body.Items))
+		}
+	}
+}
+
+func TestServerHandlerBadDatabase(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	_, _, err := Server{ImageDirPath: dir, DBPath: filepath.Join(dir, "missing", "mercari.sqlite3")}.Handler(context.Background())
+	if err == nil {
+		t.Errorf("expected an error for a database in a missing directory")
+	}
 }
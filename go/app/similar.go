@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"math/bits"
+	"net/http"
+	"strconv"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// defaultSimilarImageDistance is the maximum distance of images regarded as the same photo,
+// e.g. resized or recompressed copies.
+const defaultSimilarImageDistance = 10
+
+// similarImagePolicy is what happens when a new item has an image that looks like the image of another item,
+// as set in SIMILAR_IMAGE_POLICY.
+// Items have no seller, so every other item is compared.
+type similarImagePolicy string
+
+const (
+	// similarImagePolicyOff does not compare the images. It is the default.
+	similarImagePolicyOff similarImagePolicy = "off"
+	// similarImagePolicyFlag adds the item and returns the similar items for review.
+	similarImagePolicyFlag similarImagePolicy = "flag"
+	// similarImagePolicyReject rejects the item as a validation error of the image.
+	similarImagePolicyReject similarImagePolicy = "reject"
+)
+
+func parseSimilarImagePolicy(s string) (similarImagePolicy, error) {
+	switch p := similarImagePolicy(s); p {
+	case "":
+		return similarImagePolicyOff, nil
+	case similarImagePolicyOff, similarImagePolicyFlag, similarImagePolicyReject:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid similar image policy %q: want off, flag or reject", s)
+}
+
+// perceptualHashes returns the aHash and dHash of img.
+// aHash compares each pixel of an 8x8 grayscale thumbnail with the mean,
+// and dHash compares the horizontally adjacent pixels of a 9x8 one.
+// Both are zero for an image of a single color.
+func perceptualHashes(img image.Image) (ahash, dhash uint64) {
+	small := grayThumbnail(img, 8, 8)
+	var sum int
+	for _, p := range small.Pix {
+		sum += int(p)
+	}
+	mean := sum / len(small.Pix)
+	for i, p := range small.Pix {
+		if int(p) > mean {
+			ahash |= 1 << i
+		}
+	}
+
+	wide := grayThumbnail(img, 9, 8)
+	for y := range 8 {
+		for x := range 8 {
+			if wide.GrayAt(x, y).Y > wide.GrayAt(x+1, y).Y {
+				dhash |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return ahash, dhash
+}
+
+func grayThumbnail(img image.Image, w, h int) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	xdraw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+// hashDistance returns the number of bits different between two perceptual hashes.
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// checkSimilarImages applies the similar image policy to the image of a new item.
+// It returns the IDs of the items with a similar image if they are flagged,
+// or a *ValidationError of the field if the item is rejected.
+func (s *Handlers) checkSimilarImages(ctx context.Context, field, imageName string) ([]int, error) {
+	if s.similarImages == "" || s.similarImages == similarImagePolicyOff || !imageRefPattern.MatchString(imageName) {
+		return nil, nil
+	}
+
+	similar, err := s.itemRepo.SimilarItems(ctx, imageName, defaultSimilarImageDistance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find similar images: %w", err)
+	}
+	if len(similar) == 0 {
+		return nil, nil
+	}
+	ids := make([]int, 0, len(similar))
+	for _, item := range similar {
+		ids = append(ids, item.ID)
+	}
+
+	if s.similarImages == similarImagePolicyReject {
+		verr := &ValidationError{}
+		verr.add(field, "looks like the image of item %d", ids[0])
+		return nil, verr
+	}
+	return ids, nil
+}
+
+type GetSimilarImagesResponse struct {
+	Items []*SimilarItem `json:"items"`
+}
+
+// GetSimilarImages is a handler to return the other items whose image looks like the image of an item
+// for GET /items/{item_id}/similar-images .
+func (s *Handlers) GetSimilarImages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	itemID, err := strconv.Atoi(r.PathValue("item_id"))
+	if err != nil {
+		http.Error(w, "item_id must be an integer", http.StatusBadRequest)
+		return
+	}
+	maxDistance := defaultSimilarImageDistance
+	if v := r.URL.Query().Get("max_distance"); v != "" {
+		maxDistance, err = strconv.Atoi(v)
+		if err != nil || maxDistance < 0 || maxDistance > 64 {
+			verr := &ValidationError{}
+			verr.add("max_distance", "must be an integer from 0 to 64")
+			writeValidationError(w, r, verr)
+			return
+		}
+	}
+
+	item, err := s.itemRepo.GetByID(ctx, itemID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to retrieve items", http.StatusInternalServerError)
+		return
+	}
+
+	similar, err := s.itemRepo.SimilarItems(ctx, item.Image, maxDistance)
+	if err != nil {
+		slog.Error("failed to find similar images", "error", err)
+		http.Error(w, "failed to find similar images", http.StatusInternalServerError)
+		return
+	}
+	resp := GetSimilarImagesResponse{Items: []*SimilarItem{}}
+	for _, other := range similar {
+		if other.ID != item.ID {
+			resp.Items = append(resp.Items, other)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,332 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+// testPhoto draws pattern, given coordinates from 0 to 1, as a w x h image,
+// so that the same pattern at different sizes is the same photo.
+func testPhoto(w, h int, pattern func(x, y float64) uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetGray(x, y, color.Gray{Y: pattern(float64(x)/float64(w), float64(y)/float64(h))})
+		}
+	}
+	return img
+}
+
+// checker and fade are patterns whose hashes have neither all bits zero nor all bits one.
+func checker(x, y float64) uint8 {
+	if (int(x*4)+int(y*4))%2 == 0 {
+		return 220
+	}
+	return 30
+}
+
+func fade(x, y float64) uint8 {
+	return uint8(255 * (1 - x) * (1 - y/2))
+}
+
+func encodeJPEG(t *testing.T, img image.Image, quality int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPerceptualHashes(t *testing.T) {
+	t.Parallel()
+
+	decode := func(data []byte) image.Image {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to decode image: %v", err)
+		}
+		return img
+	}
+	original := testPhoto(256, 192, checker)
+
+	cases := map[string]struct {
+		img     image.Image
+		similar bool
+	}{
+		"ok: same photo": {
+			img:     original,
+			similar: true,
+		},
+		"ok: resized and recompressed": {
+			img:     decode(encodeJPEG(t, testPhoto(100, 75, checker), 40)),
+			similar: true,
+		},
+		"ok: other photo": {
+			img:     testPhoto(256, 192, fade),
+			similar: false,
+		},
+	}
+
+	oa, od := perceptualHashes(original)
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			a, d := perceptualHashes(tt.img)
+			distance := max(hashDistance(oa, a), hashDistance(od, d))
+			if similar := distance <= defaultSimilarImageDistance; similar != tt.similar {
+				t.Errorf("unexpected similarity at distance %d. want=%v, got=%v", distance, tt.similar, similar)
+			}
+		})
+	}
+}
+
+func TestSimilarItems(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := context.Background()
+	imageRepo := NewImageRepositoryWithDB(db)
+	itemRepo := NewItemRepositoryWithDB(db)
+	images := map[string][]byte{
+		"original": encodeJPEG(t, testPhoto(256, 192, checker), 90),
+		"copy":     encodeJPEG(t, testPhoto(100, 75, checker), 40),
+		"other":    encodeJPEG(t, testPhoto(256, 192, fade), 90),
+	}
+	names := map[string]string{}
+	for i, key := range []string{"original", "copy", "other"} {
+		names[key] = strings.Repeat(string(rune('a'+i)), 64) + ".jpg"
+		if err := imageRepo.SaveImage(ctx, imageMetadata(names[key], images[key])); err != nil {
+			t.Fatalf("failed to save image: %v", err)
+		}
+	}
+	// a content-addressed image stored before the hashes were recorded
+	unhashed := strings.Repeat("d", 64) + ".jpg"
+	for _, image := range []string{names["original"], names["copy"], names["other"], unhashed} {
+		if err := itemRepo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", Image: image}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		image   string
+		wantIDs []int
+	}{
+		"ok: copies": {
+			image:   names["original"],
+			wantIDs: []int{1, 2},
+		},
+		"ok: no copies": {
+			image:   names["other"],
+			wantIDs: []int{3},
+		},
+		"ok: no hashes": {
+			image:   unhashed,
+			wantIDs: []int{},
+		},
+		"ok: unknown image": {
+			image:   strings.Repeat("e", 64) + ".jpg",
+			wantIDs: []int{},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			similar, err := itemRepo.SimilarItems(ctx, tt.image, defaultSimilarImageDistance)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ids := []int{}
+			for _, item := range similar {
+				ids = append(ids, item.ID)
+			}
+			if diff := cmp.Diff(tt.wantIDs, ids); diff != "" {
+				t.Errorf("unexpected similar items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetSimilarImages(t *testing.T) {
+	t.Parallel()
+
+	image := strings.Repeat("a", 64) + ".jpg"
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion", Image: image}
+	copied := &Item{ID: 2, Name: "jacket", Category: "fashion", Image: strings.Repeat("b", 64) + ".jpg"}
+
+	cases := map[string]struct {
+		query    string
+		injector func(m *MockItemRepository)
+		wantCode int
+		wantIDs  []int
+	}{
+		"ok: the item itself is excluded": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil)
+				m.EXPECT().SimilarItems(gomock.Any(), image, defaultSimilarImageDistance).
+					Return([]*SimilarItem{{Item: item}, {Item: copied, Distance: 3}}, nil)
+			},
+			wantCode: http.StatusOK,
+			wantIDs:  []int{2},
+		},
+		"ok: max distance": {
+			query: "?max_distance=0",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(item, nil)
+				m.EXPECT().SimilarItems(gomock.Any(), image, 0).Return([]*SimilarItem{{Item: item}}, nil)
+			},
+			wantCode: http.StatusOK,
+			wantIDs:  []int{},
+		},
+		"ng: item not found": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetByID(gomock.Any(), 1).Return(nil, sql.ErrNoRows)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		"ng: invalid max distance": {
+			query:    "?max_distance=65",
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/1/similar-images"+tt.query, nil)
+			req.SetPathValue("item_id", "1")
+			res := httptest.NewRecorder()
+			h.GetSimilarImages(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp GetSimilarImagesResponse
+			if err := json.Unmarshal(res.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response body: %v", err)
+			}
+			ids := []int{}
+			for _, item := range resp.Items {
+				ids = append(ids, item.ID)
+			}
+			if diff := cmp.Diff(tt.wantIDs, ids); diff != "" {
+				t.Errorf("unexpected similar items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckSimilarImages(t *testing.T) {
+	t.Parallel()
+
+	image := strings.Repeat("a", 64) + ".jpg"
+	similar := []*SimilarItem{{Item: &Item{ID: 7}, Distance: 2}}
+
+	type wants struct {
+		ids    []int
+		fields []FieldError
+		err    bool
+	}
+	cases := map[string]struct {
+		policy   similarImagePolicy
+		image    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: off": {
+			policy:   similarImagePolicyOff,
+			image:    image,
+			injector: func(m *MockItemRepository) {},
+		},
+		"ok: flag": {
+			policy: similarImagePolicyFlag,
+			image:  image,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SimilarItems(gomock.Any(), image, defaultSimilarImageDistance).Return(similar, nil)
+			},
+			wants: wants{ids: []int{7}},
+		},
+		"ok: placeholder is not compared": {
+			policy:   similarImagePolicyReject,
+			image:    defaultImageName,
+			injector: func(m *MockItemRepository) {},
+		},
+		"ng: reject": {
+			policy: similarImagePolicyReject,
+			image:  image,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SimilarItems(gomock.Any(), image, defaultSimilarImageDistance).Return(similar, nil)
+			},
+			wants: wants{fields: []FieldError{{Field: "image", Message: "looks like the image of item 7"}}, err: true},
+		},
+		"ng: failed to compare": {
+			policy: similarImagePolicyReject,
+			image:  image,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SimilarItems(gomock.Any(), image, defaultSimilarImageDistance).Return(nil, errors.New("failed"))
+			},
+			wants: wants{err: true},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR, similarImages: tt.policy}
+
+			ids, err := h.checkSimilarImages(context.Background(), "image", tt.image)
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				if diff := cmp.Diff(tt.fields, verr.Fields); diff != "" {
+					t.Errorf("unexpected field errors (-want +got):\n%s", diff)
+				}
+			}
+			if diff := cmp.Diff(tt.ids, ids); diff != "" {
+				t.Errorf("unexpected similar items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
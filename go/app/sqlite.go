@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlitePragmas are the pragmas set on every connection to the database.
+type sqlitePragmas struct {
+	// JournalMode is stored in the database file. In WAL mode reads do not wait for a write in progress.
+	// Empty leaves the journal mode of the database as it is.
+	JournalMode string
+	// Synchronous NORMAL is safe from corruption in WAL mode; only the last commits may be lost on power loss.
+	Synchronous string
+	// BusyTimeout is how long a connection waits for a lock held by another one before failing with "database is locked".
+	BusyTimeout time.Duration
+	// ForeignKeys enforces the FOREIGN KEY constraints, which SQLite ignores by default.
+	ForeignKeys bool
+}
+
+// defaultSQLitePragmas are the pragmas of the server, which SQLITE_PRAGMAS overrides.
+var defaultSQLitePragmas = sqlitePragmas{
+	JournalMode: "WAL",
+	Synchronous: "NORMAL",
+	BusyTimeout: 5 * time.Second,
+	ForeignKeys: true,
+}
+
+// parseSQLitePragmas parses comma-separated name=value pairs overriding defaultSQLitePragmas,
+// e.g. "synchronous=FULL,busy_timeout=10s". The busy timeout is a duration or milliseconds as in SQLite.
+func parseSQLitePragmas(s string) (sqlitePragmas, error) {
+	pragmas := defaultSQLitePragmas
+	for pair := range strings.SplitSeq(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return sqlitePragmas{}, fmt.Errorf("invalid pragma %q: want name=value", pair)
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		switch name {
+		case "journal_mode":
+			value = strings.ToUpper(value)
+			switch value {
+			case "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+			default:
+				return sqlitePragmas{}, fmt.Errorf("invalid journal_mode %q: want DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF", value)
+			}
+			pragmas.JournalMode = value
+		case "synchronous":
+			value = strings.ToUpper(value)
+			switch value {
+			case "OFF", "NORMAL", "FULL", "EXTRA":
+			default:
+				return sqlitePragmas{}, fmt.Errorf("invalid synchronous %q: want OFF, NORMAL, FULL or EXTRA", value)
+			}
+			pragmas.Synchronous = value
+		case "busy_timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				ms, msErr := strconv.Atoi(value)
+				if msErr != nil {
+					return sqlitePragmas{}, fmt.Errorf("invalid busy_timeout %q: want a duration or milliseconds", value)
+				}
+				d = time.Duration(ms) * time.Millisecond
+			}
+			if d < 0 {
+				return sqlitePragmas{}, fmt.Errorf("invalid busy_timeout %q: must not be negative", value)
+			}
+			pragmas.BusyTimeout = d
+		case "foreign_keys":
+			switch strings.ToLower(value) {
+			case "1", "on", "true", "yes":
+				pragmas.ForeignKeys = true
+			case "0", "off", "false", "no":
+				pragmas.ForeignKeys = false
+			default:
+				return sqlitePragmas{}, fmt.Errorf("invalid foreign_keys %q: want on or off", value)
+			}
+		default:
+			return sqlitePragmas{}, fmt.Errorf("unknown pragma %q: want journal_mode, synchronous, busy_timeout or foreign_keys", name)
+		}
+	}
+	return pragmas, nil
+}
+
+// sqliteDSN returns the data source name of the database at path for the driver.
+// The pragmas are given as parameters of the drivers, so that every connection of a pool has them.
+// Write connections start transactions with BEGIN IMMEDIATE: a transaction that reads before writing
+// would otherwise fail at once with "database is locked" if another connection wrote in between,
+// without waiting for the busy timeout. Read connections refuse to write and leave the journal mode
+// to the write connections, as it is stored in the database file.
+func sqliteDSN(driver, path string, pragmas sqlitePragmas, readOnly bool) string {
+	foreignKeys := 0
+	if pragmas.ForeignKeys {
+		foreignKeys = 1
+	}
+	busyTimeout := pragmas.BusyTimeout.Milliseconds()
+
+	var params []string
+	if driver == "sqlite" {
+		// modernc.org/sqlite writes time.Time in Go's String format unless told otherwise,
+		// which SQLite's date functions cannot read
+		params = append(params, "_time_format=sqlite",
+			fmt.Sprintf("_pragma=busy_timeout(%d)", busyTimeout),
+			fmt.Sprintf("_pragma=foreign_keys(%d)", foreignKeys),
+			"_pragma=synchronous("+pragmas.Synchronous+")")
+		if readOnly {
+			params = append(params, "_pragma=query_only(1)")
+		} else {
+			if pragmas.JournalMode != "" {
+				params = append(params, "_pragma=journal_mode("+pragmas.JournalMode+")")
+			}
+			params = append(params, "_txlock=immediate")
+		}
+	} else {
+		params = append(params,
+			fmt.Sprintf("_busy_timeout=%d", busyTimeout),
+			fmt.Sprintf("_foreign_keys=%d", foreignKeys),
+			"_synchronous="+pragmas.Synchronous)
+		if readOnly {
+			params = append(params, "_query_only=1")
+		} else {
+			if pragmas.JournalMode != "" {
+				params = append(params, "_journal_mode="+pragmas.JournalMode)
+			}
+			params = append(params, "_txlock=immediate")
+		}
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + strings.Join(params, "&")
+}
+
+// openSQLite opens the database at path for writing with the SQLite driver the binary is built with.
+func openSQLite(path string, pragmas sqlitePragmas) (*sql.DB, error) {
+	return sql.Open(sqliteDriver, sqliteDSN(sqliteDriver, path, pragmas, false))
+}
+
+// sqliteReadConns is the number of connections reading from the database at the same time.
+var sqliteReadConns = max(4, runtime.NumCPU())
+
+// sqliteDB is the database of the server with separate connection pools for writes and reads.
+// SQLite allows one writer at a time, so writes share a single connection and queue in the pool
+// instead of failing with "database is locked"; in WAL mode reads run alongside them.
+type sqliteDB struct {
+	write *sql.DB
+	read  *sql.DB
+}
+
+// openSQLiteDB opens the connection pools of the database at path with the driver.
+// The write pool connects first to create the database and set its journal mode.
+func openSQLiteDB(ctx context.Context, driver, path string, pragmas sqlitePragmas) (*sqliteDB, error) {
+	write, err := sql.Open(driver, sqliteDSN(driver, path, pragmas, false))
+	if err != nil {
+		return nil, err
+	}
+	write.SetMaxOpenConns(1)
+	if err := write.PingContext(ctx); err != nil {
+		write.Close()
+		return nil, err
+	}
+
+	read, err := sql.Open(driver, sqliteDSN(driver, path, pragmas, true))
+	if err != nil {
+		write.Close()
+		return nil, err
+	}
+	read.SetMaxOpenConns(sqliteReadConns)
+	read.SetMaxIdleConns(sqliteReadConns)
+	return &sqliteDB{write: write, read: read}, nil
+}
+
+// Close closes both pools.
+func (db *sqliteDB) Close() error {
+	return errors.Join(db.read.Close(), db.write.Close())
+}
+
+// foreignKeyViolations returns the number of rows violating a FOREIGN KEY constraint,
+// e.g. items of deleted categories written before the constraints were enforced.
+// SQLite only checks the rows changed after foreign_keys is turned on.
+func foreignKeyViolations(ctx context.Context, db *sql.DB) (int, error) {
+	rows, err := db.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
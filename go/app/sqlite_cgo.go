@@ -0,0 +1,10 @@
+//go:build cgo && !sqlite_purego
+
+package app
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is github.com/mattn/go-sqlite3, which links the C library of SQLite with cgo.
+const sqliteDriver = "sqlite3"
@@ -0,0 +1,11 @@
+//go:build !cgo || sqlite_purego
+
+package app
+
+import (
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDriver is modernc.org/sqlite, SQLite translated to Go, so that the server builds with CGO_ENABLED=0.
+// It is also used with cgo if built with the sqlite_purego tag.
+const sqliteDriver = "sqlite"
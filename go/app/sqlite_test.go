@@ -0,0 +1,296 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	// linked into the tests even with cgo, so that the repositories are checked with both drivers
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDriverPackages names the SQLite drivers in the tests.
+var sqliteDriverPackages = map[string]string{"sqlite3": "mattn", "sqlite": "modernc"}
+
+// sqliteTestDrivers returns the SQLite drivers linked into the test:
+// modernc.org/sqlite always, and github.com/mattn/go-sqlite3 if built with cgo.
+func sqliteTestDrivers() []string {
+	return slices.DeleteFunc(sql.Drivers(), func(driver string) bool {
+		return driver != "sqlite3" && driver != "sqlite"
+	})
+}
+
+func TestParseSQLitePragmas(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		value   string
+		want    sqlitePragmas
+		wantErr bool
+	}{
+		"ok: defaults": {
+			value: "",
+			want:  defaultSQLitePragmas,
+		},
+		"ok: overrides": {
+			value: "synchronous=full, busy_timeout=250 ,foreign_keys=off",
+			want:  sqlitePragmas{JournalMode: "WAL", Synchronous: "FULL", BusyTimeout: 250 * time.Millisecond},
+		},
+		"ok: busy timeout as a duration": {
+			value: "journal_mode=delete,busy_timeout=10s",
+			want:  sqlitePragmas{JournalMode: "DELETE", Synchronous: "NORMAL", BusyTimeout: 10 * time.Second, ForeignKeys: true},
+		},
+		"ng: unknown pragma": {
+			value:   "cache_size=1000",
+			wantErr: true,
+		},
+		"ng: invalid value": {
+			value:   "synchronous=sometimes",
+			wantErr: true,
+		},
+		"ng: not a pair": {
+			value:   "wal",
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseSQLitePragmas(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected pragmas (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		driver   string
+		path     string
+		readOnly bool
+		want     string
+	}{
+		"mattn": {
+			driver: "sqlite3",
+			path:   "db/mercari.sqlite3",
+			want:   "db/mercari.sqlite3?_busy_timeout=5000&_foreign_keys=1&_synchronous=NORMAL&_journal_mode=WAL&_txlock=immediate",
+		},
+		"mattn read only": {
+			driver:   "sqlite3",
+			path:     "db/mercari.sqlite3",
+			readOnly: true,
+			want:     "db/mercari.sqlite3?_busy_timeout=5000&_foreign_keys=1&_synchronous=NORMAL&_query_only=1",
+		},
+		"modernc": {
+			driver: "sqlite",
+			path:   "db/mercari.sqlite3",
+			want:   "db/mercari.sqlite3?_time_format=sqlite&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=synchronous(NORMAL)&_pragma=journal_mode(WAL)&_txlock=immediate",
+		},
+		"modernc read only with options": {
+			driver:   "sqlite",
+			path:     "db/mercari.sqlite3?cache=shared",
+			readOnly: true,
+			want:     "db/mercari.sqlite3?cache=shared&_time_format=sqlite&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=synchronous(NORMAL)&_pragma=query_only(1)",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := sqliteDSN(tt.driver, tt.path, defaultSQLitePragmas, tt.readOnly); got != tt.want {
+				t.Errorf("unexpected DSN. want=%s, got=%s", tt.want, got)
+			}
+		})
+	}
+}
+
+// openTestSQLiteDB opens the pools of a migrated temporary database with the driver.
+func openTestSQLiteDB(t *testing.T, driver string) *sqliteDB {
+	t.Helper()
+
+	ctx := context.Background()
+	db, err := openSQLiteDB(ctx, driver, filepath.Join(t.TempDir(), "mercari.sqlite3"), defaultSQLitePragmas)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate(ctx, db.write); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// TestSQLiteDrivers checks the behavior the repositories rely on with every driver:
+// times written by the driver and by CURRENT_TIMESTAMP are read back and compared as dates,
+// and the pragmas are set on both pools.
+func TestSQLiteDrivers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	for _, driver := range sqliteTestDrivers() {
+		t.Run(sqliteDriverPackages[driver], func(t *testing.T) {
+			db := openTestSQLiteDB(t, driver)
+
+			written := time.Date(2025, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+			if _, err := db.write.Exec(`INSERT INTO categories (name) VALUES ('fashion')`); err != nil {
+				t.Fatalf("failed to insert category: %v", err)
+			}
+			_, err := db.write.Exec(`INSERT INTO items (name, category_id, image_name, updated_at) VALUES ('jacket', 1, 'default.jpg', ?), ('shirt', 1, 'default.jpg', CURRENT_TIMESTAMP)`, written)
+			if err != nil {
+				t.Fatalf("failed to insert items: %v", err)
+			}
+
+			var got time.Time
+			if err := db.read.QueryRow(`SELECT updated_at FROM items WHERE id = 1`).Scan(&got); err != nil {
+				t.Fatalf("failed to read time: %v", err)
+			}
+			if !got.Equal(written) {
+				t.Errorf("unexpected time. want=%v, got=%v", written, got)
+			}
+			var n int
+			if err := db.read.QueryRow(`SELECT COUNT(*) FROM items WHERE julianday(updated_at) >= julianday('2025-01-02 03:04:05')`).Scan(&n); err != nil {
+				t.Fatalf("failed to compare times: %v", err)
+			}
+			if n != 2 {
+				t.Errorf("unexpected number of items compared as dates. want=2, got=%d", n)
+			}
+
+			for pool, conn := range map[string]*sql.DB{"write": db.write, "read": db.read} {
+				got := map[string]string{}
+				for _, pragma := range []string{"journal_mode", "synchronous", "busy_timeout", "foreign_keys", "query_only"} {
+					var v string
+					if err := conn.QueryRow(`PRAGMA ` + pragma).Scan(&v); err != nil {
+						t.Fatalf("failed to read %s of %s pool: %v", pragma, pool, err)
+					}
+					got[pragma] = v
+				}
+				// synchronous NORMAL is 1
+				want := map[string]string{"journal_mode": "wal", "synchronous": "1", "busy_timeout": "5000", "foreign_keys": "1", "query_only": "0"}
+				if pool == "read" {
+					want["query_only"] = "1"
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("unexpected pragmas of %s pool (-want +got):\n%s", pool, diff)
+				}
+			}
+
+			if _, err := db.write.Exec(`INSERT INTO items (name, category_id, image_name) VALUES ('cap', 9, 'default.jpg')`); err == nil {
+				t.Errorf("expected the foreign key to reject an item of a missing category")
+			}
+			if _, err := db.read.Exec(`DELETE FROM items`); err == nil {
+				t.Errorf("expected the read pool to refuse writes")
+			}
+		})
+	}
+}
+
+// TestSQLiteConcurrentItems is a load test of concurrent POST /items and GET /items
+// on the pools of the server: no request fails with "database is locked" and every item is stored.
+func TestSQLiteConcurrentItems(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	const (
+		writers        = 16
+		readers        = 16
+		itemsPerWriter = 20
+		readsPerReader = 40
+	)
+	for _, driver := range sqliteTestDrivers() {
+		t.Run(sqliteDriverPackages[driver], func(t *testing.T) {
+			db := openTestSQLiteDB(t, driver)
+			h := &Handlers{itemRepo: NewItemRepositoryWithPools(db.write, db.read)}
+
+			var wg sync.WaitGroup
+			errs := make(chan string, writers*itemsPerWriter+readers*readsPerReader)
+			for w := range writers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range itemsPerWriter {
+						values := url.Values{"name": {fmt.Sprintf("item %d-%d", w, i)}, "category": {fmt.Sprintf("category %d", i%4)}}
+						req := httptest.NewRequest("POST", "/items", strings.NewReader(values.Encode()))
+						req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+						res := httptest.NewRecorder()
+						h.AddItem(res, req)
+						if res.Code != http.StatusOK {
+							errs <- fmt.Sprintf("POST /items: %d %s", res.Code, res.Body.String())
+						}
+					}
+				}()
+			}
+			for range readers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range readsPerReader {
+						res := httptest.NewRecorder()
+						h.GetItems(res, httptest.NewRequest("GET", "/items", nil))
+						if res.Code != http.StatusOK {
+							errs <- fmt.Sprintf("GET /items: %d %s", res.Code, res.Body.String())
+						}
+					}
+				}()
+			}
+			start := time.Now()
+			wg.Wait()
+			close(errs)
+			t.Logf("%d writes and %d reads in %v", writers*itemsPerWriter, readers*readsPerReader, time.Since(start))
+
+			for err := range errs {
+				t.Error(err)
+			}
+			items, err := h.itemRepo.GetAll(context.Background())
+			if err != nil {
+				t.Fatalf("failed to get items: %v", err)
+			}
+			if len(items) != writers*itemsPerWriter {
+				t.Errorf("unexpected number of items. want=%d, got=%d", writers*itemsPerWriter, len(items))
+			}
+		})
+	}
+}
+
+func TestForeignKeyViolations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db := openTestSQLiteDB(t, sqliteDriver)
+	ctx := context.Background()
+	if n, err := foreignKeyViolations(ctx, db.write); err != nil || n != 0 {
+		t.Fatalf("unexpected violations of an empty database: %d, %v", n, err)
+	}
+	// written before the constraints were enforced
+	if _, err := db.write.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		t.Fatalf("failed to turn off foreign keys: %v", err)
+	}
+	if _, err := db.write.ExecContext(ctx, `INSERT INTO items (name, category_id, image_name) VALUES ('jacket', 9, 'default.jpg')`); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	if n, err := foreignKeyViolations(ctx, db.write); err != nil || n != 1 {
+		t.Errorf("unexpected violations. want=1, got=%d, %v", n, err)
+	}
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// uploadTokenTTL is how long an upload token can be used to refer to an uploaded image.
+	uploadTokenTTL = 15 * time.Minute
+	// uploadGCTTL is how long an uploaded image is kept without being referred to by an item.
+	// It must be longer than uploadTokenTTL so that an image is never removed while a token for it is valid.
+	uploadGCTTL = 24 * time.Hour
+	// uploadGCInterval is how often unreferenced uploads are looked for.
+	uploadGCInterval = time.Hour
+)
+
+var errInvalidUploadToken = errors.New("upload token is invalid or expired")
+
+// newUploadSecret returns the key used to sign upload tokens.
+// If UPLOAD_TOKEN_SECRET is not set, a random key is used, so tokens do not survive a restart.
+func newUploadSecret() []byte {
+	if secret, ok := os.LookupEnv("UPLOAD_TOKEN_SECRET"); ok && secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+}
+
+// signUploadToken returns a token proving that imageName was uploaded to this server.
+// The token is "<expiry in unix seconds>.<HMAC-SHA256 of image name and expiry>" encoded in base64url.
+func signUploadToken(secret []byte, imageName string, expiresAt time.Time) string {
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(exp + "." + uploadTokenMAC(secret, imageName, exp)))
+}
+
+// verifyUploadToken returns errInvalidUploadToken unless token was signed for imageName and has not expired.
+func verifyUploadToken(secret []byte, imageName, token string, now time.Time) error {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return errInvalidUploadToken
+	}
+	exp, mac, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return errInvalidUploadToken
+	}
+	if !hmac.Equal([]byte(mac), []byte(uploadTokenMAC(secret, imageName, exp))) {
+		return errInvalidUploadToken
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.After(time.Unix(expUnix, 0)) {
+		return errInvalidUploadToken
+	}
+	return nil
+}
+
+func uploadTokenMAC(secret []byte, imageName, exp string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(imageName + "." + exp))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+type UploadImageResponse struct {
+	// ImageName is the content-addressed ID of the image, "<sha256>.jpg".
+	ImageName string `json:"image_name"`
+	// UploadToken must be sent with ImageName when adding an item.
+	UploadToken string    `json:"upload_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// UploadImage is a handler to upload an image for POST /images .
+// The returned image name and token are used with POST /items, so that a failed
+// validation of the item does not require uploading the image again.
+// The body is limited to maxAddItemBodySize like POST /items.
+func (s *Handlers) UploadImage(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAddItemBodySize)
+	if err := r.ParseMultipartForm(maxAddItemBodySize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must be at most %d bytes", maxAddItemBodySize))
+			return
+		}
+		writeJSONError(w, r, http.StatusBadRequest, "failed to parse form")
+		return
+	}
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		verr := &ValidationError{}
+		verr.add("image", "is required")
+		writeValidationError(w, r, verr)
+		return
+	}
+	defer file.Close()
+
+	image, err := io.ReadAll(io.LimitReader(file, maxAddItemBodySize))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "failed to read image")
+		return
+	}
+
+	fileName, err := s.storeImage(r.Context(), image)
+	if err != nil {
+		writeStoreImageError(w, r, "image", err)
+		return
+	}
+
+	expiresAt := time.Now().Add(uploadTokenTTL).Truncate(time.Second)
+	resp := UploadImageResponse{
+		ImageName:   fileName,
+		UploadToken: signUploadToken(s.uploadSecret, fileName, expiresAt),
+		ExpiresAt:   expiresAt.UTC(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// collectUploads removes uploaded images that no item refers to and that are older than ttl.
+// storeImage refreshes the modification time when the same image is uploaded again,
+// so the modification time is the time of the last upload.
+// It returns the names of the removed images.
+func (s *Handlers) collectUploads(ctx context.Context, ttl time.Duration, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(s.imgDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image directory: %w", err)
+	}
+
+	var removed []string
+	for _, e := range entries {
+		// only content-addressed images are uploads; default.jpg etc. are kept
+		if e.IsDir() || !imageRefPattern.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return removed, err
+		}
+		if now.Sub(info.ModTime()) < ttl {
+			continue
+		}
+
+		count, err := s.itemRepo.CountByImage(ctx, e.Name())
+		if err != nil {
+			return removed, fmt.Errorf("failed to count items referring to %s: %w", e.Name(), err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.imgDirPath, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("failed to remove %s: %w", e.Name(), err)
+		}
+		if s.imageRepo != nil {
+			if _, err := s.imageRepo.DeleteUnreferencedImage(ctx, e.Name()); err != nil {
+				return removed, fmt.Errorf("failed to delete metadata of %s: %w", e.Name(), err)
+			}
+		}
+		removed = append(removed, e.Name())
+	}
+	return removed, nil
+}
+
+// runUploadGC calls collectUploads every interval until ctx is canceled.
+// Incomplete resumable uploads older than ttl are removed as well.
+func (s *Handlers) runUploadGC(ctx context.Context, interval, ttl time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := s.collectUploads(ctx, ttl, now)
+			if err != nil {
+				slog.Error("failed to collect unreferenced uploads", "error", err)
+			}
+			if len(removed) > 0 {
+				slog.Info("removed unreferenced uploads", "images", removed)
+			}
+
+			// resumable uploads that were never completed
+			expired, err := s.uploads.removeExpired(now.Add(-ttl))
+			if err != nil {
+				slog.Error("failed to remove expired resumable uploads", "error", err)
+			}
+			if len(expired) > 0 {
+				slog.Info("removed expired resumable uploads", "uploads", expired)
+			}
+		}
+	}
+}
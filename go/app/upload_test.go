@@ -0,0 +1,202 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestVerifyUploadToken(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	token := signUploadToken(secret, "a.jpg", now.Add(time.Minute))
+
+	cases := map[string]struct {
+		secret    []byte
+		imageName string
+		token     string
+		now       time.Time
+		wantErr   bool
+	}{
+		"ok: valid token": {
+			secret: secret, imageName: "a.jpg", token: token, now: now,
+		},
+		"ng: expired": {
+			secret: secret, imageName: "a.jpg", token: token, now: now.Add(2 * time.Minute), wantErr: true,
+		},
+		"ng: other image": {
+			secret: secret, imageName: "b.jpg", token: token, now: now, wantErr: true,
+		},
+		"ng: other secret": {
+			secret: []byte("other"), imageName: "a.jpg", token: token, now: now, wantErr: true,
+		},
+		"ng: malformed token": {
+			secret: secret, imageName: "a.jpg", token: "not a token", now: now, wantErr: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := verifyUploadToken(tt.secret, tt.imageName, tt.token, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadImageThenAddItem(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	mockIR := NewMockItemRepository(ctrl)
+	h := &Handlers{imgDirPath: t.TempDir(), itemRepo: mockIR, uploadSecret: []byte("secret")}
+
+	// upload the image
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("image", "jacket.jpg")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	fw.Write(encodeTestJPEG(t, 8, 8, nil))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/images", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res := httptest.NewRecorder()
+	h.UploadImage(res, req)
+
+	if res.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code. want=%d, got=%d: %s", http.StatusCreated, res.Code, res.Body.String())
+	}
+	var uploaded UploadImageResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &uploaded); err != nil {
+		t.Fatalf("failed to unmarshal response body: %v", err)
+	}
+	if !imageRefPattern.MatchString(uploaded.ImageName) {
+		t.Fatalf("unexpected image name: %s", uploaded.ImageName)
+	}
+
+	cases := map[string]struct {
+		token    string
+		injector func(m *MockItemRepository)
+		wantCode int
+	}{
+		"ok: valid token": {
+			token: uploaded.UploadToken,
+			injector: func(m *MockItemRepository) {
+				item := &Item{Name: "jacket", Category: "fashion", Image: uploaded.ImageName}
+				m.EXPECT().Insert(gomock.Any(), item).Return(nil)
+			},
+			wantCode: http.StatusOK,
+		},
+		"ng: invalid token": {
+			token:    "invalid",
+			injector: func(m *MockItemRepository) {},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			tt.injector(mockIR)
+
+			reqBody, _ := json.Marshal(map[string]string{
+				"name":         "jacket",
+				"category":     "fashion",
+				"image_name":   uploaded.ImageName,
+				"upload_token": tt.token,
+			})
+			req := httptest.NewRequest("POST", "/items", bytes.NewReader(reqBody))
+			req.Header.Set("Content-Type", "application/json")
+			res := httptest.NewRecorder()
+			h.AddItem(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Errorf("unexpected status code. want=%d, got=%d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestUploadImageTooLarge(t *testing.T) {
+	t.Parallel()
+
+	h := &Handlers{imgDirPath: t.TempDir(), uploadSecret: []byte("secret")}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("image", "jacket.jpg")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	fw.Write(make([]byte, maxAddItemBodySize))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/images", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res := httptest.NewRecorder()
+	h.UploadImage(res, req)
+
+	if res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected status code. want=%d, got=%d: %s", http.StatusRequestEntityTooLarge, res.Code, res.Body.String())
+	}
+}
+
+func TestCollectUploads(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	oldUnused := strings.Repeat("a", 64) + ".jpg"
+	oldUsed := strings.Repeat("b", 64) + ".jpg"
+	newUnused := strings.Repeat("c", 64) + ".jpg"
+
+	dir := t.TempDir()
+	for name, modTime := range map[string]time.Time{
+		oldUnused:     now.Add(-2 * uploadGCTTL),
+		oldUsed:       now.Add(-2 * uploadGCTTL),
+		newUnused:     now.Add(-time.Minute),
+		"default.jpg": now.Add(-2 * uploadGCTTL),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to set modification time: %v", err)
+		}
+	}
+
+	ctrl := gomock.NewController(t)
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().CountByImage(gomock.Any(), oldUnused).Return(0, nil)
+	mockIR.EXPECT().CountByImage(gomock.Any(), oldUsed).Return(1, nil)
+	h := &Handlers{imgDirPath: dir, itemRepo: mockIR}
+
+	removed, err := h.collectUploads(context.Background(), uploadGCTTL, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{oldUnused}, removed); diff != "" {
+		t.Errorf("unexpected removed images (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(dir, oldUnused)); !os.IsNotExist(err) {
+		t.Errorf("%s should have been removed", oldUnused)
+	}
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// quarantineDirName is the directory under the image directory where corrupt images are moved to.
+const quarantineDirName = ".quarantine"
+
+// VerifyImagesOptions selects the repairs made by VerifyImages. By default nothing is changed.
+type VerifyImagesOptions struct {
+	// Quarantine moves corrupt images out of the image directory.
+	Quarantine bool
+	// ResetItems makes the items referring to corrupt or missing images refer to the default image.
+	ResetItems bool
+}
+
+// VerifyImagesReport is the result of VerifyImages. Image lists are sorted by name.
+type VerifyImagesReport struct {
+	// Checked is the number of content-addressed images rehashed.
+	Checked int `json:"checked"`
+	// Corrupt images have content whose SHA-256 does not match their name.
+	Corrupt []string `json:"corrupt"`
+	// Missing images are referred to by items but not in the image directory.
+	Missing []string `json:"missing"`
+	// Orphaned images are in the image directory but not referred to by any item.
+	// They include recent uploads not used by an item yet, so they are only reported.
+	Orphaned []string `json:"orphaned"`
+	// Quarantined images were moved to the quarantine directory.
+	Quarantined []string `json:"quarantined"`
+	// ResetItems is the number of items changed to refer to the default image.
+	ResetItems int `json:"reset_items"`
+}
+
+// OK reports whether no corrupt or missing images were found.
+func (r *VerifyImagesReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Missing) == 0
+}
+
+// VerifyImages checks that every content-addressed image in imgDirPath is named after
+// the SHA-256 of its content and that every image referred to by an item exists.
+// imageRepo may be nil; otherwise the metadata of quarantined images is deleted.
+func VerifyImages(ctx context.Context, itemRepo ItemRepository, imageRepo ImageRepository, imgDirPath string, opts VerifyImagesOptions) (*VerifyImagesReport, error) {
+	referenced, err := itemRepo.ReferencedImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referenced images: %w", err)
+	}
+	entries, err := os.ReadDir(imgDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image directory: %w", err)
+	}
+
+	report := &VerifyImagesReport{Corrupt: []string{}, Missing: []string{}, Orphaned: []string{}, Quarantined: []string{}}
+	present := map[string]bool{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		present[e.Name()] = true
+		if !imageRefPattern.MatchString(e.Name()) {
+			continue
+		}
+
+		report.Checked++
+		ok, err := verifyImageHash(filepath.Join(imgDirPath, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			report.Corrupt = append(report.Corrupt, e.Name())
+			continue
+		}
+		if referenced[e.Name()] == 0 {
+			report.Orphaned = append(report.Orphaned, e.Name())
+		}
+	}
+	for name := range referenced {
+		if name != "" && !present[name] {
+			report.Missing = append(report.Missing, name)
+		}
+	}
+	sort.Strings(report.Missing)
+
+	if opts.Quarantine {
+		for _, name := range report.Corrupt {
+			if err := quarantineImage(imgDirPath, name); err != nil {
+				return report, err
+			}
+			report.Quarantined = append(report.Quarantined, name)
+		}
+	}
+	if opts.ResetItems {
+		for _, name := range slices.Concat(report.Corrupt, report.Missing) {
+			if name == defaultImageName {
+				continue
+			}
+			n, err := itemRepo.ReplaceImage(ctx, name, defaultImageName)
+			if err != nil {
+				return report, fmt.Errorf("failed to reset items referring to %s: %w", name, err)
+			}
+			report.ResetItems += n
+		}
+	}
+	if imageRepo != nil {
+		// the metadata is recorded again if the image is uploaded again.
+		// Images still referred to by items keep it until the items are reset.
+		for _, name := range report.Quarantined {
+			if _, err := imageRepo.DeleteUnreferencedImage(ctx, name); err != nil {
+				return report, fmt.Errorf("failed to delete metadata of %s: %w", name, err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// verifyImageHash reports whether the content of a content-addressed image matches its name.
+func verifyImageHash(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)) == strings.TrimSuffix(filepath.Base(path), ".jpg"), nil
+}
+
+// quarantineImage moves the image to the quarantine directory.
+func quarantineImage(imgDirPath, name string) error {
+	dir := filepath.Join(imgDirPath, quarantineDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.Rename(filepath.Join(imgDirPath, name), filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", name, err)
+	}
+	return nil
+}
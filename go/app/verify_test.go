@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestVerifyImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	hashName := func(content string) string {
+		hash := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(hash[:]) + ".jpg"
+	}
+	good := hashName("good")
+	corrupt := hashName("corrupt")
+	orphaned := hashName("orphaned")
+	missing := strings.Repeat("0", 64) + ".jpg"
+
+	cases := map[string]struct {
+		opts       VerifyImagesOptions
+		want       *VerifyImagesReport
+		wantImages map[string]int
+	}{
+		"ok: report only": {
+			want: &VerifyImagesReport{
+				Checked:  3,
+				Corrupt:  []string{corrupt},
+				Missing:  []string{missing},
+				Orphaned: []string{orphaned},
+			},
+			wantImages: map[string]int{good: 1, corrupt: 1, missing: 1},
+		},
+		"ok: quarantine and reset items": {
+			opts: VerifyImagesOptions{Quarantine: true, ResetItems: true},
+			want: &VerifyImagesReport{
+				Checked:     3,
+				Corrupt:     []string{corrupt},
+				Missing:     []string{missing},
+				Orphaned:    []string{orphaned},
+				Quarantined: []string{corrupt},
+				ResetItems:  2,
+			},
+			wantImages: map[string]int{good: 1, defaultImageName: 2},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			db, closers, err := setupDB(t)
+			if err != nil {
+				t.Fatalf("failed to set up database: %v", err)
+			}
+			t.Cleanup(func() {
+				for _, c := range closers {
+					c()
+				}
+			})
+
+			dir := t.TempDir()
+			files := map[string]string{good: "good", corrupt: "tampered", orphaned: "orphaned", defaultImageName: "default"}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to write image: %v", err)
+				}
+			}
+
+			ctx := context.Background()
+			itemRepo := NewItemRepositoryWithDB(db)
+			for _, image := range []string{good, corrupt, missing} {
+				if err := itemRepo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", Image: image}); err != nil {
+					t.Fatalf("failed to insert item: %v", err)
+				}
+			}
+
+			got, err := VerifyImages(ctx, itemRepo, NewImageRepositoryWithDB(db), dir, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected report (-want +got):\n%s", diff)
+			}
+
+			images, err := itemRepo.ReferencedImages(ctx)
+			if err != nil {
+				t.Fatalf("failed to list referenced images: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantImages, images); diff != "" {
+				t.Errorf("unexpected referenced images (-want +got):\n%s", diff)
+			}
+
+			_, err = os.Stat(filepath.Join(dir, quarantineDirName, corrupt))
+			if quarantined := err == nil; quarantined != tt.opts.Quarantine {
+				t.Errorf("unexpected quarantine of %s. want=%v, got=%v", corrupt, tt.opts.Quarantine, quarantined)
+			}
+		})
+	}
+}
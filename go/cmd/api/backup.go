@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mercari-build-training/app"
+	"os"
+	"time"
+)
+
+// backup runs the backup subcommand and returns the exit code.
+// It can run while the server is running, like GET /admin/backup, since it does not migrate the database.
+func backup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s backup [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Writes a tar archive of a snapshot of the database and the images of its items.")
+		fs.PrintDefaults()
+	}
+	dbPath := fs.String("db", app.DefaultDBPath, "path to the database")
+	imgDir := fs.String("images", imageDirPath, "path to the image directory")
+	output := fs.String("o", "", "path to the archive (default mercari-backup-<time>.tar)")
+	asJSON := fs.Bool("json", false, "print the manifest as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *output == "" {
+		*output = "mercari-backup-" + time.Now().UTC().Format("20060102T150405Z") + ".tar"
+	}
+
+	ctx := context.Background()
+	db, err := app.OpenDBNoMigrate(ctx, *dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create archive: %v\n", err)
+		return 1
+	}
+	manifest, err := app.Backup(ctx, db, *imgDir, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// do not leave a truncated archive behind
+		os.Remove(*output)
+		fmt.Fprintf(os.Stderr, "failed to back up: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(manifest)
+	} else {
+		for _, name := range manifest.MissingImages {
+			fmt.Printf("missing\t%s\n", name)
+		}
+		fmt.Printf("wrote %s: %d items at schema version %d, %d images, %d missing\n",
+			*output, manifest.Items, manifest.SchemaVersion, len(manifest.Images), len(manifest.MissingImages))
+	}
+	return 0
+}
+
+// restore runs the restore subcommand and returns the exit code.
+func restore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s restore [flags] <archive.tar>\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Validates an archive written by backup and replaces the database and images with it.")
+		fmt.Fprintln(fs.Output(), "Stop the server first. The replaced database is kept next to the restored one.")
+		fs.PrintDefaults()
+	}
+	dbPath := fs.String("db", app.DefaultDBPath, "path to the database")
+	imgDir := fs.String("images", imageDirPath, "path to the image directory")
+	dryRun := fs.Bool("dry-run", false, "validate the archive without changing anything")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open archive: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	report, err := app.RestoreBackup(context.Background(), f, *dbPath, *imgDir, app.RestoreOptions{DryRun: *dryRun})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to restore: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(report)
+		return 0
+	}
+	m := report.Manifest
+	if *dryRun {
+		fmt.Printf("archive is valid: %d items at schema version %d taken at %s, %d images, %d missing\n",
+			m.Items, m.SchemaVersion, m.CreatedAt.Format(time.RFC3339), len(m.Images), len(m.MissingImages))
+		return 0
+	}
+	if report.PreviousDatabase != "" {
+		fmt.Printf("moved the previous database to %s\n", report.PreviousDatabase)
+	}
+	fmt.Printf("restored %d items at schema version %d taken at %s, %d of %d images written\n",
+		m.Items, m.SchemaVersion, m.CreatedAt.Format(time.RFC3339), report.RestoredImages, len(m.Images))
+	return 0
+}
@@ -12,7 +12,20 @@ const (
 
 func main() {
 	// This is the entry point of the application.
-	// You don't need to modify this function.
+	// Without a subcommand, it runs the API server.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify-images":
+			os.Exit(verifyImages(os.Args[2:]))
+		case "migrate-json":
+			os.Exit(migrateJSON(os.Args[2:]))
+		case "backup":
+			os.Exit(backup(os.Args[2:]))
+		case "restore":
+			os.Exit(restore(os.Args[2:]))
+		}
+	}
+
 	os.Exit(app.Server{
 		Port:         port,
 		ImageDirPath: imageDirPath,
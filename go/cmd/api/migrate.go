@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mercari-build-training/app"
+	"os"
+	"strings"
+)
+
+// migrateJSON runs the migrate-json subcommand and returns the exit code.
+// It exits with 1 if items were skipped or migrated without their image,
+// so that they are not missed; running it again after fixing them migrates only those.
+func migrateJSON(args []string) int {
+	fs := flag.NewFlagSet("migrate-json", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s migrate-json [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Copies the items of the items.json file used before the database into the database.")
+		fmt.Fprintln(fs.Output(), "Items already in the database are not copied again.")
+		fs.PrintDefaults()
+	}
+	file := fs.String("file", app.LegacyItemsFile, "path to the items.json file")
+	dbPath := fs.String("db", app.DefaultDBPath, "path to the database")
+	imgDir := fs.String("images", imageDirPath, "path to the image directory")
+	dryRun := fs.Bool("dry-run", false, "report what would be migrated without changing the database")
+	resetMissing := fs.Bool("reset-missing-images", false, "migrate items whose image is missing with the placeholder image of their category instead of skipping them")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	placeholders, err := app.PlaceholderImages()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open items file: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	ctx := app.WithActor(context.Background(), "migrate-json")
+	db, err := app.OpenDB(ctx, *dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	report, err := app.MigrateJSON(ctx, app.NewItemRepositoryWithDB(db), f, *imgDir, app.MigrateJSONOptions{
+		DryRun:             *dryRun,
+		ResetMissingImages: *resetMissing,
+		Placeholders:       placeholders,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to migrate items: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		printMigrateReport(report, *dryRun)
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+func printMigrateReport(report *app.MigrateJSONReport, dryRun bool) {
+	for _, e := range report.Invalid {
+		fmt.Printf("skipped\titem %d: %s\n", e.Index, e.Message)
+	}
+	for _, name := range report.MissingImages {
+		fmt.Printf("missing\t%s\n", name)
+	}
+	if len(report.Categories) > 0 {
+		fmt.Printf("categories\t%s\n", strings.Join(report.Categories, ", "))
+	}
+	verb := "migrated"
+	if dryRun {
+		verb = "would migrate"
+	}
+	fmt.Printf("read %d items: %s %d (%d with a placeholder image), %d already in the database, %d skipped\n",
+		report.Read, verb, report.Migrated, report.ResetItems, report.Existing, len(report.Invalid))
+}
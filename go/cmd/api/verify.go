@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mercari-build-training/app"
+	"os"
+)
+
+// verifyImages runs the verify-images subcommand and returns the exit code.
+// It exits with 1 if corrupt or missing images are found, even if they were repaired,
+// so that a scheduled run reports them.
+func verifyImages(args []string) int {
+	fs := flag.NewFlagSet("verify-images", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s verify-images [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Rehashes every image and reports corrupt, missing and orphaned images.")
+		fs.PrintDefaults()
+	}
+	dbPath := fs.String("db", app.DefaultDBPath, "path to the database")
+	imgDir := fs.String("images", imageDirPath, "path to the image directory")
+	quarantine := fs.Bool("quarantine", false, "move corrupt images to the .quarantine directory")
+	resetItems := fs.Bool("reset-items", false, "make items referring to corrupt or missing images use the default image")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx := app.WithActor(context.Background(), "verify-images")
+	db, err := app.OpenDB(ctx, *dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	report, err := app.VerifyImages(ctx, app.NewItemRepositoryWithDB(db), app.NewImageRepositoryWithDB(db), *imgDir, app.VerifyImagesOptions{
+		Quarantine: *quarantine,
+		ResetItems: *resetItems,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to verify images: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		printVerifyReport(report)
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+func printVerifyReport(report *app.VerifyImagesReport) {
+	for _, name := range report.Corrupt {
+		fmt.Printf("corrupt\t%s\n", name)
+	}
+	for _, name := range report.Missing {
+		fmt.Printf("missing\t%s\n", name)
+	}
+	for _, name := range report.Orphaned {
+		fmt.Printf("orphaned\t%s\n", name)
+	}
+	for _, name := range report.Quarantined {
+		fmt.Printf("quarantined\t%s\n", name)
+	}
+	fmt.Printf("checked %d images: %d corrupt, %d missing, %d orphaned, %d quarantined, %d items reset\n",
+		report.Checked, len(report.Corrupt), len(report.Missing), len(report.Orphaned), len(report.Quarantined), report.ResetItems)
+}
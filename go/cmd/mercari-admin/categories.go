@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mercari-build-training/app"
+)
+
+func (c *cli) listCategories(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	categories, err := c.repo.GetCategories(ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(map[string][]*app.Category{"categories": categories}, "ID\tNAME\tITEMS", func(w io.Writer) {
+		for _, category := range categories {
+			fmt.Fprintf(w, "%d\t%s\t%d\n", category.ID, category.Name, category.Items)
+		}
+	})
+}
+
+func (c *cli) createCategory(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errUsage
+	}
+	// an existing category with the name is returned as is
+	id, err := c.repo.CategoryInsert(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return c.printMessage("category %d: %s", id, args[0])
+}
+
+func (c *cli) renameCategory(ctx context.Context, args []string) error {
+	if len(args) != 2 || args[1] == "" {
+		return errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := c.repo.RenameCategory(ctx, id, args[1]); err != nil {
+		return err
+	}
+	return c.printMessage("renamed category %d to %s", id, args[1])
+}
+
+func (c *cli) deleteCategory(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := c.repo.DeleteCategory(ctx, id); err != nil {
+		return err
+	}
+	return c.printMessage("deleted category %d", id)
+}
+
+func (c *cli) mergeCategories(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	src, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+	moved, err := c.repo.MergeCategories(ctx, src, dst)
+	if err != nil {
+		return err
+	}
+	return c.printMessage("moved %d items from category %d to %d", moved, src, dst)
+}
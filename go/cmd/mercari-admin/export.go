@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"mercari-build-training/app"
+	"os"
+	"time"
+)
+
+// exportItems writes the items joined with their categories as GET /items/export does,
+// reading the database directly so that the API server does not have to be running.
+func (c *cli) exportItems(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("items export", flag.ContinueOnError)
+	columns := fs.String("columns", "", "comma-separated columns to export (default all)")
+	var filter app.ExportFilter
+	fs.StringVar(&filter.Category, "category", "", "export only the items in the category")
+	fs.StringVar(&filter.Keyword, "keyword", "", "export only the items whose name contains the keyword")
+	since := fs.String("updated-since", "", "export only the items changed at or after the RFC 3339 time")
+	output := fs.String("o", "", "file to write instead of the standard output")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	format := fs.Arg(0)
+
+	cols, err := app.ParseExportColumns(*columns)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *since != "" {
+		filter.UpdatedSince, err = time.Parse(time.RFC3339, *since)
+		if err != nil {
+			return fmt.Errorf("%w: -updated-since must be a time such as 2025-01-02T15:04:05Z", errUsage)
+		}
+	}
+
+	if *output == "" {
+		return export(ctx, c.repo, c.out, format, cols, filter)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = export(ctx, c.repo, f, format, cols, filter)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// do not leave a truncated export behind
+		os.Remove(*output)
+	}
+	return err
+}
+
+func export(ctx context.Context, repo app.ItemRepository, w io.Writer, format string, columns []string, filter app.ExportFilter) error {
+	ew, err := app.NewExportWriter(w, format, columns)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if err := repo.ExportItems(ctx, filter, ew.Write); err != nil {
+		return err
+	}
+	return ew.Close()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mercari-build-training/app"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// importItems sends a CSV or JSON Lines file to POST /v1/items:bulk together with the images it refers to.
+// Image paths relative to the file are resolved from the directory of the file.
+func (c *cli) importItems(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	path := args[0]
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	rows, err := app.ReadBulkItems(f, path)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	images, err := importImages(path, rows, c.errOut)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeImportForm(mw, path, images))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.server, "/")+"/v1/items:bulk", pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var errRes app.ErrorResponse
+		if err := json.NewDecoder(res.Body).Decode(&errRes); err != nil || errRes.Message == "" {
+			return fmt.Errorf("server returned %s", res.Status)
+		}
+		for _, fe := range errRes.Errors {
+			errRes.Message += fmt.Sprintf("; %s %s", fe.Field, fe.Message)
+		}
+		return fmt.Errorf("server returned %s: %s", res.Status, errRes.Message)
+	}
+	var report app.BulkAddItemsResponse
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	err = c.print(report, "ROW\tID\tERRORS", func(w io.Writer) {
+		for _, result := range report.Results {
+			var msgs []string
+			for _, fe := range result.Errors {
+				msgs = append(msgs, strings.TrimSpace(fe.Field+" "+fe.Message))
+			}
+			fmt.Fprintf(w, "%d\t%d\t%s\n", result.Row, result.ID, strings.Join(msgs, "; "))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", report.Failed, len(report.Results))
+	}
+	return nil
+}
+
+// importImages returns the local paths of the images the rows refer to, keyed by the name they are attached under.
+// Missing images are not attached, so that the server reports the rows referring to them; they are reported to errOut.
+func importImages(path string, rows []app.BulkItemRow, errOut io.Writer) (map[string]string, error) {
+	images := map[string]string{}
+	for _, row := range rows {
+		if row.Image == "" {
+			continue
+		}
+		local := row.Image
+		if u, err := url.Parse(row.Image); err == nil && u.Scheme == "file" {
+			// file:///C:/photo.jpg on Windows
+			local = filepath.FromSlash(strings.TrimPrefix(u.Path, "/"))
+			if filepath.VolumeName(local) == "" {
+				local = filepath.FromSlash(u.Path)
+			}
+		} else if !filepath.IsAbs(local) {
+			local = filepath.Join(filepath.Dir(path), local)
+		}
+		if _, err := os.Stat(local); err != nil {
+			fmt.Fprintf(errOut, "skipping image: %v\n", err)
+			continue
+		}
+
+		name := app.BulkImageName(row.Image)
+		if other, ok := images[name]; ok && other != local {
+			return nil, fmt.Errorf("images %s and %s have the same file name; rename one of them", other, local)
+		}
+		images[name] = local
+	}
+	return images, nil
+}
+
+func writeImportForm(mw *multipart.Writer, path string, images map[string]string) error {
+	if err := copyFormFile(mw, "items", filepath.Base(path), path); err != nil {
+		return err
+	}
+	for name, local := range images {
+		if err := copyFormFile(mw, "images", name, local); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func copyFormFile(mw *multipart.Writer, field, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := mw.CreateFormFile(field, name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
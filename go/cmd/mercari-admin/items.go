@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"mercari-build-training/app"
+)
+
+func (c *cli) printItems(items []*app.Item) error {
+	return c.print(map[string][]*app.Item{"items": items}, "ID\tNAME\tCATEGORY\tIMAGE", func(w io.Writer) {
+		for _, item := range items {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", item.ID, item.Name, item.Category, item.Image)
+		}
+	})
+}
+
+func (c *cli) printItem(item *app.Item) error {
+	return c.print(item, "ID\tNAME\tCATEGORY\tIMAGE", func(w io.Writer) {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", item.ID, item.Name, item.Category, item.Image)
+	})
+}
+
+func (c *cli) listItems(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	items, err := c.repo.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		items = []*app.Item{}
+	}
+	return c.printItems(items)
+}
+
+func (c *cli) showItem(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	item, err := c.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return c.printItem(item)
+}
+
+// itemFlags parses the fields of an item into item and returns the names of the flags set.
+func itemFlags(name string, item *app.Item, args []string) (map[string]bool, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&item.Name, "name", item.Name, "name of the item")
+	fs.StringVar(&item.Category, "category", item.Category, "category of the item, created if it does not exist")
+	fs.StringVar(&item.Image, "image", item.Image, "file name of the image in the image directory")
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("%w: unexpected %q", errUsage, fs.Arg(0))
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set, nil
+}
+
+func (c *cli) createItem(ctx context.Context, args []string) error {
+	item := &app.Item{}
+	if _, err := itemFlags("items create", item, args); err != nil {
+		return err
+	}
+	if item.Name == "" || item.Category == "" {
+		return fmt.Errorf("%w: -name and -category are required", errUsage)
+	}
+	if item.Image == "" {
+		// as POST /items refers to the placeholder of the category
+		item.Image = app.PlaceholderImage(c.placeholders, item.Category)
+	}
+	if err := c.repo.Insert(ctx, item); err != nil {
+		return err
+	}
+	return c.printItem(item)
+}
+
+func (c *cli) updateItem(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	item, err := c.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	set, err := itemFlags("items update", item, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(set) == 0 {
+		return fmt.Errorf("%w: nothing to change", errUsage)
+	}
+	if item.Name == "" || item.Category == "" || item.Image == "" {
+		return fmt.Errorf("%w: -name, -category and -image cannot be empty", errUsage)
+	}
+	if err := c.repo.Update(ctx, item); err != nil {
+		return err
+	}
+	return c.printItem(item)
+}
+
+func (c *cli) deleteItem(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	item, err := c.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := c.repo.Delete(ctx, id, item.Version); err != nil {
+		return err
+	}
+	return c.printMessage("deleted item %d", id)
+}
@@ -0,0 +1,180 @@
+// mercari-admin manages the items and categories in the database of the API server
+// without writing SQL.
+//
+//	go run ./cmd/mercari-admin items list
+//	go run ./cmd/mercari-admin -format json categories list
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mercari-build-training/app"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a subcommand such as "items list".
+type command struct {
+	name string
+	// args describes the arguments in the usage.
+	args string
+	help string
+	// remote commands call the API server instead of opening the database.
+	remote bool
+	run    func(c *cli, ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{name: "items list", help: "list all items", run: (*cli).listItems},
+	{name: "items show", args: "<id>", help: "show an item", run: (*cli).showItem},
+	{name: "items create", args: "-name <name> -category <category> [-image <image>]", help: "create an item", run: (*cli).createItem},
+	{name: "items update", args: "<id> [-name <name>] [-category <category>] [-image <image>]", help: "change an item", run: (*cli).updateItem},
+	{name: "items delete", args: "<id>", help: "delete an item", run: (*cli).deleteItem},
+	{name: "items export", args: "[-columns <columns>] [-category <category>] [-keyword <keyword>] [-updated-since <time>] [-o <file>] <csv|jsonl|parquet>", help: "export the items with their categories", run: (*cli).exportItems},
+	{name: "items import", args: "<file.csv|file.jsonl>", help: "add the items in the file with their images through the API server", remote: true, run: (*cli).importItems},
+	{name: "categories list", help: "list all categories with the number of items", run: (*cli).listCategories},
+	{name: "categories create", args: "<name>", help: "create a category", run: (*cli).createCategory},
+	{name: "categories rename", args: "<id> <name>", help: "rename a category", run: (*cli).renameCategory},
+	{name: "categories delete", args: "<id>", help: "delete a category without items", run: (*cli).deleteCategory},
+	{name: "categories merge", args: "<src-id> <dst-id>", help: "move the items of src to dst and delete src", run: (*cli).mergeCategories},
+}
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// cli runs the commands against the repository and writes the results to out.
+type cli struct {
+	repo app.ItemRepository
+	// placeholders are the images of items created without one, per category as in the API server.
+	placeholders map[string]string
+	// server is the URL of the API server for remote commands.
+	server string
+	out    io.Writer
+	// errOut receives warnings that do not fail the command.
+	errOut io.Writer
+	json   bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command line and returns the exit code: 1 on errors and 2 on invalid usage.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mercari-admin", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] <command> [args]\n\ncommands:\n", fs.Name())
+		for _, cmd := range commands {
+			fmt.Fprintf(fs.Output(), "  %s\n    \t%s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.help)
+		}
+		fmt.Fprintln(fs.Output(), "\nflags:")
+		fs.PrintDefaults()
+	}
+	dbPath := fs.String("db", app.DefaultDBPath, "path to the database")
+	format := fs.String("format", "table", "output format: table or json")
+	server := fs.String("server", "http://localhost:9000", "URL of the API server for items import")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(stderr, "invalid format %q: want table or json\n", *format)
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0) + " " + fs.Arg(1)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n", name)
+		fs.Usage()
+		return 2
+	}
+
+	ctx := app.WithActor(context.Background(), actor())
+	c := &cli{server: *server, out: stdout, errOut: stderr, json: *format == "json"}
+	if !cmd.remote {
+		placeholders, err := app.PlaceholderImages()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		c.placeholders = placeholders
+		db, err := app.OpenDB(ctx, *dbPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to open database: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+		c.repo = app.NewItemRepositoryWithDB(db)
+	}
+
+	err := cmd.run(c, ctx, fs.Args()[2:])
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "%v\nusage: %s %s\n", err, fs.Name(), strings.TrimSpace(cmd.name+" "+cmd.args))
+		return 2
+	case errors.Is(err, sql.ErrNoRows):
+		fmt.Fprintln(stderr, "not found")
+		return 1
+	case err != nil:
+		fmt.Fprintf(stderr, "%s: %v\n", cmd.name, err)
+		return 1
+	}
+	return 0
+}
+
+// actor returns who the changes are recorded as made by in the history of the items.
+func actor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return "mercari-admin:" + u.Username
+	}
+	return "mercari-admin"
+}
+
+// parseID parses an ID argument.
+func parseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%w: %q is not an ID", errUsage, s)
+	}
+	return id, nil
+}
+
+// print writes v as JSON, or the rows as a table with the header.
+func (c *cli) print(v any, header string, rows func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	rows(w)
+	return w.Flush()
+}
+
+// printMessage writes the result of a command that has nothing else to show.
+func (c *cli) printMessage(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if c.json {
+		return json.NewEncoder(c.out).Encode(map[string]string{"message": msg})
+	}
+	_, err := fmt.Fprintln(c.out, msg)
+	return err
+}
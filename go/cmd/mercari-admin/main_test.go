@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mercari-build-training/app"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// runCLI runs the command line against the database at dbPath and returns the exit code and the output.
+func runCLI(t *testing.T, dbPath string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"-db", dbPath}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// listItems returns the items in the database through items list.
+func listItems(t *testing.T, dbPath string) []*app.Item {
+	t.Helper()
+	code, out, errOut := runCLI(t, dbPath, "-format", "json", "items", "list")
+	if code != 0 {
+		t.Fatalf("failed to list items: %s", errOut)
+	}
+	var body struct {
+		Items []*app.Item `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(out), &body); err != nil {
+		t.Fatalf("failed to decode items: %v", err)
+	}
+	return body.Items
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		"ok: show item": {
+			args:     []string{"items", "show", "1"},
+			wantCode: 0,
+			wantOut:  "jacket",
+		},
+		"ok: list categories": {
+			args:     []string{"categories", "list"},
+			wantCode: 0,
+			wantOut:  "fashion",
+		},
+		"ng: item not found": {
+			args:     []string{"items", "show", "2"},
+			wantCode: 1,
+		},
+		"ng: invalid ID": {
+			args:     []string{"items", "show", "jacket"},
+			wantCode: 2,
+		},
+		"ng: unknown command": {
+			args:     []string{"items", "sell"},
+			wantCode: 2,
+		},
+		"ng: invalid format": {
+			args:     []string{"-format", "yaml", "items", "list"},
+			wantCode: 2,
+		},
+		"ng: create without category": {
+			args:     []string{"items", "create", "-name", "jacket"},
+			wantCode: 2,
+		},
+		"ng: category with items": {
+			args:     []string{"categories", "delete", "1"},
+			wantCode: 1,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dbPath := filepath.Join(t.TempDir(), "mercari.sqlite3")
+			if code, _, errOut := runCLI(t, dbPath, "items", "create", "-name", "jacket", "-category", "fashion"); code != 0 {
+				t.Fatalf("failed to create item: %s", errOut)
+			}
+
+			code, out, errOut := runCLI(t, dbPath, tt.args...)
+			if code != tt.wantCode {
+				t.Fatalf("unexpected exit code. want=%d, got=%d: %s", tt.wantCode, code, errOut)
+			}
+			if !strings.Contains(out, tt.wantOut) {
+				t.Errorf("unexpected output. want it to contain %q, got=%q: %s", tt.wantOut, out, errOut)
+			}
+		})
+	}
+}
+
+func TestItemsCommands(t *testing.T) {
+	t.Setenv("PLACEHOLDER_IMAGES", "phone=phone.jpg")
+
+	dbPath := filepath.Join(t.TempDir(), "mercari.sqlite3")
+	steps := [][]string{
+		{"items", "create", "-name", "jacket", "-category", "fashion"},
+		{"items", "create", "-name", "iphone", "-category", "phone"},
+		{"items", "create", "-name", "scarf", "-category", "fashion", "-image", "scarf.jpg"},
+		{"items", "update", "1", "-name", "blue jacket"},
+		{"items", "delete", "3"},
+		{"categories", "create", "outer"},
+		{"categories", "merge", "1", "3"},
+	}
+	for _, args := range steps {
+		if code, _, errOut := runCLI(t, dbPath, args...); code != 0 {
+			t.Fatalf("failed to run %s: %s", strings.Join(args, " "), errOut)
+		}
+	}
+
+	// items without an image refer to the placeholder of their category, as with POST /items
+	want := []*app.Item{
+		{ID: 1, Name: "blue jacket", Category: "outer", Image: "default.jpg"},
+		{ID: 2, Name: "iphone", Category: "phone", Image: "phone.jpg"},
+	}
+	if diff := cmp.Diff(want, listItems(t, dbPath)); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+}
+
+func TestExportItemsCommand(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "mercari.sqlite3")
+	if code, _, errOut := runCLI(t, dbPath, "items", "create", "-name", "jacket", "-category", "fashion"); code != 0 {
+		t.Fatalf("failed to create item: %s", errOut)
+	}
+
+	cases := map[string]struct {
+		args     []string
+		wantCode int
+		want     string
+	}{
+		"ok: csv": {
+			args:     []string{"-columns", "id,name,category", "csv"},
+			wantCode: 0,
+			want:     "id,name,category\n1,jacket,fashion\n",
+		},
+		"ok: jsonl filtered by category": {
+			args:     []string{"-columns", "name", "-category", "phone", "jsonl"},
+			wantCode: 0,
+			want:     "",
+		},
+		"ng: unknown format": {
+			args:     []string{"xml"},
+			wantCode: 2,
+		},
+		"ng: unknown column": {
+			args:     []string{"-columns", "price", "csv"},
+			wantCode: 2,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			output := filepath.Join(t.TempDir(), "items.out")
+			code, _, errOut := runCLI(t, dbPath, append([]string{"items", "export", "-o", output}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Fatalf("unexpected exit code. want=%d, got=%d: %s", tt.wantCode, code, errOut)
+			}
+			got, err := os.ReadFile(output)
+			if tt.wantCode != 0 {
+				if !os.IsNotExist(err) {
+					t.Errorf("expected no output file, got error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("unexpected export (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestImportItemsCommand(t *testing.T) {
+	t.Parallel()
+
+	// the server reports the rows whose image is not attached, as POST /items:bulk does
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/items:bulk" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, fh, err := r.FormFile("items")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		rows, err := app.ReadBulkItems(f, fh.Filename)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		attached := map[string]bool{}
+		for _, img := range r.MultipartForm.File["images"] {
+			attached[img.Filename] = true
+		}
+
+		var res app.BulkAddItemsResponse
+		for i, row := range rows {
+			result := app.BulkItemResult{Row: i + 1}
+			if row.Image != "" && !attached[app.BulkImageName(row.Image)] {
+				result.Errors = []app.FieldError{{Field: "image", Message: "is not attached"}}
+				res.Failed++
+			} else {
+				result.ID = res.Imported + 1
+				res.Imported++
+			}
+			res.Results = append(res.Results, result)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "jacket.jpg"), []byte("jacket"), 0o644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	path := filepath.Join(dir, "items.csv")
+	file := "name,category,image\njacket,fashion,jacket.jpg\nscarf,fashion,scarf.jpg\n"
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatalf("failed to write items file: %v", err)
+	}
+
+	// remote commands do not open the database
+	code, out, errOut := runCLI(t, filepath.Join(dir, "missing", "mercari.sqlite3"), "-server", srv.URL, "items", "import", path)
+	if code != 1 {
+		t.Fatalf("unexpected exit code. want=1, got=%d: %s", code, errOut)
+	}
+	if !strings.Contains(out, "image is not attached") {
+		t.Errorf("unexpected output. want it to contain %q, got=%q", "image is not attached", out)
+	}
+	for _, want := range []string{"skipping image", "1 of 2 rows failed"} {
+		if !strings.Contains(errOut, want) {
+			t.Errorf("unexpected error output. want it to contain %q, got=%q", want, errOut)
+		}
+	}
+}
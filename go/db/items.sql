@@ -1,12 +1,42 @@
-CREATE TABLE IF NOT EXISTS "items" (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    name TEXT NOT NULL,
-    category_id INTEGER,
-    image_name TEXT,
-    FOREIGN KEY (category_id) REFERENCES categories(id)
-);
-
-CREATE TABLE categories (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    name TEXT NOT NULL
-);
\ No newline at end of file
+CREATE TABLE IF NOT EXISTS "items" (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    category_id INTEGER,
+    image_name TEXT,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at DATETIME,
+    version INTEGER NOT NULL DEFAULT 1,
+    FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+CREATE TABLE categories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE images (
+    name TEXT PRIMARY KEY,
+    size INTEGER,
+    mime_type TEXT,
+    width INTEGER,
+    height INTEGER,
+    ref_count INTEGER NOT NULL DEFAULT 0 CHECK (ref_count >= 0),
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ahash INTEGER,
+    dhash INTEGER
+);
+
+CREATE TABLE item_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    request_id TEXT,
+    before_json TEXT,
+    after_json TEXT,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX item_events_item_id ON item_events (item_id, id);
+
+-- the version of the last migration in app/migrate.go, so that the server does not apply them again
+PRAGMA user_version = 7;
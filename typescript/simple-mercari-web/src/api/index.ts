@@ -1,4 +1,5 @@
 const SERVER_URL = import.meta.env.VITE_BACKEND_URL || 'http://127.0.0.1:9000';
+const API_URL = `${SERVER_URL}/v1`;
 
 export interface Item {
   id: number;
@@ -12,7 +13,7 @@ export interface ItemListResponse {
 }
 
 export const fetchItems = async (): Promise<ItemListResponse> => {
-  const response = await fetch(`${SERVER_URL}/items`, {
+  const response = await fetch(`${API_URL}/items`, {
     method: 'GET',
     mode: 'cors',
     headers: {
@@ -34,7 +35,7 @@ export const postItem = async (input: CreateItemInput): Promise<Response> => {
   data.append('name', input.name);
   data.append('category', input.category);
   data.append('image', input.image);
-  const response = await fetch(`${SERVER_URL}/items`, {
+  const response = await fetch(`${API_URL}/items`, {
     method: 'POST',
     mode: 'cors',
     body: data,
@@ -44,7 +45,7 @@ export const postItem = async (input: CreateItemInput): Promise<Response> => {
 
 
 export const searchItem = async (keyword: string): Promise<ItemListResponse> => {
-  const response = await fetch(`${SERVER_URL}/search?keyword=${encodeURIComponent(keyword)}`, {
+  const response = await fetch(`${API_URL}/search?keyword=${encodeURIComponent(keyword)}`, {
     method: 'GET',
     mode: 'cors',
     headers: {
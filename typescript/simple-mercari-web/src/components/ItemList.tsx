@@ -34,7 +34,7 @@ export const ItemList = ({ reload = false, onLoadCompleted, items, onCategoryCli
       {displayItems.length > 0 ? (
         displayItems.map((item) => {
           const imageUrl = item.image_name
-            ? `${import.meta.env.VITE_BACKEND_URL}/images/${item.image_name}`
+            ? `${import.meta.env.VITE_BACKEND_URL}/images/${item.image_name}?category=${encodeURIComponent(item.category)}`
             : PLACEHOLDER_IMAGE;
           return (
             <div key={item.id} className="ItemList">